@@ -19,7 +19,9 @@ package v1beta1
 import (
 	"github.com/hongqchen/redis-operator/pkg/util"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -43,8 +45,202 @@ type CustomRedisSpec struct {
 	RedisConfig map[string]string `json:"redisConfig"`
 
 	// +kubebuilder:default:=3
-	SentinelNum  *int32                            `json:"sentinelNum,omitempty"`
+	SentinelNum *int32 `json:"sentinelNum,omitempty"`
+	// VolumeConfig is the claim of the data volume of every redis and sentinel pod.
+	// Deprecated: use storage.data and storage.sentinel, which take precedence over it.
 	VolumeConfig *corev1.PersistentVolumeClaimSpec `json:"volumeConfig,omitempty"`
+
+	// Storage configures the volumes of redis data, append only files and sentinel state.
+	// Sizes can be increased when the storage class allows volume expansion, the progress is
+	// reported in status.volumes. Other fields can not be changed once the volumes are created.
+	Storage *StorageConfig `json:"storage,omitempty"`
+
+	// Memory derives maxmemory from the memory limit of the redis container, so redis evicts keys or
+	// rejects writes before the pod is OOM-killed. maxmemory and maxmemory-policy set in redisConfig
+	// take precedence.
+	Memory *MemoryPolicy `json:"memory,omitempty"`
+
+	// Modules are loaded by every redis pod at startup with loadmodule. The cluster is not reported
+	// Running until MODULE LIST on every up-to-date pod matches this list.
+	// +listType=map
+	// +listMapKey=name
+	Modules []ModuleConfig `json:"modules,omitempty"`
+
+	// IPFamilyPolicy is applied to all generated services.
+	IPFamilyPolicy *corev1.IPFamilyPolicyType `json:"ipFamilyPolicy,omitempty"`
+	// IPFamilies is applied to all generated services, the first family is the preferred one.
+	// Defaults to IPv4 when empty.
+	// +kubebuilder:validation:MaxItems=2
+	IPFamilies []corev1.IPFamily `json:"ipFamilies,omitempty"`
+
+	Service *ServiceConfig `json:"service,omitempty"`
+
+	// ReplicaReads controls which replicas receive the traffic of the slave service. Replicas that
+	// are loading, in a full sync, have a broken master link or lag too far behind are left out.
+	// When set, the replicas are re-checked every 10 seconds instead of on every resync.
+	ReplicaReads *ReplicaReadsConfig `json:"replicaReads,omitempty"`
+
+	// MaintenanceWindows restricts disruptive actions, such as rolling restarts of the redis and
+	// sentinel pods, to the given time windows. Disruptive actions are always allowed when empty.
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+
+	// AutoRestart lets the operator restart the redis pods running an outdated revision of the
+	// statefulset, one at a time and the master last, failing it over first in sentinel mode. Restarts
+	// are limited to maintenanceWindows when set. Disabled by default, pods pick up template changes
+	// when they are deleted.
+	AutoRestart bool `json:"autoRestart,omitempty"`
+
+	// Switchover promotes the given replica to master. Writes are paused on the current master
+	// until the replica has caught up, so no acknowledged write is lost. The result is recorded
+	// in status.switchover, the switchover runs once per target pod and request ID.
+	// Pausing only writes needs redis 6.2 or later, the switchover fails on older versions.
+	Switchover *SwitchoverSpec `json:"switchover,omitempty"`
+
+	// ReplicaOf turns the cluster into a read-only standby of an external redis master, e.g. during
+	// a migration. One pod replicates from the external master and the others from that pod, the
+	// sentinels stop monitoring the cluster so no pod is promoted locally. Remove the field to
+	// promote the cluster, the standby pod becomes the master.
+	ReplicaOf *ReplicaOfSpec `json:"replicaOf,omitempty"`
+}
+
+type ReplicaOfSpec struct {
+	// Host of the external redis master.
+	// +kubebuilder:validation:MinLength=1
+	Host string `json:"host"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default:=6379
+	Port int32 `json:"port,omitempty"`
+
+	// PasswordSecret is the key of a secret in the namespace of the CustomRedis holding the password
+	// of the external master.
+	PasswordSecret *corev1.SecretKeySelector `json:"passwordSecret,omitempty"`
+}
+
+type SwitchoverSpec struct {
+	// TargetPod is the name of the redis pod to promote.
+	// +kubebuilder:validation:MinLength=1
+	TargetPod string `json:"targetPod"`
+	// RequestID identifies the request, e.g. a timestamp. Set a new value to run a switchover to
+	// the same pod again, such as a retry after a failure.
+	RequestID string `json:"requestID,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Monday;Tuesday;Wednesday;Thursday;Friday;Saturday;Sunday
+type Weekday string
+
+type MaintenanceWindow struct {
+	// Days of the week the window opens on, every day when empty.
+	Days []Weekday `json:"days,omitempty"`
+
+	// Start of the window in UTC, formatted as HH:MM.
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// Duration of the window, e.g. 2h.
+	Duration metav1.Duration `json:"duration"`
+}
+
+type ServiceConfig struct {
+	// Type of the master, slave and sentinel services.
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +kubebuilder:default:=ClusterIP
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Annotations added to the master, slave, sentinel and per-pod services, e.g. for cloud load balancers.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// PerPodType creates a NodePort or LoadBalancer service for every redis and sentinel pod.
+	// Pods announce the external address of their own service (replica-announce-ip, sentinel announce-ip),
+	// so clients outside the cluster can follow sentinel redirects.
+	// +kubebuilder:validation:Enum=NodePort;LoadBalancer
+	PerPodType corev1.ServiceType `json:"perPodType,omitempty"`
+}
+
+type ModuleConfig struct {
+	// Name of the module as reported by MODULE LIST, e.g. search or ReJSON.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9][A-Za-z0-9_-]*$`
+	// +kubebuilder:validation:MaxLength=56
+	Name string `json:"name"`
+
+	// Path of the module shared library. Without image it is a path in the redis image, with image
+	// it is a path in the module image, and the file is copied into the redis pod by an init container.
+	// +kubebuilder:validation:MinLength=1
+	Path string `json:"path"`
+
+	// Image containing the module, it must provide cp.
+	Image string `json:"image,omitempty"`
+
+	// Args passed to the module after the path in the loadmodule directive.
+	Args []string `json:"args,omitempty"`
+}
+
+// maxLagBytes 未设置时的默认值，与 CRD 中的默认值保持一致
+const defaultMaxReplicaLagBytes = 1024 * 1024
+
+type ReplicaReadsConfig struct {
+	// MaxLagBytes is how many bytes of replication offset a replica may be behind its master and
+	// still serve reads through the slave service.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default:=1048576
+	MaxLagBytes *int64 `json:"maxLagBytes,omitempty"`
+}
+
+// maxMemoryPercent 未设置时的默认值，与 CRD 中的默认值保持一致
+const defaultMaxMemoryPercent = 70
+
+type MemoryPolicy struct {
+	// MaxMemoryPercent of the memory limit used as maxmemory. The rest is left for the copy-on-write
+	// pages of the BGSAVE and AOF rewrite forks, replication backlog and client buffers.
+	// +kubebuilder:validation:Minimum=10
+	// +kubebuilder:validation:Maximum=95
+	// +kubebuilder:default:=70
+	MaxMemoryPercent int32 `json:"maxMemoryPercent,omitempty"`
+
+	// EvictionPolicy is the maxmemory-policy, redis defaults to noeviction.
+	// +kubebuilder:validation:Enum=noeviction;allkeys-lru;allkeys-lfu;allkeys-random;volatile-lru;volatile-lfu;volatile-random;volatile-ttl
+	EvictionPolicy string `json:"evictionPolicy,omitempty"`
+}
+
+type StorageConfig struct {
+	// Data is mounted at redisConfig.dir and holds the RDB snapshots, and the append only files
+	// unless aof is set. An emptyDir is used when neither data nor volumeConfig is set.
+	Data *VolumeSpec `json:"data,omitempty"`
+
+	// Aof puts the append only files on a separate volume, e.g. on faster disks. It is mounted at
+	// the appenddirname directory inside redisConfig.dir, which requires redis 7 or later.
+	// appendonly is enabled unless it is set in redisConfig.
+	Aof *VolumeSpec `json:"aof,omitempty"`
+
+	// Sentinel persists the config file sentinel rewrites with the known replicas, sentinels and epoch.
+	// It only holds a few kilobytes. An emptyDir is used when neither sentinel nor volumeConfig is set.
+	Sentinel *VolumeSpec `json:"sentinel,omitempty"`
+}
+
+type VolumeSpec struct {
+	Size resource.Quantity `json:"size"`
+
+	// StorageClassName of the claims, the default storage class is used when empty.
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// +kubebuilder:default:={ReadWriteOnce}
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// claimSpec 转换为 PVC spec
+func (vs *VolumeSpec) claimSpec() *corev1.PersistentVolumeClaimSpec {
+	accessModes := vs.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	return &corev1.PersistentVolumeClaimSpec{
+		AccessModes:      accessModes,
+		StorageClassName: vs.StorageClassName,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: vs.Size},
+		},
+	}
 }
 
 type PodConfig struct {
@@ -59,6 +255,34 @@ type PodConfig struct {
 
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
 
+	// Env is added to the redis and sentinel containers.
+	Env     []corev1.EnvVar        `json:"env,omitempty"`
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// SecurityContext is applied to the redis and sentinel containers and the init containers
+	// created by the operator. Extra containers keep their own security context.
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+	// PodSecurityContext is the security context of the redis and sentinel pods.
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	ServiceAccountName string                        `json:"serviceAccountName,omitempty"`
+	ImagePullSecrets   []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// PodLabels are added to the redis and sentinel pods. Labels used by the operator to select
+	// the pods can not be overridden.
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+	// PodAnnotations are added to the redis and sentinel pods, e.g. for a vault agent injector.
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+
+	// ExtraInitContainers run after the init containers created by the operator.
+	ExtraInitContainers []corev1.Container `json:"extraInitContainers,omitempty"`
+	// ExtraContainers run as sidecars of the redis and sentinel containers, e.g. log shippers.
+	ExtraContainers []corev1.Container `json:"extraContainers,omitempty"`
+	// ExtraVolumes are added to the redis and sentinel pods, so extra containers can mount them.
+	ExtraVolumes []corev1.Volume `json:"extraVolumes,omitempty"`
+	// ExtraVolumeMounts are mounted into the redis and sentinel containers.
+	ExtraVolumeMounts []corev1.VolumeMount `json:"extraVolumeMounts,omitempty"`
+
 	// UpdateStrategy appv1.StatefulSetUpdateStrategy `json:"updateStrategy,omitempty"`
 }
 
@@ -68,6 +292,115 @@ type CustomRedisStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	Phase util.CustomRedisPhase `json:"phase"`
+
+	// Master is the name of the pod currently acting as master.
+	Master string `json:"master,omitempty"`
+	// ReadyReplicas is the number of ready redis pods.
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Switchover is the result of the last switchover requested through spec.switchover.
+	Switchover *SwitchoverStatus `json:"switchover,omitempty"`
+
+	// ObservedGeneration is the generation of the last successful reconcile.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Drift is the last change made to the generated resources outside the operator, it has been reverted.
+	// It is cleared by the first successful reconcile 10 minutes after it was detected.
+	Drift *DriftStatus `json:"drift,omitempty"`
+
+	// Volumes reports the size of every persistent volume of the redis and sentinel pods,
+	// including the progress of a resize.
+	// +listType=map
+	// +listMapKey=claimName
+	Volumes []VolumeStatus `json:"volumes,omitempty"`
+
+	// Standby reports the replication from the external master while spec.replicaOf is set.
+	Standby *StandbyStatus `json:"standby,omitempty"`
+
+	// Sentinel reports the consistency of the sentinels in sentinel mode.
+	Sentinel *SentinelStatus `json:"sentinel,omitempty"`
+}
+
+type SentinelStatus struct {
+	// Monitor is the master address monitored by the majority of the sentinels.
+	Monitor string `json:"monitor,omitempty"`
+	// QuorumReachable is the result of SENTINEL CKQUORUM, false when the sentinels could not
+	// authorize a failover.
+	QuorumReachable bool `json:"quorumReachable"`
+	// Inconsistencies found by the last check, e.g. a sentinel monitoring another master or not
+	// knowing a replica. Stale entries are forgotten with SENTINEL RESET.
+	Inconsistencies []string `json:"inconsistencies,omitempty"`
+}
+
+type StandbyStatus struct {
+	// Source is the address of the external master, host:port.
+	Source string `json:"source"`
+	// Pod is the redis pod replicating from the external master.
+	Pod string `json:"pod,omitempty"`
+	// LinkStatus is the master_link_status of the pod, up once the initial sync completed.
+	LinkStatus string `json:"linkStatus,omitempty"`
+}
+
+type DriftStatus struct {
+	// Resources lists the modified resources and fields, e.g. "Service/redis-master: spec.selector".
+	Resources    []string    `json:"resources"`
+	DetectedTime metav1.Time `json:"detectedTime"`
+}
+
+type VolumeResizePhase string
+
+const (
+	// VolumeResized means the capacity of the volume matches the requested size.
+	VolumeResized VolumeResizePhase = "Resized"
+	// VolumeResizePending means the claim has not been expanded yet.
+	VolumeResizePending VolumeResizePhase = "Pending"
+	// VolumeResizing means the claim has been expanded and the storage provider is resizing the volume.
+	VolumeResizing VolumeResizePhase = "Resizing"
+	// VolumeFileSystemResizePending means the volume has been resized and the file system is resized
+	// once the pod is restarted, or online by the kubelet if the driver supports it.
+	VolumeFileSystemResizePending VolumeResizePhase = "FileSystemResizePending"
+	// VolumeResizeUnsupported means the storage class of the claim does not allow volume expansion.
+	VolumeResizeUnsupported VolumeResizePhase = "Unsupported"
+)
+
+type VolumeStatus struct {
+	Pod       string `json:"pod"`
+	ClaimName string `json:"claimName"`
+	// Requested is the size requested in spec.storage or spec.volumeConfig.
+	Requested resource.Quantity `json:"requested"`
+	// Capacity is the actual size of the volume.
+	Capacity resource.Quantity `json:"capacity,omitempty"`
+	Phase    VolumeResizePhase `json:"phase"`
+	Message  string            `json:"message,omitempty"`
+}
+
+type SwitchoverPhase string
+
+const (
+	// InProgress sentinel is still failing over to the target pod, the result is checked again later
+	SwitchoverInProgress SwitchoverPhase = "InProgress"
+	SwitchoverSucceeded  SwitchoverPhase = "Succeeded"
+	SwitchoverFailed     SwitchoverPhase = "Failed"
+)
+
+type SwitchoverStatus struct {
+	TargetPod string `json:"targetPod"`
+	// RequestID is spec.switchover.requestID of the request.
+	RequestID string `json:"requestID,omitempty"`
+	// From is the pod that was master when the switchover started.
+	From  string          `json:"from,omitempty"`
+	Phase SwitchoverPhase `json:"phase"`
+	// Message explains why the switchover failed.
+	Message string `json:"message,omitempty"`
+	// ReplicaPriorities is the replica-priority of the other replicas, keyed by pod name. They are
+	// lowered to 0 while sentinel is still promoting the target and restored once it is done.
+	ReplicaPriorities map[string]string `json:"replicaPriorities,omitempty"`
+	// ObservedGeneration is the generation of the CustomRedis the switchover was run for.
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	CompletionTime     metav1.Time `json:"completionTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -103,6 +436,204 @@ func (cr *CustomRedis) SetDefaultStatus() bool {
 	return cr.Status.setDefault(cr)
 }
 
+// IsIPv6Preferred 首选地址族是否为 IPv6
+func (cr *CustomRedis) IsIPv6Preferred() bool {
+	return len(cr.Spec.IPFamilies) > 0 && cr.Spec.IPFamilies[0] == corev1.IPv6Protocol
+}
+
+// IsPerPodServiceEnabled 是否为每个 Pod 创建对外访问的 service
+func (cr *CustomRedis) IsPerPodServiceEnabled() bool {
+	return cr.Spec.Service != nil && cr.Spec.Service.PerPodType != ""
+}
+
+// IsIPv6Enabled 集群是否为 IPv6 单栈或双栈
+func (cr *CustomRedis) IsIPv6Enabled() bool {
+	for _, family := range cr.Spec.IPFamilies {
+		if family == corev1.IPv6Protocol {
+			return true
+		}
+	}
+	if cr.Spec.IPFamilyPolicy != nil && *cr.Spec.IPFamilyPolicy != corev1.IPFamilyPolicySingleStack {
+		return true
+	}
+	return false
+}
+
+// DataVolumeClaim redis 数据卷的 PVC spec，未配置时返回 nil
+func (cr *CustomRedis) DataVolumeClaim() *corev1.PersistentVolumeClaimSpec {
+	if cr.Spec.Storage != nil && cr.Spec.Storage.Data != nil {
+		return cr.Spec.Storage.Data.claimSpec()
+	}
+	return cr.Spec.VolumeConfig
+}
+
+// AofVolumeClaim AOF 独立数据卷的 PVC spec，未配置时返回 nil
+func (cr *CustomRedis) AofVolumeClaim() *corev1.PersistentVolumeClaimSpec {
+	if cr.Spec.Storage != nil && cr.Spec.Storage.Aof != nil {
+		return cr.Spec.Storage.Aof.claimSpec()
+	}
+	return nil
+}
+
+// SentinelVolumeClaim sentinel 数据卷的 PVC spec，未配置时返回 nil
+func (cr *CustomRedis) SentinelVolumeClaim() *corev1.PersistentVolumeClaimSpec {
+	if cr.Spec.Storage != nil && cr.Spec.Storage.Sentinel != nil {
+		return cr.Spec.Storage.Sentinel.claimSpec()
+	}
+	return cr.Spec.VolumeConfig
+}
+
+// MaxMemory 根据内存 limit 计算 maxmemory（字节），未开启内存策略、未设置 limit
+// 或 redisConfig 中已显式设置 maxmemory 时返回 false
+func (cr *CustomRedis) MaxMemory(limits corev1.ResourceList) (int64, bool) {
+	if cr.Spec.Memory == nil {
+		return 0, false
+	}
+	if _, exists := cr.Spec.RedisConfig["maxmemory"]; exists {
+		return 0, false
+	}
+	limit, ok := limits[corev1.ResourceMemory]
+	if !ok || limit.IsZero() {
+		return 0, false
+	}
+
+	percent := cr.Spec.Memory.MaxMemoryPercent
+	if percent == 0 {
+		percent = defaultMaxMemoryPercent
+	}
+	return limit.Value() * int64(percent) / 100, true
+}
+
+// EvictionPolicy 内存策略中的 maxmemory-policy，redisConfig 中已显式设置时返回空
+func (cr *CustomRedis) EvictionPolicy() string {
+	if cr.Spec.Memory == nil {
+		return ""
+	}
+	if _, exists := cr.Spec.RedisConfig["maxmemory-policy"]; exists {
+		return ""
+	}
+	return cr.Spec.Memory.EvictionPolicy
+}
+
+// MaxReplicaLag slave service 可以接受的 slave 最大复制延迟（字节）
+func (cr *CustomRedis) MaxReplicaLag() int64 {
+	if cr.Spec.ReplicaReads == nil || cr.Spec.ReplicaReads.MaxLagBytes == nil {
+		return defaultMaxReplicaLagBytes
+	}
+	return *cr.Spec.ReplicaReads.MaxLagBytes
+}
+
+// HasReplicaReads 是否设置了 spec.replicaReads，设置后需要及时根据复制延迟调整 slave service 的后端
+func (cr *CustomRedis) HasReplicaReads() bool {
+	return cr.Spec.ReplicaReads != nil
+}
+
+// IsStandby 是否作为外部 redis master 的备用集群
+func (cr *CustomRedis) IsStandby() bool {
+	return cr.Spec.ReplicaOf != nil
+}
+
+// Address 外部 master 的地址与端口，端口未设置时为 redis 默认端口
+func (r *ReplicaOfSpec) Address() (string, int32) {
+	if r.Port == 0 {
+		return r.Host, 6379
+	}
+	return r.Host, r.Port
+}
+
+// IsPaused 是否通过注解暂停 operator 对集群的修改
+func (cr *CustomRedis) IsPaused() bool {
+	return cr.Annotations[util.PausedAnnotation] == "true"
+}
+
+// IsSwitchoverRequested spec.switchover 是否尚未执行完成，每个目标 Pod 与 request ID 只执行一次，其他字段的修改不会再次执行
+func (cr *CustomRedis) IsSwitchoverRequested() bool {
+	if cr.Spec.Switchover == nil || cr.Spec.Switchover.TargetPod == "" {
+		return false
+	}
+
+	last := cr.Status.Switchover
+	return last == nil || last.TargetPod != cr.Spec.Switchover.TargetPod || last.RequestID != cr.Spec.Switchover.RequestID ||
+		last.Phase == SwitchoverInProgress
+}
+
+// IsVolumeResizing 是否有数据卷已提交扩容、尚未完成
+func (cr *CustomRedis) IsVolumeResizing() bool {
+	for _, volume := range cr.Status.Volumes {
+		if volume.Phase == VolumeResizing || volume.Phase == VolumeFileSystemResizePending {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDisruptionAllowed 当前是否允许滚动重启等破坏性操作
+func (cr *CustomRedis) IsDisruptionAllowed(now time.Time) bool {
+	if len(cr.Spec.MaintenanceWindows) == 0 {
+		return true
+	}
+
+	for _, window := range cr.Spec.MaintenanceWindows {
+		for _, start := range window.starts(now) {
+			if !now.Before(start) && now.Before(start.Add(window.Duration.Duration)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextMaintenanceWindowChange 距离下一次维护窗口开始或结束的时间，未配置维护窗口时返回 false
+func (cr *CustomRedis) NextMaintenanceWindowChange(now time.Time) (time.Duration, bool) {
+	var next time.Duration
+	found := false
+	for _, window := range cr.Spec.MaintenanceWindows {
+		for _, start := range window.starts(now) {
+			for _, change := range []time.Time{start, start.Add(window.Duration.Duration)} {
+				if !change.After(now) {
+					continue
+				}
+				if d := change.Sub(now); !found || d < next {
+					next = d
+					found = true
+				}
+			}
+		}
+	}
+	return next, found
+}
+
+// starts 返回 now 前后一周内，该窗口所有的开始时间
+func (mw *MaintenanceWindow) starts(now time.Time) []time.Time {
+	clock, err := time.Parse("15:04", mw.Start)
+	if err != nil {
+		return nil
+	}
+
+	now = now.UTC()
+	var starts []time.Time
+	for offset := -7; offset <= 7; offset++ {
+		day := now.AddDate(0, 0, offset)
+		if !mw.isOnDay(day.Weekday()) {
+			continue
+		}
+		starts = append(starts, time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, time.UTC))
+	}
+	return starts
+}
+
+func (mw *MaintenanceWindow) isOnDay(weekday time.Weekday) bool {
+	if len(mw.Days) == 0 {
+		return true
+	}
+	for _, day := range mw.Days {
+		if string(day) == weekday.String() {
+			return true
+		}
+	}
+	return false
+}
+
 //+kubebuilder:object:root=true
 
 // CustomRedisList contains a list of CustomRedis
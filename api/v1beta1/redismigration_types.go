@@ -0,0 +1,137 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:validation:Enum=Replication;Dump
+type MigrationMethod string
+
+const (
+	// MigrationReplication makes the destination a standby of the source with spec.replicaOf
+	MigrationReplication MigrationMethod = "Replication"
+	// MigrationDump copies the keys with SCAN, DUMP and RESTORE, for sources that refuse replicas
+	MigrationDump MigrationMethod = "Dump"
+)
+
+type MigrationPhase string
+
+const (
+	MigrationPending     MigrationPhase = "Pending"
+	MigrationSyncing     MigrationPhase = "Syncing"
+	MigrationSynced      MigrationPhase = "Synced"
+	MigrationCuttingOver MigrationPhase = "CuttingOver"
+	MigrationCompleted   MigrationPhase = "Completed"
+	MigrationFailed      MigrationPhase = "Failed"
+)
+
+// RedisMigrationSpec defines the desired state of RedisMigration
+type RedisMigrationSpec struct {
+	// Destination is the name of the CustomRedis in the same namespace receiving the data. Its master
+	// must not be written to before the cutover completes.
+	// +kubebuilder:validation:MinLength=1
+	Destination string `json:"destination"`
+
+	// Source is the redis instance the data is migrated from, the password secret is read from the
+	// namespace of the migration.
+	Source ReplicaOfSpec `json:"source"`
+
+	// Method is Replication unless the source refuses REPLICAOF, e.g. a managed redis. Dump copies the
+	// keys of db 0 in batches, writes made to the source during a pass may be missed until the next one.
+	// +kubebuilder:default:=Replication
+	Method MigrationMethod `json:"method,omitempty"`
+
+	// BatchSize is the number of keys copied per SCAN with the Dump method.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=1000
+	BatchSize int64 `json:"batchSize,omitempty"`
+
+	// Cutover detaches the destination from the source once it is synced. With Replication the
+	// destination is promoted once its lag is 0 on two consecutive observations, with Dump a last
+	// pass copies the keys written since the first one.
+	// Stop the writes to the source before setting it.
+	Cutover bool `json:"cutover,omitempty"`
+}
+
+// RedisMigrationStatus defines the observed state of RedisMigration
+type RedisMigrationStatus struct {
+	Phase   MigrationPhase `json:"phase,omitempty"`
+	Message string         `json:"message,omitempty"`
+
+	// SourceKeys and DestinationKeys are the number of keys reported by INFO keyspace.
+	SourceKeys      int64 `json:"sourceKeys,omitempty"`
+	DestinationKeys int64 `json:"destinationKeys,omitempty"`
+
+	// LinkStatus is the master_link_status of the standby pod with the Replication method.
+	LinkStatus string `json:"linkStatus,omitempty"`
+	// LagBytes is the replication offset the standby pod is behind the source.
+	LagBytes int64 `json:"lagBytes,omitempty"`
+
+	// CopiedKeys is the number of keys restored by the current pass of the Dump method.
+	CopiedKeys int64 `json:"copiedKeys,omitempty"`
+	// Cursor is the SCAN cursor the current pass resumes from.
+	Cursor string `json:"cursor,omitempty"`
+
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=rm
+// +kubebuilder:printcolumn:name="Destination",type=string,JSONPath=`.spec.destination`
+// +kubebuilder:printcolumn:name="Method",type=string,JSONPath=`.spec.method`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="SourceKeys",type=integer,JSONPath=`.status.sourceKeys`
+// +kubebuilder:printcolumn:name="DestinationKeys",type=integer,JSONPath=`.status.destinationKeys`
+
+// RedisMigration is the Schema for the redismigrations API
+type RedisMigration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RedisMigrationSpec   `json:"spec,omitempty"`
+	Status RedisMigrationStatus `json:"status,omitempty"`
+}
+
+// IsFinished 迁移是否已完成或失败，之后不再修改目标集群
+func (rm *RedisMigration) IsFinished() bool {
+	return rm.Status.Phase == MigrationCompleted || rm.Status.Phase == MigrationFailed
+}
+
+// BatchSizeOrDefault 每次 SCAN 复制的 key 数量，未设置时为 1000
+func (rm *RedisMigration) BatchSizeOrDefault() int64 {
+	if rm.Spec.BatchSize <= 0 {
+		return 1000
+	}
+	return rm.Spec.BatchSize
+}
+
+//+kubebuilder:object:root=true
+
+// RedisMigrationList contains a list of RedisMigration
+type RedisMigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RedisMigration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RedisMigration{}, &RedisMigrationList{})
+}
@@ -0,0 +1,67 @@
+// kubectl-credis inspects and operates CustomRedis clusters from outside the cluster,
+// redis is reached through port-forwards so the pods don't need to be exposed.
+// Install the binary in PATH and run it as `kubectl credis`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+)
+
+const usage = `Usage: kubectl credis <command> <name> [args] [flags]
+
+Commands:
+  topology <name>            show role, link status, offset and lag of every redis pod
+  switchover <name> <pod>    promote the pod to master and wait for the result
+  promote <name>             detach a standby from its external master and make it the primary
+  pause <name>               stop the operator from changing the cluster, status is still refreshed
+  resume <name>              let the operator reconcile the cluster again
+  backup <name> [pod]        run BGSAVE and wait for it, on a slave unless a pod is given
+  config <name> [pod]        print the redis.conf rendered for the pod, the master by default
+
+Flags:
+`
+
+func main() {
+	kubeconfig := flag.String("kubeconfig", "", "Path to the kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config.")
+	context := flag.String("context", "", "Name of the kubeconfig context to use.")
+	namespace := flag.String("n", "", "Namespace of the CustomRedis, defaults to the namespace of the current context.")
+	timeout := flag.Duration("timeout", 2*time.Minute, "How long switchover, promote and backup wait for completion.")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	// kubectl 插件的参数习惯将 flag 放在命令之后，flag 包遇到第一个非 flag 参数即停止解析
+	var args []string
+	rest := os.Args[1:]
+	for {
+		_ = flag.CommandLine.Parse(rest)
+		rest = flag.Args()
+		if len(rest) == 0 {
+			break
+		}
+		args = append(args, rest[0])
+		rest = rest[1:]
+	}
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	p, err := newPlugin(*kubeconfig, *context, *namespace, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	if err := p.run(args[0], args[1], args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
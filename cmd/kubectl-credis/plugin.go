@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	k8sclient "github.com/hongqchen/redis-operator/pkg/client/kubernetes"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	"github.com/pkg/errors"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+type plugin struct {
+	namespace  string
+	timeout    time.Duration
+	out        io.Writer
+	restConfig *rest.Config
+	clientset  kubernetes.Interface
+	cl         client.Client
+	k8sClient  k8sclient.Clienter
+	redis      redis.Clienter
+}
+
+func newPlugin(kubeconfig, kubecontext, namespace string, timeout time.Duration) (*plugin, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: kubecontext})
+
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load kubeconfig")
+	}
+	if namespace == "" {
+		if namespace, _, err = clientConfig.Namespace(); err != nil {
+			return nil, errors.Wrap(err, "failed to get namespace of the current context")
+		}
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	cl, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plugin{
+		namespace:  namespace,
+		timeout:    timeout,
+		out:        os.Stdout,
+		restConfig: restConfig,
+		clientset:  clientset,
+		cl:         cl,
+		k8sClient:  k8sclient.NewClient(cl),
+		redis:      redis.NewClient(),
+	}, nil
+}
+
+func (p *plugin) run(command, name string, args []string) error {
+	cRedis := &v1beta1.CustomRedis{}
+	if err := p.cl.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: p.namespace}, cRedis); err != nil {
+		return err
+	}
+
+	switch command {
+	case "topology":
+		return p.topology(cRedis)
+	case "switchover":
+		if len(args) != 1 {
+			return errors.New("switchover needs the name of the pod to promote")
+		}
+		return p.switchover(cRedis, args[0])
+	case "promote":
+		return p.promote(cRedis)
+	case "pause":
+		return p.setPaused(cRedis, true)
+	case "resume":
+		return p.setPaused(cRedis, false)
+	case "backup":
+		return p.backup(cRedis, args)
+	case "config":
+		return p.config(cRedis, args)
+	default:
+		return errors.Errorf("unknown command %q", command)
+	}
+}
+
+type topologyRow struct {
+	pod         string
+	ready       bool
+	replication *redis.ReplicationInfo
+	err         error
+}
+
+func (p *plugin) topology(cRedis *v1beta1.CustomRedis) error {
+	pods, err := p.redisPods(cRedis)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]topologyRow, 0, len(pods))
+	for i := range pods {
+		row := topologyRow{pod: pods[i].Name, ready: isPodReady(&pods[i])}
+		row.err = p.withRedis(cRedis, &pods[i], func(port int32, password string) error {
+			info, err := p.redis.GetInfo(localhost, port, password, "replication")
+			if err != nil {
+				return err
+			}
+			row.replication = &info.Replication
+			return nil
+		})
+		rows = append(rows, row)
+	}
+
+	fmt.Fprintf(p.out, "%s/%s %s, phase %s, master %s\n\n", cRedis.Namespace, cRedis.Name, cRedis.Spec.ClusterMode, cRedis.Status.Phase, cRedis.Status.Master)
+	return writeTopology(p.out, rows)
+}
+
+// writeTopology 以表格输出各节点的复制状态，只有一个 master 时计算 slave 落后的字节数
+func writeTopology(out io.Writer, rows []topologyRow) error {
+	var master *redis.ReplicationInfo
+	masters := 0
+	for _, row := range rows {
+		if row.replication != nil && row.replication.IsMaster() {
+			master = row.replication
+			masters++
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tREADY\tROLE\tMASTER\tLINK\tOFFSET\tLAG")
+	for _, row := range rows {
+		if row.replication == nil {
+			fmt.Fprintf(w, "%s\t%t\tunknown\t-\t-\t-\t%v\n", row.pod, row.ready, row.err)
+			continue
+		}
+
+		r := row.replication
+		if r.IsMaster() {
+			fmt.Fprintf(w, "%s\t%t\t%s\t-\t-\t%d\t-\n", row.pod, row.ready, r.Role, r.MasterReplOffset)
+			continue
+		}
+
+		lag := "-"
+		if masters == 1 {
+			lag = strconv.FormatInt(master.MasterReplOffset-r.ProcessedOffset(), 10)
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s:%d\t%s\t%d\t%s\n",
+			row.pod, row.ready, r.Role, r.MasterHost, r.MasterPort, r.MasterLinkStatus, r.ProcessedOffset(), lag)
+	}
+	return w.Flush()
+}
+
+// switchover 设置 spec.switchover 并等待 operator 记录结果
+// 每次请求使用新的 request ID，目标与上一次相同时 operator 同样会再次执行
+func (p *plugin) switchover(cRedis *v1beta1.CustomRedis, target string) error {
+	if cRedis.IsPaused() {
+		return errors.Errorf("%s is paused, resume it first", cRedis.Name)
+	}
+
+	ctx := context.TODO()
+	requestID := time.Now().UTC().Format(time.RFC3339Nano)
+	patch := client.MergeFrom(cRedis.DeepCopy())
+	cRedis.Spec.Switchover = &v1beta1.SwitchoverSpec{TargetPod: target, RequestID: requestID}
+	if err := p.cl.Patch(ctx, cRedis, patch); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "switchover to %s requested, waiting for the operator\n", target)
+
+	var result *v1beta1.SwitchoverStatus
+	err := wait.PollImmediate(time.Second, p.timeout, func() (bool, error) {
+		if err := p.cl.Get(ctx, client.ObjectKeyFromObject(cRedis), cRedis); err != nil {
+			return false, err
+		}
+		result = cRedis.Status.Switchover
+		return result != nil && result.TargetPod == target && result.RequestID == requestID &&
+			result.Phase != v1beta1.SwitchoverInProgress, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "switchover did not complete")
+	}
+
+	if result.Phase != v1beta1.SwitchoverSucceeded {
+		return errors.Errorf("switchover from %s to %s failed: %s", result.From, target, result.Message)
+	}
+	fmt.Fprintf(p.out, "switchover from %s to %s succeeded\n", result.From, target)
+	return nil
+}
+
+// promote 删除 spec.replicaOf，等待 operator 将备用 Pod 提升为 master
+func (p *plugin) promote(cRedis *v1beta1.CustomRedis) error {
+	if !cRedis.IsStandby() {
+		return errors.Errorf("%s is not a standby, spec.replicaOf is not set", cRedis.Name)
+	}
+	if cRedis.IsPaused() {
+		return errors.Errorf("%s is paused, resume it first", cRedis.Name)
+	}
+
+	ctx := context.TODO()
+	source, _ := cRedis.Spec.ReplicaOf.Address()
+	patch := client.MergeFrom(cRedis.DeepCopy())
+	cRedis.Spec.ReplicaOf = nil
+	if err := p.cl.Patch(ctx, cRedis, patch); err != nil {
+		return err
+	}
+	generation := cRedis.Generation
+	fmt.Fprintf(p.out, "detaching %s from %s, waiting for the operator\n", cRedis.Name, source)
+
+	err := wait.PollImmediate(time.Second, p.timeout, func() (bool, error) {
+		if err := p.cl.Get(ctx, client.ObjectKeyFromObject(cRedis), cRedis); err != nil {
+			return false, err
+		}
+		status := cRedis.Status
+		return status.ObservedGeneration >= generation && status.Standby == nil && status.Master != "", nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "promotion did not complete")
+	}
+	fmt.Fprintf(p.out, "%s promoted, master %s\n", cRedis.Name, cRedis.Status.Master)
+	return nil
+}
+
+func (p *plugin) setPaused(cRedis *v1beta1.CustomRedis, paused bool) error {
+	patch := client.MergeFrom(cRedis.DeepCopy())
+	if paused {
+		if cRedis.Annotations == nil {
+			cRedis.Annotations = make(map[string]string, 1)
+		}
+		cRedis.Annotations[util.PausedAnnotation] = "true"
+	} else {
+		delete(cRedis.Annotations, util.PausedAnnotation)
+	}
+	if err := p.cl.Patch(context.TODO(), cRedis, patch); err != nil {
+		return err
+	}
+
+	if paused {
+		fmt.Fprintf(p.out, "customredis/%s paused\n", cRedis.Name)
+	} else {
+		fmt.Fprintf(p.out, "customredis/%s resumed\n", cRedis.Name)
+	}
+	return nil
+}
+
+// backup 默认在 slave 上执行 BGSAVE，避免 fork 影响 master
+func (p *plugin) backup(cRedis *v1beta1.CustomRedis, args []string) error {
+	pod, err := p.targetPod(cRedis, args, redis.RoleSlave)
+	if err != nil {
+		return err
+	}
+
+	return p.withRedis(cRedis, pod, func(port int32, password string) error {
+		info, err := p.redis.GetInfo(localhost, port, password, "persistence")
+		if err != nil {
+			return err
+		}
+		lastSave := info.Persistence.RDBLastSaveTime
+
+		if err := p.redis.BackgroundSave(localhost, port, password); err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "background save started on %s\n", pod.Name)
+
+		err = wait.PollImmediate(time.Second, p.timeout, func() (bool, error) {
+			info, err = p.redis.GetInfo(localhost, port, password, "persistence")
+			if err != nil {
+				return false, err
+			}
+			return !info.Persistence.RDBBgsaveInProgress && info.Persistence.RDBLastSaveTime > lastSave, nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "background save did not complete")
+		}
+		if info.Persistence.RDBLastBgsaveStatus != "ok" {
+			return errors.Errorf("background save on %s failed, status %s", pod.Name, info.Persistence.RDBLastBgsaveStatus)
+		}
+
+		fmt.Fprintf(p.out, "background save on %s completed at %s\n", pod.Name, time.Unix(info.Persistence.RDBLastSaveTime, 0).Format(time.RFC3339))
+		return nil
+	})
+}
+
+// config 输出 init container 渲染后 redis 实际加载的配置文件
+func (p *plugin) config(cRedis *v1beta1.CustomRedis, args []string) error {
+	pod, err := p.targetPod(cRedis, args, redis.RoleMaster)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("%s/%s", util.RedisConfigWritablePath, util.RedisConfigFileName)
+	return p.exec(pod, cRedis.Name, []string{"cat", path})
+}
+
+// redisPods 返回 redis statefulset 的所有 Pod，按名称排序
+func (p *plugin) redisPods(cRedis *v1beta1.CustomRedis) ([]corev1.Pod, error) {
+	sts, err := p.k8sClient.GetStatefulset(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := p.k8sClient.GetPods(cRedis.Namespace, sts.Spec.Selector.MatchLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].Name < pods.Items[j].Name
+	})
+	return pods.Items, nil
+}
+
+// targetPod 返回参数指定的 Pod，未指定时返回第一个角色为 role 的就绪 Pod
+func (p *plugin) targetPod(cRedis *v1beta1.CustomRedis, args []string, role string) (*corev1.Pod, error) {
+	if len(args) > 0 {
+		return p.k8sClient.GetPod(args[0], cRedis.Namespace)
+	}
+
+	pods, err := p.redisPods(cRedis)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods {
+		if isPodReady(&pods[i]) && pods[i].Labels["redis.hongqchen/role"] == role {
+			return &pods[i], nil
+		}
+	}
+	return nil, errors.Errorf("no ready %s pod found", role)
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"strings"
+	"testing"
+)
+
+func TestWriteTopology(t *testing.T) {
+	rows := []topologyRow{
+		{pod: "redis-0", ready: true, replication: &redis.ReplicationInfo{Role: redis.RoleMaster, MasterReplOffset: 1200}},
+		{pod: "redis-1", ready: true, replication: &redis.ReplicationInfo{
+			Role: redis.RoleSlave, MasterHost: "redis-0.redis-headless", MasterPort: 6379,
+			MasterLinkStatus: redis.MasterLinkUp, SlaveReplOffset: 1000,
+		}},
+		{pod: "redis-2", ready: false, err: errors.New("connection refused")},
+	}
+
+	var out bytes.Buffer
+	if err := writeTopology(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"POD      READY  ROLE     MASTER                       LINK  OFFSET  LAG",
+		"redis-0  true   master   -                            -     1200    -",
+		"redis-1  true   slave    redis-0.redis-headless:6379  up    1000    200",
+		"redis-2  false  unknown  -                            -     -       connection refused",
+	}
+	got := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("writeTopology() =\n%s", out.String())
+	}
+	for i := range want {
+		if strings.TrimRight(got[i], " ") != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/pkg/errors"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const localhost = "127.0.0.1"
+
+// withRedis 将 Pod 的 redis 端口转发到本地随机端口，fn 通过 localhost 访问 redis
+func (p *plugin) withRedis(cRedis *v1beta1.CustomRedis, pod *corev1.Pod, fn func(port int32, password string) error) error {
+	redisPort, err := strconv.Atoi(cRedis.Spec.RedisConfig["port"])
+	if err != nil {
+		return errors.New("value of port is invalid")
+	}
+
+	transport, upgrader, err := spdy.RoundTripperFor(p.restConfig)
+	if err != nil {
+		return err
+	}
+	req := p.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	stop := make(chan struct{})
+	ready := make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(dialer, []string{localhost}, []string{fmt.Sprintf("0:%d", redisPort)}, stop, ready, io.Discard, os.Stderr)
+	if err != nil {
+		return err
+	}
+	defer close(stop)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- forwarder.ForwardPorts()
+	}()
+	select {
+	case <-ready:
+	case err := <-errCh:
+		return errors.Wrapf(err, "failed to forward port of pod %s", pod.Name)
+	}
+
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		return err
+	}
+	return fn(int32(ports[0].Local), cRedis.Spec.RedisConfig["requirepass"])
+}
+
+// exec 在 Pod 的容器中执行命令，输出写入 p.out
+func (p *plugin) exec(pod *corev1.Pod, container string, command []string) error {
+	req := p.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(p.restConfig, http.MethodPost, req.URL())
+	if err != nil {
+		return err
+	}
+	return executor.Stream(remotecommand.StreamOptions{Stdout: p.out, Stderr: os.Stderr})
+}
@@ -20,14 +20,21 @@ import (
 	"context"
 	"fmt"
 	"github.com/go-logr/logr"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
 	"github.com/hongqchen/redis-operator/pkg/controller"
 	"github.com/hongqchen/redis-operator/pkg/util"
 	appv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+	"time"
 
 	redisv1beta1 "github.com/hongqchen/redis-operator/api/v1beta1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -35,21 +42,43 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	volumeResizePollInterval = 30 * time.Second
+	// 每次 reconcile 最多重置一个 sentinel，间隔足够 sentinel 重新发现彼此
+	sentinelPollInterval = 30 * time.Second
+	// slave 的复制延迟变化不会触发 reconcile，需定期刷新 read-eligible label
+	replicaReadsPollInterval = 10 * time.Second
+	// 漂移还原后在 status.drift 中保留的时间，期间未再发生漂移则清除
+	driftRetention = 10 * time.Minute
+)
+
 // CustomRedisReconciler reconciles a CustomRedis object
 type CustomRedisReconciler struct {
 	client.Client
 	Logger logr.Logger
 	Scheme *runtime.Scheme
+	// RedisClient 与 redis/sentinel 节点交互，测试时可替换为 fake 实现
+	RedisClient redis.Clienter
+	// ResyncPeriod 集群正常时定期 reconcile 的间隔，用于发现 watch 无法感知的变化，为 0 时不定期 reconcile
+	ResyncPeriod time.Duration
+	// Backoff 记录每个 CustomRedis 的连续失败次数，决定重试间隔
+	Backoff  *util.Backoff
+	Recorder record.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=redis.hongqchen,resources=customredis,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=redis.hongqchen,resources=customredis/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=redis.hongqchen,resources=customredis/finalizers,verbs=update
 // +kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups="apps",resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="apps",resources=deployments,verbs=get;delete
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="storage.k8s.io",resources=storageclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -67,12 +96,25 @@ func (r *CustomRedisReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	cRedis := &redisv1beta1.CustomRedis{}
 	if err := r.Get(ctx, namespacedName, cRedis); err != nil {
+		if apierror.IsNotFound(err) {
+			r.Backoff.Forget(namespacedName.String())
+			forgetMetrics(namespacedName.Namespace, namespacedName.Name)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 	logger.Info("Reconciling")
+	storedStatus := cRedis.Status.DeepCopy()
 
 	logger.V(3).Info(fmt.Sprintf("Instance info: %+v", cRedis))
 
+	redisHandler := controller.NewRedisHandler(r.Client, r.RedisClient, logger)
+
+	// 暂停期间跳过所有修改集群的操作，仅刷新 status
+	if cRedis.IsPaused() {
+		logger.Info("Reconcile paused, skipping all mutating steps")
+		return ctrl.Result{RequeueAfter: time.Minute}, r.updateStatus(ctx, logger, redisHandler, cRedis, storedStatus)
+	}
+
 	// 首次创建，更新 status 为 creating
 	if cRedis.SetDefaultStatus() {
 		logger.V(2).Info("Setting status")
@@ -81,28 +123,122 @@ func (r *CustomRedisReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
-	redisHandler := controller.NewRedisHandler(r.Client, logger)
-	if requeue := redisHandler.Sync(cRedis); requeue > 0 {
-		return ctrl.Result{RequeueAfter: requeue}, nil
+	syncErr := redisHandler.Sync(cRedis)
+	category, requeue := util.ErrorHandle(logger, r.Backoff, namespacedName.String(), syncErr)
+	if syncErr == nil {
+		logger.V(2).Info("Setting status to running")
+		cRedis.Status.Phase = util.CustomRedisRunning
+		cRedis.Status.ObservedGeneration = cRedis.Generation
+		// 本次 reconcile 检测到的漂移 detectedTime 为当前时间，不会被清除
+		if drift := cRedis.Status.Drift; drift != nil && time.Since(drift.DetectedTime.Time) >= driftRetention {
+			cRedis.Status.Drift = nil
+		}
+	}
+	r.reportSyncResult(cRedis, category, syncErr)
+	if err := r.updateStatus(ctx, logger, redisHandler, cRedis, storedStatus); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	logger.V(2).Info("Setting status to running")
-	if cRedis.Status.Phase != util.CustomRedisRunning {
-		cRedis.Status.Phase = util.CustomRedisRunning
-		if err := r.Status().Update(ctx, cRedis); err != nil {
-			return ctrl.Result{}, err
+	// PVC 扩容的进度不会触发 reconcile，扩容期间定期刷新 status.volumes
+	if cRedis.IsVolumeResizing() && (requeue == 0 || volumeResizePollInterval < requeue) {
+		requeue = volumeResizePollInterval
+	}
+	// sentinel 不一致时定期检查，逐个重置记录了已不存在条目的 sentinel
+	if sentinel := cRedis.Status.Sentinel; sentinel != nil && len(sentinel.Inconsistencies) > 0 && (requeue == 0 || sentinelPollInterval < requeue) {
+		requeue = sentinelPollInterval
+	}
+	// slave 追上或落后 master 时及时调整 slave service 的后端
+	if cRedis.HasReplicaReads() && (requeue == 0 || replicaReadsPollInterval < requeue) {
+		requeue = replicaReadsPollInterval
+	}
+	// 到期后清除 status.drift
+	if drift := cRedis.Status.Drift; drift != nil {
+		if remaining := driftRetention - time.Since(drift.DetectedTime.Time); remaining > 0 && (requeue == 0 || remaining < requeue) {
+			requeue = remaining
 		}
 	}
+	// 维护窗口开启或关闭时重新 reconcile，放行或阻止滚动更新
+	if next, ok := cRedis.NextMaintenanceWindowChange(time.Now()); ok && (requeue == 0 || next < requeue) {
+		requeue = next
+	}
+	if requeue > 0 {
+		return ctrl.Result{RequeueAfter: requeue}, nil
+	}
 
 	logger.Info("Reconcile complete")
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// reportSyncResult 根据 reconcile 结果设置 condition、记录事件与指标，category 为空表示成功
+func (r *CustomRedisReconciler) reportSyncResult(cRedis *redisv1beta1.CustomRedis, category util.ErrorCategory, err error) {
+	recordMetrics(cRedis.Namespace, cRedis.Name, category)
+
+	reconciledCondition := metav1.Condition{
+		Type:               util.ConditionReconciled,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Succeeded",
+		ObservedGeneration: cRedis.Generation,
+	}
+	needsHumanCondition := metav1.Condition{
+		Type:               util.ConditionNeedsHuman,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NoActionRequired",
+		ObservedGeneration: cRedis.Generation,
+	}
+	if err != nil {
+		reconciledCondition.Status = metav1.ConditionFalse
+		reconciledCondition.Reason = string(category)
+		reconciledCondition.Message = err.Error()
+	}
+
+	switch category {
+	case util.ErrorNeedsHuman:
+		needsHumanCondition.Status = metav1.ConditionTrue
+		needsHumanCondition.Reason = "ManualRepairRequired"
+		needsHumanCondition.Message = err.Error()
+		r.Recorder.Event(cRedis, corev1.EventTypeWarning, string(category), err.Error())
+	case util.ErrorTransient:
+		r.Recorder.Event(cRedis, corev1.EventTypeWarning, "ReconcileFailed", err.Error())
+	}
+	meta.SetStatusCondition(&cRedis.Status.Conditions, reconciledCondition)
+	meta.SetStatusCondition(&cRedis.Status.Conditions, needsHumanCondition)
+}
+
+// updateStatus 刷新观测到的集群状态与暂停状态，与 reconcile 开始时的 status 不一致时更新
+func (r *CustomRedisReconciler) updateStatus(ctx context.Context, logger logr.Logger, redisHandler *controller.RedisHandler, cRedis *redisv1beta1.CustomRedis, storedStatus *redisv1beta1.CustomRedisStatus) error {
+	// 观测失败不影响 reconcile，保留上一次的结果
+	if err := redisHandler.Observe(cRedis); err != nil {
+		logger.Error(err, "Failed to observe cluster status")
+	}
+
+	pausedCondition := metav1.Condition{
+		Type:               util.ConditionPaused,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Reconciling",
+		ObservedGeneration: cRedis.Generation,
+	}
+	if cRedis.IsPaused() {
+		pausedCondition.Status = metav1.ConditionTrue
+		pausedCondition.Reason = "PausedByAnnotation"
+		pausedCondition.Message = fmt.Sprintf("annotation %s is set, the cluster is not modified", util.PausedAnnotation)
+	}
+	meta.SetStatusCondition(&cRedis.Status.Conditions, pausedCondition)
+
+	if equality.Semantic.DeepEqual(storedStatus, &cRedis.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, cRedis)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *CustomRedisReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&redisv1beta1.CustomRedis{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// 注解变化（如暂停）同样触发 reconcile
+		For(&redisv1beta1.CustomRedis{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
 		Owns(&appv1.StatefulSet{}, builder.WithPredicates(util.AnnotationsOrGenerationChanged{})).
+		// 生成的资源被外部修改时触发 reconcile，将其还原
+		Owns(&corev1.ConfigMap{}, builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		Owns(&corev1.Service{}, builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
 		Watches(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForOwner{
 			OwnerType:    &redisv1beta1.CustomRedis{},
 			IsController: false,
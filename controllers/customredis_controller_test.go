@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	redisv1beta1 "github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/util"
+)
+
+const (
+	// reconciles waiting for pods are requeued after 20s
+	timeout  = 90 * time.Second
+	interval = time.Second
+)
+
+var _ = Describe("CustomRedis controller", func() {
+	ctx := context.Background()
+
+	Context("master-slave mode", func() {
+		const name = "ms"
+		key := types.NamespacedName{Name: name, Namespace: "default"}
+
+		It("creates the resources and follows the cluster through creating, running and scaling", func() {
+			cRedis := newCustomRedis(name, redisv1beta1.MasterSlave, 3)
+			Expect(k8sClient.Create(ctx, cRedis)).To(Succeed())
+
+			By("moving to creating")
+			Eventually(func() util.CustomRedisPhase {
+				return getPhase(ctx, key)
+			}, timeout, interval).Should(Equal(util.CustomRedisCreating))
+
+			By("generating the statefulset, configmap and services")
+			sts := &appv1.StatefulSet{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, key, sts)
+			}, timeout, interval).Should(Succeed())
+			Expect(*sts.Spec.Replicas).To(Equal(int32(3)))
+			Expect(sts.Spec.ServiceName).To(Equal(name + "-headless"))
+			expectControlledBy(sts, cRedis)
+
+			cm := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, key, cm)).To(Succeed())
+			Expect(cm.Data[util.RedisConfigFileName]).To(ContainSubstring("port 6379"))
+			expectControlledBy(cm, cRedis)
+
+			By("starting the redis pods")
+			createReadyPods(ctx, sts, 3, "10.1.0")
+
+			for _, svcName := range []string{name + "-master", name + "-slave", name + "-headless"} {
+				svc := &corev1.Service{}
+				Eventually(func() error {
+					return k8sClient.Get(ctx, types.NamespacedName{Name: svcName, Namespace: key.Namespace}, svc)
+				}, timeout, interval).Should(Succeed())
+				expectControlledBy(svc, cRedis)
+			}
+
+			By("moving to running with one master and two slaves")
+			Eventually(func() util.CustomRedisPhase {
+				return getPhase(ctx, key)
+			}, timeout, interval).Should(Equal(util.CustomRedisRunning))
+			masterHost := expectTopology(ctx, sts, 3, cRedis)
+
+			By("scaling up, the new pod joins the master")
+			Expect(k8sClient.Get(ctx, key, cRedis)).To(Succeed())
+			replicas := int32(4)
+			cRedis.Spec.Replicas = &replicas
+			Expect(k8sClient.Update(ctx, cRedis)).To(Succeed())
+
+			Eventually(func() util.CustomRedisPhase {
+				return getPhase(ctx, key)
+			}, timeout, interval).Should(Equal(util.CustomRedisScaling))
+			Eventually(func() int32 {
+				_ = k8sClient.Get(ctx, key, sts)
+				return *sts.Spec.Replicas
+			}, timeout, interval).Should(Equal(int32(4)))
+
+			createReadyPods(ctx, sts, 4, "10.1.0")
+			Eventually(func() util.CustomRedisPhase {
+				return getPhase(ctx, key)
+			}, timeout, interval).Should(Equal(util.CustomRedisRunning))
+			Expect(expectTopology(ctx, sts, 4, cRedis)).To(Equal(masterHost))
+		})
+	})
+
+	Context("sentinel mode", func() {
+		const name = "sen"
+		key := types.NamespacedName{Name: name, Namespace: "default"}
+		sentinelKey := types.NamespacedName{Name: name + "-sentinel", Namespace: "default"}
+
+		It("runs sentinels as a statefulset monitoring the master", func() {
+			By("leaving a sentinel deployment from an older version behind")
+			legacy := newLegacySentinelDeployment(sentinelKey)
+			Expect(k8sClient.Create(ctx, legacy)).To(Succeed())
+
+			cRedis := newCustomRedis(name, redisv1beta1.Sentinel, 3)
+			Expect(k8sClient.Create(ctx, cRedis)).To(Succeed())
+
+			sts := &appv1.StatefulSet{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, key, sts)
+			}, timeout, interval).Should(Succeed())
+			sentinelCm := &corev1.ConfigMap{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, sentinelKey, sentinelCm)
+			}, timeout, interval).Should(Succeed())
+			Expect(sentinelCm.Data[util.SentinelConfigFileName]).To(ContainSubstring("sentinel monitor mymaster"))
+			expectControlledBy(sentinelCm, cRedis)
+
+			By("starting the redis pods")
+			createReadyPods(ctx, sts, 3, "10.2.0")
+
+			By("generating the sentinel statefulset")
+			sentinelSts := &appv1.StatefulSet{}
+			Eventually(func() error {
+				return k8sClient.Get(ctx, sentinelKey, sentinelSts)
+			}, timeout, interval).Should(Succeed())
+			Expect(sentinelSts.Spec.ServiceName).To(Equal(name + "-sentinel-headless"))
+			expectControlledBy(sentinelSts, cRedis)
+
+			By("starting the sentinel pods")
+			createReadyPods(ctx, sentinelSts, 3, "10.2.1")
+
+			Eventually(func() util.CustomRedisPhase {
+				return getPhase(ctx, key)
+			}, timeout, interval).Should(Equal(util.CustomRedisRunning))
+			masterHost := expectTopology(ctx, sts, 3, cRedis)
+
+			By("removing the legacy sentinel deployment")
+			Eventually(func() bool {
+				return apierror.IsNotFound(k8sClient.Get(ctx, sentinelKey, &appv1.Deployment{}))
+			}, timeout, interval).Should(BeTrue())
+
+			By("pointing every sentinel at the master")
+			for i := 0; i < 3; i++ {
+				sentinel, exists := redisClient.Sentinel(fmt.Sprintf("10.2.1.%d", i+1))
+				Expect(exists).To(BeTrue())
+				Expect(sentinel.MonitorHost).To(Equal(masterHost))
+				Expect(sentinel.MonitorPort).To(Equal("6379"))
+			}
+
+			pod := &corev1.Pod{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name + "-sentinel-0", Namespace: key.Namespace}, pod)).To(Succeed())
+			Expect(pod.Labels).To(HaveKeyWithValue("redis.hongqchen/role", "sentinel"))
+			expectOwnedBy(pod, cRedis)
+		})
+	})
+})
+
+func newCustomRedis(name string, mode redisv1beta1.ClusterMode, replicas int32) *redisv1beta1.CustomRedis {
+	sentinelNum := int32(3)
+	return &redisv1beta1.CustomRedis{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: redisv1beta1.CustomRedisSpec{
+			Replicas:    &replicas,
+			ClusterMode: mode,
+			SentinelNum: &sentinelNum,
+			RedisConfig: map[string]string{"port": "6379"},
+			Templates: redisv1beta1.PodConfig{
+				InitImage: "busybox:1.28",
+				Image:     "redis:7.0",
+			},
+		},
+	}
+}
+
+func newLegacySentinelDeployment(key types.NamespacedName) *appv1.Deployment {
+	labels := map[string]string{"app": key.Name}
+	return &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+		Spec: appv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "sentinel", Image: "redis:7.0"}},
+				},
+			},
+		},
+	}
+}
+
+// createReadyPods plays the statefulset controller and the kubelet, which envtest does not run:
+// pods up to count are created with the statefulset labels, then reported running and ready.
+// Every pod is registered in the fake redis backend by its IP and its stable DNS name.
+func createReadyPods(ctx context.Context, sts *appv1.StatefulSet, count int, ipPrefix string) {
+	for i := 0; i < count; i++ {
+		podName := fmt.Sprintf("%s-%d", sts.Name, i)
+		podKey := types.NamespacedName{Name: podName, Namespace: sts.Namespace}
+		if err := k8sClient.Get(ctx, podKey, &corev1.Pod{}); err == nil {
+			continue
+		}
+
+		labels := make(map[string]string, len(sts.Spec.Selector.MatchLabels))
+		for k, v := range sts.Spec.Selector.MatchLabels {
+			labels[k] = v
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: sts.Namespace, Labels: labels},
+			Spec: corev1.PodSpec{
+				Hostname:   podName,
+				Subdomain:  sts.Spec.ServiceName,
+				Containers: []corev1.Container{{Name: "redis", Image: "redis:7.0"}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+
+		ip := fmt.Sprintf("%s.%d", ipPrefix, i+1)
+		pod.Status = corev1.PodStatus{
+			Phase:  corev1.PodRunning,
+			PodIP:  ip,
+			PodIPs: []corev1.PodIP{{IP: ip}},
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodInitialized, Status: corev1.ConditionTrue},
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+		}
+		Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+
+		if sts.Spec.Template.Labels["redis.hongqchen/component"] == util.SentinelResourceSuffix {
+			redisClient.AddSentinel(ip)
+			continue
+		}
+		fqdn := util.GetPodFQDN(podName, sts.Spec.ServiceName, sts.Namespace)
+		redisClient.AddNode(ip, fqdn)
+		// replica-announce-ip is written into the config file by the init container
+		Expect(redisClient.SetConfig(ip, 6379, "", "replica-announce-ip", fqdn)).To(Succeed())
+	}
+}
+
+func getPhase(ctx context.Context, key types.NamespacedName) util.CustomRedisPhase {
+	cRedis := &redisv1beta1.CustomRedis{}
+	if err := k8sClient.Get(ctx, key, cRedis); err != nil {
+		return ""
+	}
+	return cRedis.Status.Phase
+}
+
+// expectTopology checks there is exactly one master, the other pods replicate from its DNS name
+// and the pods are labeled with their role. Pods created within the same second are equally old,
+// so any of them may have been picked as the first master. Returns the DNS name of the master.
+func expectTopology(ctx context.Context, sts *appv1.StatefulSet, count int, cRedis *redisv1beta1.CustomRedis) string {
+	pods := make([]*corev1.Pod, 0, count)
+	masterHost := ""
+	for i := 0; i < count; i++ {
+		podName := fmt.Sprintf("%s-%d", sts.Name, i)
+		pod := &corev1.Pod{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: podName, Namespace: sts.Namespace}, pod)).To(Succeed())
+		expectOwnedBy(pod, cRedis)
+		pods = append(pods, pod)
+
+		node, exists := redisClient.Node(pod.Status.PodIP)
+		Expect(exists).To(BeTrue())
+		if node.Role == "master" {
+			Expect(masterHost).To(BeEmpty(), "more than one master")
+			masterHost = util.GetPodFQDN(podName, sts.Spec.ServiceName, sts.Namespace)
+		}
+	}
+	Expect(masterHost).NotTo(BeEmpty(), "no master")
+
+	for _, pod := range pods {
+		node, _ := redisClient.Node(pod.Status.PodIP)
+		if node.Role == "master" {
+			Expect(pod.Labels).To(HaveKeyWithValue("redis.hongqchen/role", "master"))
+			continue
+		}
+		Expect(node.MasterHost).To(Equal(masterHost))
+		Expect(pod.Labels).To(HaveKeyWithValue("redis.hongqchen/role", "slave"))
+	}
+
+	return masterHost
+}
+
+func expectControlledBy(obj client.Object, cRedis *redisv1beta1.CustomRedis) {
+	owner := metav1.GetControllerOf(obj)
+	Expect(owner).NotTo(BeNil())
+	Expect(owner.Kind).To(Equal("CustomRedis"))
+	Expect(owner.Name).To(Equal(cRedis.Name))
+}
+
+// pods are controlled by their statefulset, the CustomRedis is added as a second owner
+func expectOwnedBy(obj client.Object, cRedis *redisv1beta1.CustomRedis) {
+	Expect(obj.GetOwnerReferences()).To(ContainElement(And(
+		HaveField("Kind", "CustomRedis"),
+		HaveField("Name", cRedis.Name),
+	)))
+}
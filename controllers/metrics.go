@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"github.com/hongqchen/redis-operator/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// reconcileErrors reconcile 失败次数，按错误类别区分
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "customredis_reconcile_errors_total",
+		Help: "Number of failed CustomRedis reconciles by error category.",
+	}, []string{"namespace", "name", "category"})
+
+	// needsHuman 为 1 时集群需要人工介入，可据此告警
+	needsHuman = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "customredis_needs_human",
+		Help: "Whether the CustomRedis is in a state the operator cannot fix on its own (1) or not (0).",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileErrors, needsHuman)
+}
+
+// recordMetrics 记录 reconcile 结果，category 为空表示成功
+func recordMetrics(namespace, name string, category util.ErrorCategory) {
+	if category != "" {
+		reconcileErrors.WithLabelValues(namespace, name, string(category)).Inc()
+	}
+	if category == util.ErrorNeedsHuman {
+		needsHuman.WithLabelValues(namespace, name).Set(1)
+	} else {
+		needsHuman.WithLabelValues(namespace, name).Set(0)
+	}
+}
+
+// forgetMetrics CustomRedis 被删除后移除其指标
+func forgetMetrics(namespace, name string) {
+	for _, category := range []util.ErrorCategory{util.ErrorWaiting, util.ErrorTransient, util.ErrorNeedsHuman} {
+		reconcileErrors.DeleteLabelValues(namespace, name, string(category))
+	}
+	needsHuman.DeleteLabelValues(namespace, name)
+}
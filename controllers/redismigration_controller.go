@@ -0,0 +1,129 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"github.com/hongqchen/redis-operator/pkg/controller"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"time"
+
+	redisv1beta1 "github.com/hongqchen/redis-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// 复制链路与 key 数量的变化不会触发 reconcile，迁移期间定期刷新
+	migrationPollInterval = 10 * time.Second
+	// Dump 方式两批 key 之间的间隔，限制对源实例的压力
+	migrationBatchInterval = 100 * time.Millisecond
+)
+
+// RedisMigrationReconciler reconciles a RedisMigration object
+type RedisMigrationReconciler struct {
+	client.Client
+	Logger logr.Logger
+	Scheme *runtime.Scheme
+	// RedisClient 与源实例及目标集群交互，测试时可替换为 fake 实现
+	RedisClient redis.Clienter
+	// Backoff 记录每个 RedisMigration 的连续失败次数，决定重试间隔
+	Backoff  *util.Backoff
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=redis.hongqchen,resources=redismigrations,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=redis.hongqchen,resources=redismigrations/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=redis.hongqchen,resources=redismigrations/finalizers,verbs=update
+//+kubebuilder:rbac:groups=redis.hongqchen,resources=customredis,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile 推进一次迁移并更新 status，迁移完成或失败后不再修改目标集群
+func (r *RedisMigrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	namespacedName := req.NamespacedName
+	logger := r.Logger.WithValues("migration", namespacedName)
+
+	rm := &redisv1beta1.RedisMigration{}
+	if err := r.Get(ctx, namespacedName, rm); err != nil {
+		if apierror.IsNotFound(err) {
+			r.Backoff.Forget(namespacedName.String())
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if rm.IsFinished() {
+		return ctrl.Result{}, nil
+	}
+	// 缓存只包含匹配分片 selector 的 CustomRedis，目标集群不可见时由负责该分片的实例处理，不写入 status
+	destination := &redisv1beta1.CustomRedis{}
+	if err := r.Get(ctx, client.ObjectKey{Name: rm.Spec.Destination, Namespace: rm.Namespace}, destination); err != nil {
+		if apierror.IsNotFound(err) {
+			logger.V(1).Info("Destination not found or managed by another shard, skipping", "destination", rm.Spec.Destination)
+			return ctrl.Result{RequeueAfter: migrationPollInterval}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	logger.Info("Reconciling")
+	storedStatus := rm.Status.DeepCopy()
+
+	migrationHandler := controller.NewMigrationHandler(r.Client, r.RedisClient, logger)
+	syncErr := migrationHandler.Sync(rm)
+	_, requeue := util.ErrorHandle(logger, r.Backoff, namespacedName.String(), syncErr)
+	if syncErr != nil {
+		rm.Status.Message = syncErr.Error()
+	}
+	if rm.Status.Phase != storedStatus.Phase {
+		r.Recorder.Event(rm, corev1.EventTypeNormal, string(rm.Status.Phase), fmt.Sprintf("migration is %s", rm.Status.Phase))
+	}
+	if !equality.Semantic.DeepEqual(storedStatus, &rm.Status) {
+		if err := r.Status().Update(ctx, rm); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	switch {
+	case requeue > 0:
+		return ctrl.Result{RequeueAfter: requeue}, nil
+	case rm.IsFinished():
+		logger.Info("Reconcile complete")
+		return ctrl.Result{}, nil
+	case rm.Spec.Method == redisv1beta1.MigrationDump && rm.Status.Phase != redisv1beta1.MigrationSynced:
+		return ctrl.Result{RequeueAfter: migrationBatchInterval}, nil
+	default:
+		return ctrl.Result{RequeueAfter: migrationPollInterval}, nil
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RedisMigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		// status 的更新不触发 reconcile，迁移进度由定期 reconcile 推进
+		For(&redisv1beta1.RedisMigration{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
 
@@ -24,6 +25,7 @@ import (
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
@@ -31,6 +33,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	redisv1beta1 "github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis/fake"
+	"github.com/hongqchen/redis-operator/pkg/util"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -41,6 +45,10 @@ var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
 
+// redisClient backs the redis and sentinel pods created by the specs
+var redisClient *fake.Client
+var cancel context.CancelFunc
+
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 
@@ -73,10 +81,48 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	By("starting the reconciler with a fake redis backend")
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	redisClient = fake.NewClient()
+	err = (&CustomRedisReconciler{
+		Client:      mgr.GetClient(),
+		Logger:      ctrl.Log,
+		Scheme:      mgr.GetScheme(),
+		RedisClient: redisClient,
+		Backoff:     util.NewBackoff(),
+		Recorder:    mgr.GetEventRecorderFor("customredis-controller"),
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&RedisMigrationReconciler{
+		Client:      mgr.GetClient(),
+		Logger:      ctrl.Log,
+		Scheme:      mgr.GetScheme(),
+		RedisClient: redisClient,
+		Backoff:     util.NewBackoff(),
+		Recorder:    mgr.GetEventRecorderFor("redismigration-controller"),
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.TODO())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	if cancel != nil {
+		cancel()
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
@@ -18,23 +18,36 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"go.uber.org/zap/zapcore"
+	"hash/fnv"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	redisv1beta1 "github.com/hongqchen/redis-operator/api/v1beta1"
 	"github.com/hongqchen/redis-operator/controllers"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"github.com/hongqchen/redis-operator/pkg/util"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -54,11 +67,23 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var watchNamespaces string
+	var shardSelector string
+	var resyncPeriod time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma-separated list of namespaces the manager watches, all namespaces when empty.")
+	flag.StringVar(&shardSelector, "shard-selector", "",
+		"Label selector of the CustomRedis resources managed by this instance, e.g. shard=a. "+
+			"Instances with disjoint selectors split the fleet and each elect their own leader.")
+	flag.StringVar(&util.ClusterDomain, "cluster-domain", util.ClusterDomain,
+		"DNS domain of the Kubernetes cluster, used in the pod names redis and sentinel replicate through.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute,
+		"Interval at which healthy CustomRedis resources are reconciled again, to revert changes missed by watches. 0 disables it.")
 	opts := zap.Options{
 		Development: false,
 		TimeEncoder: func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
@@ -71,13 +96,32 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	namespaces := parseNamespaces(watchNamespaces)
+	selector, err := labels.Parse(shardSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid shard selector", "selector", shardSelector)
+		os.Exit(1)
+	}
+	setupLog.Info("Watch scope", "namespaces", namespaces, "shardSelector", selector.String())
+
+	uncached := []client.Object{&corev1.Secret{}, &appv1.Deployment{}}
+	if len(namespaces) > 0 {
+		// 仅监听部分 namespace 时可能没有读取 node 与 storage class 的 ClusterRole，
+		// 缓存的 informer 无权限时会一直等待同步，直接读取以便返回 Forbidden
+		uncached = append(uncached, &corev1.Node{}, &storagev1.StorageClass{})
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "3c0b62e6.hongqchen",
+		LeaderElectionID:       leaderElectionID(namespaces, selector),
+		NewCache:               newCache(namespaces, selector),
+		// 仅读取 spec.replicaOf 引用的 secret，不缓存集群中所有的 secret
+		// deployment 仅在删除旧版本的 sentinel 时读取，不需要 list/watch 权限
+		ClientDisableCacheFor: uncached,
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -96,13 +140,28 @@ func main() {
 	}
 
 	if err = (&controllers.CustomRedisReconciler{
-		Client: mgr.GetClient(),
-		Logger: ctrl.Log,
-		Scheme: mgr.GetScheme(),
+		Client:       mgr.GetClient(),
+		Logger:       ctrl.Log,
+		Scheme:       mgr.GetScheme(),
+		RedisClient:  redis.NewClient(),
+		ResyncPeriod: resyncPeriod,
+		Backoff:      util.NewBackoff(),
+		Recorder:     mgr.GetEventRecorderFor("customredis-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CustomRedis")
 		os.Exit(1)
 	}
+	if err = (&controllers.RedisMigrationReconciler{
+		Client:      mgr.GetClient(),
+		Logger:      ctrl.Log,
+		Scheme:      mgr.GetScheme(),
+		RedisClient: redis.NewClient(),
+		Backoff:     util.NewBackoff(),
+		Recorder:    mgr.GetEventRecorderFor("redismigration-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RedisMigration")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -120,3 +179,43 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func parseNamespaces(value string) []string {
+	var namespaces []string
+	for _, namespace := range strings.Split(value, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// newCache 只缓存被监听 namespace 中、匹配分片 selector 的 CustomRedis，
+// 其余分片的 CustomRedis 对当前实例不可见，相关 Pod 事件触发的 reconcile 会因找不到对象而直接返回
+func newCache(namespaces []string, selector labels.Selector) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		if !selector.Empty() {
+			opts.SelectorsByObject = cache.SelectorsByObject{
+				&redisv1beta1.CustomRedis{}: {Label: selector},
+			}
+		}
+		if len(namespaces) > 0 {
+			return cache.MultiNamespacedCacheBuilder(namespaces)(config, opts)
+		}
+		return cache.New(config, opts)
+	}
+}
+
+// leaderElectionID 不同监听范围的实例各自选主，监听全部集群时保持原有的 ID
+func leaderElectionID(namespaces []string, selector labels.Selector) string {
+	const id = "3c0b62e6.hongqchen"
+	if len(namespaces) == 0 && selector.Empty() {
+		return id
+	}
+
+	sorted := append([]string(nil), namespaces...)
+	sort.Strings(sorted)
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.Join(sorted, ",") + "/" + selector.String()))
+	return fmt.Sprintf("%08x.%s", h.Sum32(), id)
+}
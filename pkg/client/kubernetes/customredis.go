@@ -0,0 +1,41 @@
+package kubernetes
+
+import (
+	"context"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ CustomRediser = (*CustomRedis)(nil)
+
+type CustomRediser interface {
+	GetCustomRedis(name, namespace string) (*v1beta1.CustomRedis, error)
+	UpdateCustomRedis(cRedis *v1beta1.CustomRedis) error
+}
+
+type CustomRedis struct {
+	cl client.Client
+}
+
+func NewCustomRedis(cl client.Client) *CustomRedis {
+	return &CustomRedis{cl: cl}
+}
+
+func (c *CustomRedis) GetCustomRedis(name, namespace string) (*v1beta1.CustomRedis, error) {
+	cRedis := &v1beta1.CustomRedis{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	err := c.cl.Get(context.TODO(), client.ObjectKeyFromObject(cRedis), cRedis)
+	if err != nil {
+		return nil, err
+	}
+	return cRedis, nil
+}
+
+func (c *CustomRedis) UpdateCustomRedis(cRedis *v1beta1.CustomRedis) error {
+	return c.cl.Update(context.TODO(), cRedis)
+}
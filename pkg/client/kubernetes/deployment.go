@@ -13,6 +13,7 @@ type Deploymenter interface {
 	GetDeployment(name, namespace string) (*appv1.Deployment, error)
 	CreateDeployment(deploy *appv1.Deployment) error
 	UpdateDeployment(deploy *appv1.Deployment) error
+	DeleteDeployment(name, namespace string) error
 }
 
 type Deployment struct {
@@ -47,3 +48,13 @@ func (d *Deployment) CreateDeployment(deploy *appv1.Deployment) error {
 func (d *Deployment) UpdateDeployment(deploy *appv1.Deployment) error {
 	return d.cl.Update(context.TODO(), deploy)
 }
+
+func (d *Deployment) DeleteDeployment(name, namespace string) error {
+	deploy := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	return d.cl.Delete(context.TODO(), deploy, client.PropagationPolicy(metav1.DeletePropagationBackground))
+}
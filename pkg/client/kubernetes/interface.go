@@ -10,6 +10,11 @@ type Clienter interface {
 	Statefulseter
 	Poder
 	Deploymenter
+	Noder
+	PersistentVolumeClaimer
+	StorageClasser
+	Secreter
+	CustomRediser
 }
 
 type Client struct {
@@ -18,14 +23,24 @@ type Client struct {
 	Statefulseter
 	Poder
 	Deploymenter
+	Noder
+	PersistentVolumeClaimer
+	StorageClasser
+	Secreter
+	CustomRediser
 }
 
 func NewClient(cl client.Client) *Client {
 	return &Client{
-		Configmaper:   NewConfigmap(cl),
-		Servicer:      NewService(cl),
-		Statefulseter: NewStatefulset(cl),
-		Poder:         NewPod(cl),
-		Deploymenter:  NewDeployment(cl),
+		Configmaper:             NewConfigmap(cl),
+		Servicer:                NewService(cl),
+		Statefulseter:           NewStatefulset(cl),
+		Poder:                   NewPod(cl),
+		Deploymenter:            NewDeployment(cl),
+		Noder:                   NewNode(cl),
+		PersistentVolumeClaimer: NewPersistentVolumeClaim(cl),
+		StorageClasser:          NewStorageClass(cl),
+		Secreter:                NewSecret(cl),
+		CustomRediser:           NewCustomRedis(cl),
 	}
 }
@@ -0,0 +1,35 @@
+package kubernetes
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ Noder = (*Node)(nil)
+
+type Noder interface {
+	GetNode(name string) (*corev1.Node, error)
+}
+
+type Node struct {
+	cl client.Client
+}
+
+func NewNode(cl client.Client) *Node {
+	return &Node{cl: cl}
+}
+
+func (n *Node) GetNode(name string) (*corev1.Node, error) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	err := n.cl.Get(context.TODO(), client.ObjectKeyFromObject(node), node)
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
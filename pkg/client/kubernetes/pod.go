@@ -13,6 +13,7 @@ type Poder interface {
 	GetPod(name, namespace string) (*corev1.Pod, error)
 	GetPods(namespace string, selector client.MatchingLabels) (corev1.PodList, error)
 	UpdatePod(podObj *corev1.Pod) error
+	DeletePod(name, namespace string) error
 }
 
 type Pod struct {
@@ -51,3 +52,13 @@ func (p *Pod) GetPod(name, namespace string) (*corev1.Pod, error) {
 func (p *Pod) UpdatePod(podObj *corev1.Pod) error {
 	return p.cl.Update(context.TODO(), podObj)
 }
+
+func (p *Pod) DeletePod(name, namespace string) error {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	return p.cl.Delete(context.TODO(), pod)
+}
@@ -0,0 +1,41 @@
+package kubernetes
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ PersistentVolumeClaimer = (*PersistentVolumeClaim)(nil)
+
+type PersistentVolumeClaimer interface {
+	GetPersistentVolumeClaim(name, namespace string) (*corev1.PersistentVolumeClaim, error)
+	UpdatePersistentVolumeClaim(pvc *corev1.PersistentVolumeClaim) error
+}
+
+type PersistentVolumeClaim struct {
+	cl client.Client
+}
+
+func NewPersistentVolumeClaim(cl client.Client) *PersistentVolumeClaim {
+	return &PersistentVolumeClaim{cl: cl}
+}
+
+func (p *PersistentVolumeClaim) GetPersistentVolumeClaim(name, namespace string) (*corev1.PersistentVolumeClaim, error) {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	err := p.cl.Get(context.TODO(), client.ObjectKeyFromObject(pvc), pvc)
+	if err != nil {
+		return nil, err
+	}
+	return pvc, nil
+}
+
+func (p *PersistentVolumeClaim) UpdatePersistentVolumeClaim(pvc *corev1.PersistentVolumeClaim) error {
+	return p.cl.Update(context.TODO(), pvc)
+}
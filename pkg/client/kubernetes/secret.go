@@ -0,0 +1,36 @@
+package kubernetes
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ Secreter = (*Secret)(nil)
+
+type Secreter interface {
+	GetSecret(name, namespace string) (*corev1.Secret, error)
+}
+
+type Secret struct {
+	cl client.Client
+}
+
+func NewSecret(cl client.Client) *Secret {
+	return &Secret{cl: cl}
+}
+
+func (s *Secret) GetSecret(name, namespace string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	err := s.cl.Get(context.TODO(), client.ObjectKeyFromObject(secret), secret)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
@@ -13,6 +13,8 @@ type Servicer interface {
 	GetService(name, namespace string) (*corev1.Service, error)
 	CreateService(service *corev1.Service) error
 	UpdateService(service *corev1.Service) error
+	GetServices(namespace string, selector client.MatchingLabels) (corev1.ServiceList, error)
+	DeleteService(name, namespace string) error
 }
 
 type Service struct {
@@ -44,3 +46,21 @@ func (s *Service) CreateService(service *corev1.Service) error {
 func (s *Service) UpdateService(service *corev1.Service) error {
 	return s.cl.Update(context.TODO(), service)
 }
+
+func (s *Service) GetServices(namespace string, selector client.MatchingLabels) (corev1.ServiceList, error) {
+	services := corev1.ServiceList{}
+	if err := s.cl.List(context.TODO(), &services, client.InNamespace(namespace), selector); err != nil {
+		return corev1.ServiceList{}, err
+	}
+	return services, nil
+}
+
+func (s *Service) DeleteService(name, namespace string) error {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	return s.cl.Delete(context.TODO(), service)
+}
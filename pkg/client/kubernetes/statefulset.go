@@ -13,6 +13,8 @@ type Statefulseter interface {
 	GetStatefulset(name, namespace string) (*appv1.StatefulSet, error)
 	CreateStatefulset(sts *appv1.StatefulSet) error
 	UpdateStatefulset(sts *appv1.StatefulSet) error
+	DryRunUpdateStatefulset(sts *appv1.StatefulSet) (*appv1.StatefulSet, error)
+	DeleteStatefulset(name, namespace string, propagation metav1.DeletionPropagation) error
 }
 
 type Statefulset struct {
@@ -46,3 +48,22 @@ func (s *Statefulset) CreateStatefulset(sts *appv1.StatefulSet) error {
 func (s *Statefulset) UpdateStatefulset(sts *appv1.StatefulSet) error {
 	return s.cl.Update(context.TODO(), sts)
 }
+
+// DryRunUpdateStatefulset returns the statefulset as the API server would store it, with the defaults applied
+func (s *Statefulset) DryRunUpdateStatefulset(sts *appv1.StatefulSet) (*appv1.StatefulSet, error) {
+	statefulset := sts.DeepCopy()
+	if err := s.cl.Update(context.TODO(), statefulset, client.DryRunAll); err != nil {
+		return nil, err
+	}
+	return statefulset, nil
+}
+
+func (s *Statefulset) DeleteStatefulset(name, namespace string, propagation metav1.DeletionPropagation) error {
+	statefulset := &appv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	return s.cl.Delete(context.TODO(), statefulset, client.PropagationPolicy(propagation))
+}
@@ -0,0 +1,35 @@
+package kubernetes
+
+import (
+	"context"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ StorageClasser = (*StorageClass)(nil)
+
+type StorageClasser interface {
+	GetStorageClass(name string) (*storagev1.StorageClass, error)
+}
+
+type StorageClass struct {
+	cl client.Client
+}
+
+func NewStorageClass(cl client.Client) *StorageClass {
+	return &StorageClass{cl: cl}
+}
+
+func (s *StorageClass) GetStorageClass(name string) (*storagev1.StorageClass, error) {
+	storageClass := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	err := s.cl.Get(context.TODO(), client.ObjectKeyFromObject(storageClass), storageClass)
+	if err != nil {
+		return nil, err
+	}
+	return storageClass, nil
+}
@@ -0,0 +1,964 @@
+// Package fake provides an in-memory redis.Clienter that simulates a replication
+// topology of redis nodes and sentinels, for deterministic tests of the service layer.
+package fake
+
+import (
+	"fmt"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ redis.Clienter = (*Client)(nil)
+
+// ErrUnreachable is returned for nodes and sentinels that are unknown or down
+var ErrUnreachable = errors.New("connection refused")
+
+// like redis, INFO and CONFIG are served while loading but REPLICAOF is refused
+var errLoading = errors.New("LOADING Redis is loading the dataset in memory")
+
+// Node is a simulated redis instance
+type Node struct {
+	IP         string
+	Role       string
+	MasterHost string
+	MasterPort int
+	// replication offset the node has processed
+	Offset int64
+	// bytes a linked slave stays behind its master
+	Lag     int64
+	Loading bool
+	Down    bool
+	// writes are refused while paused by CLIENT PAUSE WRITE
+	Paused bool
+	// number of completed BGSAVE
+	Saves int
+	// modules reported by MODULE LIST
+	Modules []redis.Module
+	// keys of db 0 and their serialized values, replicated to linked slaves
+	Keys     map[string]string
+	Password string
+	Config   map[string]string
+	// redis_version of INFO server, 7.0.5 when empty
+	Version string
+}
+
+// Sentinel is a simulated sentinel instance monitoring "mymaster"
+type Sentinel struct {
+	IP          string
+	MonitorHost string
+	MonitorPort string
+	Quorum      string
+	Down        bool
+	// FailoverInProgress is reported in the flags of SENTINEL MASTER
+	FailoverInProgress bool
+	// peers and replicas that no longer exist but are still known, forgotten by SENTINEL RESET
+	StalePeers    []string
+	StaleReplicas []string
+	Resets        int
+	Config        map[string]string
+}
+
+type Client struct {
+	mu        sync.Mutex
+	nodes     map[string]*Node
+	hosts     map[string]string
+	sentinels map[string]*Sentinel
+}
+
+func NewClient() *Client {
+	return &Client{
+		nodes:     make(map[string]*Node),
+		hosts:     make(map[string]string),
+		sentinels: make(map[string]*Sentinel),
+	}
+}
+
+// AddNode adds a standalone master reachable by its IP and by every alias, e.g. its DNS name
+func (c *Client) AddNode(ip string, aliases ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nodes[ip] = &Node{IP: ip, Role: redis.RoleMaster, Config: make(map[string]string)}
+	c.hosts[ip] = ip
+	for _, alias := range aliases {
+		c.hosts[alias] = ip
+	}
+}
+
+// RemoveNode forgets a node and every alias pointing to it, e.g. when its pod is deleted
+func (c *Client) RemoveNode(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node := c.resolve(host)
+	if node == nil {
+		return
+	}
+	for alias, ip := range c.hosts {
+		if ip == node.IP {
+			delete(c.hosts, alias)
+		}
+	}
+	delete(c.nodes, node.IP)
+}
+
+func (c *Client) AddSentinel(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sentinels[ip] = &Sentinel{IP: ip, Config: make(map[string]string)}
+}
+
+// Node returns a copy of the node known by host
+func (c *Client) Node(host string) (Node, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node := c.resolve(host)
+	if node == nil {
+		return Node{}, false
+	}
+	return *node, true
+}
+
+// Sentinel returns a copy of the sentinel
+func (c *Client) Sentinel(ip string) (Sentinel, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, exists := c.sentinels[ip]
+	if !exists {
+		return Sentinel{}, false
+	}
+	return *sentinel, true
+}
+
+// Masters returns the IPs of all reachable nodes whose role is master
+func (c *Client) Masters() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var masters []string
+	for _, ip := range c.sortedNodes() {
+		node := c.nodes[ip]
+		if !node.Down && node.Role == redis.RoleMaster {
+			masters = append(masters, ip)
+		}
+	}
+	return masters
+}
+
+// SetDown makes a node or a sentinel unreachable, or reachable again
+func (c *Client) SetDown(host string, down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node := c.resolve(host); node != nil {
+		node.Down = down
+	}
+	if sentinel, exists := c.sentinels[host]; exists {
+		sentinel.Down = down
+	}
+}
+
+func (c *Client) SetLoading(host string, loading bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node := c.resolve(host); node != nil {
+		node.Loading = loading
+	}
+}
+
+// SetVersion changes the redis_version reported by the node
+func (c *Client) SetVersion(host string, version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node := c.resolve(host); node != nil {
+		node.Version = version
+	}
+}
+
+// SetLag keeps a slave lag bytes behind its master, as if it could not keep up with the writes
+func (c *Client) SetLag(host string, lag int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node := c.resolve(host); node != nil {
+		node.Lag = lag
+		c.propagate()
+	}
+}
+
+func (c *Client) SetModules(host string, modules ...redis.Module) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node := c.resolve(host); node != nil {
+		node.Modules = modules
+	}
+}
+
+func (c *Client) SetPassword(host, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node := c.resolve(host); node != nil {
+		node.Password = password
+	}
+}
+
+// AddStalePeer makes the sentinel remember a sentinel that does not exist anymore
+func (c *Client) AddStalePeer(sentinelIP, peerIP string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sentinel, exists := c.sentinels[sentinelIP]; exists {
+		sentinel.StalePeers = append(sentinel.StalePeers, peerIP)
+	}
+}
+
+// Write applies n bytes of writes on a master and replicates them to its linked slaves
+func (c *Client) Write(host string, n int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node := c.resolve(host)
+	if node == nil || node.Down {
+		return ErrUnreachable
+	}
+	if node.Role != redis.RoleMaster {
+		return errors.New("READONLY You can't write against a read only replica.")
+	}
+	if node.Paused {
+		return errors.New("writes are paused")
+	}
+
+	node.Offset += n
+	c.propagate()
+	return nil
+}
+
+// SetKey writes a key on a master and replicates it to its linked slaves
+func (c *Client) SetKey(host, key, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node := c.resolve(host)
+	if node == nil || node.Down {
+		return ErrUnreachable
+	}
+	if node.Role != redis.RoleMaster {
+		return errors.New("READONLY You can't write against a read only replica.")
+	}
+
+	if node.Keys == nil {
+		node.Keys = make(map[string]string)
+	}
+	node.Keys[key] = value
+	node.Offset += int64(len(key) + len(value))
+	c.propagate()
+	return nil
+}
+
+// Restart simulates a restart without persistence, the node comes back as an empty master
+func (c *Client) Restart(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node := c.resolve(host); node != nil {
+		node.Role = redis.RoleMaster
+		node.MasterHost = ""
+		node.MasterPort = 0
+		node.Offset = 0
+		node.Keys = nil
+		node.Loading = false
+		node.Down = false
+		node.Paused = false
+	}
+}
+
+// RestartFromDisk simulates a restart with persistence, the node comes back as a master with its keys
+// and a replication offset of 0
+func (c *Client) RestartFromDisk(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if node := c.resolve(host); node != nil {
+		node.Role = redis.RoleMaster
+		node.MasterHost = ""
+		node.MasterPort = 0
+		node.Offset = 0
+		node.Loading = false
+		node.Down = false
+		node.Paused = false
+	}
+}
+
+// Failover simulates the sentinels promoting a slave of the monitored master, the one with the lowest
+// replica-priority first and the most up-to-date one among them, slaves with priority 0 are never promoted. The other slaves and the old master are reconfigured as slaves of the new one
+func (c *Client) Failover() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.failover()
+}
+
+func (c *Client) failover() (string, error) {
+	var monitorHost string
+	for _, ip := range c.sortedSentinels() {
+		if sentinel := c.sentinels[ip]; !sentinel.Down && sentinel.MonitorHost != "" {
+			monitorHost = sentinel.MonitorHost
+			break
+		}
+	}
+	oldMaster := c.resolve(monitorHost)
+	if oldMaster == nil {
+		return "", errors.Errorf("no sentinel monitors a known master, monitor: %q", monitorHost)
+	}
+
+	var promoted *Node
+	for _, ip := range c.sortedNodes() {
+		node := c.nodes[ip]
+		if node.Down || node.Role != redis.RoleSlave || c.resolve(node.MasterHost) != oldMaster || priority(node) == 0 {
+			continue
+		}
+		if promoted == nil || priority(node) < priority(promoted) ||
+			(priority(node) == priority(promoted) && node.Offset > promoted.Offset) {
+			promoted = node
+		}
+	}
+	if promoted == nil {
+		return "", errors.New("NOGOODSLAVE No suitable replica to promote")
+	}
+
+	port := promoted.MasterPort
+	promoted.Role = redis.RoleMaster
+	promoted.MasterHost = ""
+	promoted.MasterPort = 0
+
+	promotedHost := c.announceHost(promoted)
+	for _, node := range c.nodes {
+		if node == promoted {
+			continue
+		}
+		if node == oldMaster || (node.Role == redis.RoleSlave && c.resolve(node.MasterHost) == oldMaster) {
+			node.Role = redis.RoleSlave
+			node.MasterHost = promotedHost
+			node.MasterPort = port
+		}
+	}
+	for _, sentinel := range c.sentinels {
+		if sentinel.MonitorHost != "" {
+			sentinel.MonitorHost = promotedHost
+		}
+	}
+	c.propagate()
+
+	return promoted.IP, nil
+}
+
+// SetFailoverInProgress makes the sentinel report a failover of the master it monitors
+func (c *Client) SetFailoverInProgress(sentinelIP string, inProgress bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sentinel, exists := c.sentinels[sentinelIP]; exists {
+		sentinel.FailoverInProgress = inProgress
+	}
+}
+
+// AddStaleReplica makes the sentinel remember a replica that does not exist anymore
+func (c *Client) AddStaleReplica(sentinelIP, host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sentinel, exists := c.sentinels[sentinelIP]; exists {
+		sentinel.StaleReplicas = append(sentinel.StaleReplicas, host)
+	}
+}
+
+func (c *Client) GetInfo(ip string, port int32, password string, section string) (*redis.Info, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return redis.ParseInfo(c.renderInfo(node, port, section))
+}
+
+func (c *Client) SetAsMaster(ip string, port int32, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return err
+	}
+	if node.Loading {
+		return errLoading
+	}
+
+	node.Role = redis.RoleMaster
+	node.MasterHost = ""
+	node.MasterPort = 0
+	return nil
+}
+
+func (c *Client) SetAsSlave(slaveIP, masterHost string, port int32, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(slaveIP, password)
+	if err != nil {
+		return err
+	}
+	if node.Loading {
+		return errLoading
+	}
+
+	node.Role = redis.RoleSlave
+	node.MasterHost = masterHost
+	node.MasterPort = int(port)
+	c.propagate()
+	return nil
+}
+
+func (c *Client) ReplicateFrom(ip string, port int32, password string, masterHost string, masterPort int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return err
+	}
+	if node.Loading {
+		return errLoading
+	}
+
+	node.Role = redis.RoleSlave
+	node.MasterHost = masterHost
+	node.MasterPort = int(masterPort)
+	c.propagate()
+	return nil
+}
+
+func (c *Client) GetSentinelMonitor(sentinelIP string, password string) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return "", "", err
+	}
+	if sentinel.MonitorHost == "" {
+		return "", "", errors.Wrap(redis.ErrNoMonitor, "failed to get sentinel monitor info")
+	}
+
+	return sentinel.MonitorHost, sentinel.MonitorPort, nil
+}
+
+func (c *Client) GetSentinelMaster(sentinelIP string, password string) (*redis.SentinelMaster, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return nil, err
+	}
+	if sentinel.MonitorHost == "" {
+		return nil, errors.Wrap(redis.ErrNoMonitor, "failed to get sentinel master")
+	}
+
+	flags := "master"
+	if sentinel.FailoverInProgress {
+		flags += "," + redis.SentinelFlagFailoverInProgress
+	}
+	quorum, _ := strconv.Atoi(sentinel.Quorum)
+	return &redis.SentinelMaster{
+		IP:     sentinel.MonitorHost,
+		Port:   sentinel.MonitorPort,
+		Flags:  strings.Split(flags, ","),
+		Quorum: quorum,
+	}, nil
+}
+
+// SetSentinelMonitor fails like sentinel does when the master is already monitored
+func (c *Client) SetSentinelMonitor(sentinelIP string, password string, monitor map[string]interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return err
+	}
+	if sentinel.MonitorHost != "" {
+		return errors.New("ERR Duplicated master name")
+	}
+
+	sentinel.MonitorHost = monitor["masterIP"].(string)
+	sentinel.MonitorPort = strconv.Itoa(int(monitor["port"].(int32)))
+	sentinel.Quorum = monitor["quorum"].(string)
+	return nil
+}
+
+// RemoveSentinelMonitor forgets the monitored master with its replicas and sentinels
+func (c *Client) RemoveSentinelMonitor(sentinelIP string, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return err
+	}
+
+	// sentinel forgets everything it knew about the master
+	sentinel.MonitorHost = ""
+	sentinel.MonitorPort = ""
+	sentinel.StalePeers = nil
+	sentinel.StaleReplicas = nil
+	return nil
+}
+
+// SetSentinelOption supports the options set by the operator
+func (c *Client) SetSentinelOption(sentinelIP string, password string, option, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return err
+	}
+	if sentinel.MonitorHost == "" {
+		return errors.New("ERR No such master with that name")
+	}
+
+	switch option {
+	case "quorum":
+		sentinel.Quorum = value
+	case "auth-pass":
+	default:
+		return errors.Errorf("ERR Unknown option or number of arguments for SENTINEL SET '%s'", option)
+	}
+	return nil
+}
+
+// GetSentinelPeers returns the other reachable sentinels monitoring the same master, plus stale peers,
+// and ErrNoMonitor like the real client when the sentinel does not monitor mymaster
+func (c *Client) GetSentinelPeers(sentinelIP string, password string) ([]map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return nil, err
+	}
+
+	if sentinel.MonitorHost == "" {
+		return nil, errors.Wrap(redis.ErrNoMonitor, "failed to get sentinel peers")
+	}
+
+	var peers []map[string]string
+	for _, ip := range c.sortedSentinels() {
+		peer := c.sentinels[ip]
+		if peer == sentinel || peer.Down || peer.MonitorHost != sentinel.MonitorHost {
+			continue
+		}
+		peers = append(peers, map[string]string{"ip": ip, "port": "26379"})
+	}
+	for _, ip := range sentinel.StalePeers {
+		peers = append(peers, map[string]string{"ip": ip, "port": "26379", "flags": "s_down,sentinel"})
+	}
+
+	return peers, nil
+}
+
+// GetSentinelReplicas returns the slaves linked to the monitored master, plus stale replicas
+func (c *Client) GetSentinelReplicas(sentinelIP string, password string) ([]map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return nil, err
+	}
+	if sentinel.MonitorHost == "" {
+		return nil, errors.Wrap(redis.ErrNoMonitor, "failed to get sentinel replicas")
+	}
+	master := c.resolve(sentinel.MonitorHost)
+	if master == nil {
+		return nil, errors.New("ERR No such master with that name")
+	}
+
+	var replicas []map[string]string
+	for _, ip := range c.sortedNodes() {
+		node := c.nodes[ip]
+		if node.Role == redis.RoleSlave && c.resolve(node.MasterHost) == master {
+			replicas = append(replicas, map[string]string{"ip": c.announceHost(node), "port": sentinel.MonitorPort, "flags": "slave"})
+		}
+	}
+	for _, host := range sentinel.StaleReplicas {
+		replicas = append(replicas, map[string]string{"ip": host, "port": sentinel.MonitorPort, "flags": "s_down,slave,disconnected"})
+	}
+
+	return replicas, nil
+}
+
+// CheckSentinelQuorum counts the sentinels monitoring the same master, stale peers are known but unusable
+func (c *Client) CheckSentinelQuorum(sentinelIP string, password string) (bool, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return false, "", err
+	}
+	if sentinel.MonitorHost == "" {
+		return false, "", errors.New("ERR No such master with that name")
+	}
+
+	known, usable := len(sentinel.StalePeers), 0
+	for _, peer := range c.sentinels {
+		if peer.MonitorHost != sentinel.MonitorHost {
+			continue
+		}
+		known++
+		if !peer.Down {
+			usable++
+		}
+	}
+	quorum, _ := strconv.Atoi(sentinel.Quorum)
+	if usable < quorum {
+		return false, fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable), nil
+	}
+	if usable <= known/2 {
+		return false, fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable), nil
+	}
+	return true, fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable), nil
+}
+
+func (c *Client) ResetSentinel(sentinelIP string, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return err
+	}
+
+	sentinel.StalePeers = nil
+	sentinel.StaleReplicas = nil
+	sentinel.Resets++
+	return nil
+}
+
+func (c *Client) SentinelFailover(sentinelIP string, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.connectSentinel(sentinelIP); err != nil {
+		return err
+	}
+
+	_, err := c.failover()
+	return err
+}
+
+func (c *Client) PauseWrites(ip string, port int32, password string, timeout time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return err
+	}
+
+	server := &redis.ServerInfo{RedisVersion: node.version()}
+	if !server.SupportsPauseWrite() {
+		return errors.Errorf("pausing writes needs redis 6.2 or later, running %s", server.RedisVersion)
+	}
+
+	node.Paused = true
+	return nil
+}
+
+func (c *Client) UnpauseWrites(ip string, port int32, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return err
+	}
+
+	node.Paused = false
+	return nil
+}
+
+// BackgroundSave completes the save immediately
+func (c *Client) BackgroundSave(ip string, port int32, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return err
+	}
+
+	node.Saves++
+	return nil
+}
+
+func (c *Client) ListModules(ip string, port int32, password string) ([]redis.Module, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]redis.Module{}, node.Modules...), nil
+}
+
+func (c *Client) GetConfig(ip string, port int32, password string, parameter string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return "", err
+	}
+
+	return node.Config[parameter], nil
+}
+
+func (c *Client) SetConfig(ip string, port int32, password string, parameter, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return err
+	}
+
+	node.Config[parameter] = value
+	return nil
+}
+
+func (c *Client) SetSentinelConfig(sentinelIP string, password string, parameter, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sentinel, err := c.connectSentinel(sentinelIP)
+	if err != nil {
+		return err
+	}
+
+	sentinel.Config[parameter] = value
+	return nil
+}
+
+// ScanDump pages through the keys in lexical order, the cursor is the index of the next key
+func (c *Client) ScanDump(ip string, port int32, password string, cursor uint64, count int64) ([]redis.DumpedKey, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keys := make([]string, 0, len(node.Keys))
+	for key := range node.Keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var dumped []redis.DumpedKey
+	next := cursor
+	for ; next < uint64(len(keys)) && int64(len(dumped)) < count; next++ {
+		dumped = append(dumped, redis.DumpedKey{Key: keys[next], Value: node.Keys[keys[next]]})
+	}
+	if next >= uint64(len(keys)) {
+		next = 0
+	}
+	return dumped, next, nil
+}
+
+func (c *Client) RestoreKeys(ip string, port int32, password string, keys []redis.DumpedKey) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, err := c.connect(ip, password)
+	if err != nil {
+		return err
+	}
+	if node.Role != redis.RoleMaster {
+		return errors.New("READONLY You can't write against a read only replica.")
+	}
+
+	if node.Keys == nil {
+		node.Keys = make(map[string]string)
+	}
+	for _, key := range keys {
+		node.Keys[key.Key] = key.Value
+		node.Offset += int64(len(key.Key) + len(key.Value))
+	}
+	c.propagate()
+	return nil
+}
+
+func (c *Client) resolve(host string) *Node {
+	ip, exists := c.hosts[host]
+	if !exists {
+		return nil
+	}
+	return c.nodes[ip]
+}
+
+func (c *Client) connect(host, password string) (*Node, error) {
+	node := c.resolve(host)
+	if node == nil || node.Down {
+		return nil, errors.Wrapf(ErrUnreachable, "dial %s", host)
+	}
+	if node.Password != password {
+		return nil, errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	return node, nil
+}
+
+func (c *Client) connectSentinel(ip string) (*Sentinel, error) {
+	sentinel, exists := c.sentinels[ip]
+	if !exists || sentinel.Down {
+		return nil, errors.Wrapf(ErrUnreachable, "dial %s", ip)
+	}
+	return sentinel, nil
+}
+
+// master returns the master a slave is linked to, nil while the link is down
+func (c *Client) master(node *Node) *Node {
+	if node.Role != redis.RoleSlave || node.Loading {
+		return nil
+	}
+	master := c.resolve(node.MasterHost)
+	if master == nil || master == node || master.Down || master.Loading {
+		return nil
+	}
+	return master
+}
+
+// propagate brings every linked slave to the offset of its master, slaves of slaves included
+func (c *Client) propagate() {
+	for range c.nodes {
+		for _, node := range c.nodes {
+			if master := c.master(node); master != nil {
+				node.Offset = master.Offset - node.Lag
+				node.Keys = make(map[string]string, len(master.Keys))
+				for key, value := range master.Keys {
+					node.Keys[key] = value
+				}
+			}
+		}
+	}
+}
+
+// priority is the replica-priority of the node, 100 unless configured
+func priority(node *Node) int {
+	if value, err := strconv.Atoi(node.Config["replica-priority"]); err == nil {
+		return value
+	}
+	return 100
+}
+
+// announceHost is the address a node is known by, replica-announce-ip when set
+func (c *Client) announceHost(node *Node) string {
+	if announceIP := node.Config["replica-announce-ip"]; announceIP != "" {
+		return announceIP
+	}
+	return node.IP
+}
+
+func (n *Node) version() string {
+	if n.Version == "" {
+		return "7.0.5"
+	}
+	return n.Version
+}
+
+func (c *Client) renderInfo(node *Node, port int32, section string) string {
+	var b strings.Builder
+	include := func(name string) bool {
+		return section == "" || section == "all" || strings.EqualFold(section, name)
+	}
+
+	if include("server") {
+		fmt.Fprintf(&b, "# Server\r\nredis_version:%s\r\nredis_mode:standalone\r\ntcp_port:%d\r\n\r\n", node.version(), port)
+	}
+	if include("persistence") {
+		loading := 0
+		if node.Loading {
+			loading = 1
+		}
+		fmt.Fprintf(&b, "# Persistence\r\nloading:%d\r\nrdb_bgsave_in_progress:0\r\nrdb_last_save_time:%d\r\nrdb_last_bgsave_status:ok\r\n\r\n",
+			loading, 1600000000+node.Saves)
+	}
+	if include("replication") {
+		fmt.Fprintf(&b, "# Replication\r\nrole:%s\r\n", node.Role)
+		if node.Role == redis.RoleSlave {
+			linkStatus := "down"
+			if c.master(node) != nil {
+				linkStatus = redis.MasterLinkUp
+			}
+			fmt.Fprintf(&b, "master_host:%s\r\nmaster_port:%d\r\nmaster_link_status:%s\r\nmaster_sync_in_progress:0\r\nslave_repl_offset:%d\r\n",
+				node.MasterHost, node.MasterPort, linkStatus, node.Offset)
+		}
+
+		var slaves []*Node
+		for _, ip := range c.sortedNodes() {
+			if c.master(c.nodes[ip]) == node {
+				slaves = append(slaves, c.nodes[ip])
+			}
+		}
+		fmt.Fprintf(&b, "connected_slaves:%d\r\n", len(slaves))
+		for i, slave := range slaves {
+			fmt.Fprintf(&b, "slave%d:ip=%s,port=%d,state=online,offset=%d,lag=0\r\n", i, c.announceHost(slave), port, slave.Offset)
+		}
+		fmt.Fprintf(&b, "master_repl_offset:%d\r\n\r\n", node.Offset)
+	}
+	if include("keyspace") {
+		b.WriteString("# Keyspace\r\n")
+		if len(node.Keys) > 0 {
+			fmt.Fprintf(&b, "db0:keys=%d,expires=0,avg_ttl=0\r\n", len(node.Keys))
+		}
+	}
+
+	return b.String()
+}
+
+func (c *Client) sortedNodes() []string {
+	ips := make([]string, 0, len(c.nodes))
+	for ip := range c.nodes {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+func (c *Client) sortedSentinels() []string {
+	ips := make([]string, 0, len(c.sentinels))
+	for ip := range c.sentinels {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return ips
+}
@@ -0,0 +1,363 @@
+package redis
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// Info is the typed result of the INFO command, sections not requested are left empty
+type Info struct {
+	Server      ServerInfo
+	Clients     ClientsInfo
+	Memory      MemoryInfo
+	Persistence PersistenceInfo
+	Replication ReplicationInfo
+	// keyed by database name, e.g. "db0"
+	Keyspace map[string]KeyspaceInfo
+}
+
+type ServerInfo struct {
+	RedisVersion    string
+	RedisMode       string
+	RunID           string
+	TCPPort         int
+	UptimeInSeconds int64
+}
+
+type ClientsInfo struct {
+	ConnectedClients int64
+	BlockedClients   int64
+	MaxClients       int64
+}
+
+type MemoryInfo struct {
+	UsedMemory      int64
+	UsedMemoryRSS   int64
+	UsedMemoryPeak  int64
+	MaxMemory       int64
+	MaxMemoryPolicy string
+}
+
+type PersistenceInfo struct {
+	// the instance is loading its dataset from disk and refuses most commands
+	Loading              bool
+	AsyncLoading         bool
+	RDBBgsaveInProgress  bool
+	RDBLastSaveTime      int64
+	RDBLastBgsaveStatus  string
+	AOFEnabled           bool
+	AOFRewriteInProgress bool
+	AOFLastWriteStatus   string
+}
+
+type ReplicationInfo struct {
+	Role            string
+	ConnectedSlaves int
+	// only reported by a master
+	Slaves []SlaveInfo
+
+	// only reported by a slave
+	MasterHost                 string
+	MasterPort                 int
+	MasterLinkStatus           string
+	MasterLastIOSecondsAgo     int64
+	MasterSyncInProgress       bool
+	MasterLinkDownSinceSeconds int64
+	SlaveReplOffset            int64
+	SlavePriority              int
+	SlaveReadOnly              bool
+
+	MasterReplID     string
+	MasterReplOffset int64
+}
+
+// SlaveInfo is a "slaveN:ip=...,port=...,state=...,offset=...,lag=..." line of a master
+type SlaveInfo struct {
+	IP     string
+	Port   int
+	State  string
+	Offset int64
+	Lag    int64
+}
+
+type KeyspaceInfo struct {
+	Keys    int64
+	Expires int64
+	AvgTTL  int64
+}
+
+const (
+	RoleMaster = "master"
+	RoleSlave  = "slave"
+
+	MasterLinkUp = "up"
+)
+
+// SupportsPauseWrite reports whether CLIENT PAUSE accepts the WRITE mode, added in redis 6.2
+func (s *ServerInfo) SupportsPauseWrite() bool {
+	parts := strings.SplitN(s.RedisVersion, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	return major > 6 || major == 6 && minor >= 2
+}
+
+func (r *ReplicationInfo) IsMaster() bool {
+	return r.Role == RoleMaster
+}
+
+// IsLinkUp reports whether a slave is connected to its master and not in the middle of a full sync
+func (r *ReplicationInfo) IsLinkUp() bool {
+	return r.Role == RoleSlave && r.MasterLinkStatus == MasterLinkUp && !r.MasterSyncInProgress
+}
+
+// ProcessedOffset is the replication offset the instance has applied,
+// for a slave it is the offset processed from its master
+func (r *ReplicationInfo) ProcessedOffset() int64 {
+	if r.Role == RoleSlave {
+		return r.SlaveReplOffset
+	}
+	return r.MasterReplOffset
+}
+
+// Keys is the number of keys in all databases
+func (i *Info) Keys() int64 {
+	var keys int64
+	for _, keyspace := range i.Keyspace {
+		keys += keyspace.Keys
+	}
+	return keys
+}
+
+// ParseInfo parses the raw output of INFO, unknown sections and fields are ignored
+func ParseInfo(raw string) (*Info, error) {
+	info := &Info{Keyspace: make(map[string]KeyspaceInfo)}
+
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			section = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "#")))
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		var err error
+		switch section {
+		case "server":
+			err = info.Server.set(key, value)
+		case "clients":
+			err = info.Clients.set(key, value)
+		case "memory":
+			err = info.Memory.set(key, value)
+		case "persistence":
+			err = info.Persistence.set(key, value)
+		case "replication":
+			err = info.Replication.set(key, value)
+		case "keyspace":
+			var keyspace KeyspaceInfo
+			keyspace, err = parseKeyspace(value)
+			info.Keyspace[key] = keyspace
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s in section %s", key, section)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read info")
+	}
+
+	return info, nil
+}
+
+func (s *ServerInfo) set(key, value string) (err error) {
+	switch key {
+	case "redis_version":
+		s.RedisVersion = value
+	case "redis_mode":
+		s.RedisMode = value
+	case "run_id":
+		s.RunID = value
+	case "tcp_port":
+		s.TCPPort, err = strconv.Atoi(value)
+	case "uptime_in_seconds":
+		s.UptimeInSeconds, err = parseInt(value)
+	}
+	return err
+}
+
+func (c *ClientsInfo) set(key, value string) (err error) {
+	switch key {
+	case "connected_clients":
+		c.ConnectedClients, err = parseInt(value)
+	case "blocked_clients":
+		c.BlockedClients, err = parseInt(value)
+	case "maxclients":
+		c.MaxClients, err = parseInt(value)
+	}
+	return err
+}
+
+func (m *MemoryInfo) set(key, value string) (err error) {
+	switch key {
+	case "used_memory":
+		m.UsedMemory, err = parseInt(value)
+	case "used_memory_rss":
+		m.UsedMemoryRSS, err = parseInt(value)
+	case "used_memory_peak":
+		m.UsedMemoryPeak, err = parseInt(value)
+	case "maxmemory":
+		m.MaxMemory, err = parseInt(value)
+	case "maxmemory_policy":
+		m.MaxMemoryPolicy = value
+	}
+	return err
+}
+
+func (p *PersistenceInfo) set(key, value string) (err error) {
+	switch key {
+	case "loading":
+		p.Loading, err = parseFlag(value)
+	case "async_loading":
+		p.AsyncLoading, err = parseFlag(value)
+	case "rdb_bgsave_in_progress":
+		p.RDBBgsaveInProgress, err = parseFlag(value)
+	case "rdb_last_save_time":
+		p.RDBLastSaveTime, err = parseInt(value)
+	case "rdb_last_bgsave_status":
+		p.RDBLastBgsaveStatus = value
+	case "aof_enabled":
+		p.AOFEnabled, err = parseFlag(value)
+	case "aof_rewrite_in_progress":
+		p.AOFRewriteInProgress, err = parseFlag(value)
+	case "aof_last_write_status":
+		p.AOFLastWriteStatus = value
+	}
+	return err
+}
+
+func (r *ReplicationInfo) set(key, value string) (err error) {
+	switch key {
+	case "role":
+		r.Role = value
+	case "connected_slaves":
+		r.ConnectedSlaves, err = strconv.Atoi(value)
+	case "master_host":
+		r.MasterHost = value
+	case "master_port":
+		r.MasterPort, err = strconv.Atoi(value)
+	case "master_link_status":
+		r.MasterLinkStatus = value
+	case "master_last_io_seconds_ago":
+		r.MasterLastIOSecondsAgo, err = parseInt(value)
+	case "master_sync_in_progress":
+		r.MasterSyncInProgress, err = parseFlag(value)
+	case "master_link_down_since_seconds":
+		r.MasterLinkDownSinceSeconds, err = parseInt(value)
+	case "slave_repl_offset":
+		r.SlaveReplOffset, err = parseInt(value)
+	case "slave_priority", "replica_priority":
+		r.SlavePriority, err = strconv.Atoi(value)
+	case "slave_read_only", "replica_read_only":
+		r.SlaveReadOnly, err = parseFlag(value)
+	case "master_replid":
+		r.MasterReplID = value
+	case "master_repl_offset":
+		r.MasterReplOffset, err = parseInt(value)
+	default:
+		if strings.HasPrefix(key, "slave") {
+			if _, convErr := strconv.Atoi(strings.TrimPrefix(key, "slave")); convErr == nil {
+				var slave SlaveInfo
+				slave, err = parseSlave(value)
+				r.Slaves = append(r.Slaves, slave)
+			}
+		}
+	}
+	return err
+}
+
+func parseSlave(value string) (SlaveInfo, error) {
+	slave := SlaveInfo{}
+	for k, v := range parseFields(value) {
+		var err error
+		switch k {
+		case "ip":
+			slave.IP = v
+		case "port":
+			slave.Port, err = strconv.Atoi(v)
+		case "state":
+			slave.State = v
+		case "offset":
+			slave.Offset, err = parseInt(v)
+		case "lag":
+			slave.Lag, err = parseInt(v)
+		}
+		if err != nil {
+			return slave, err
+		}
+	}
+	return slave, nil
+}
+
+func parseKeyspace(value string) (KeyspaceInfo, error) {
+	keyspace := KeyspaceInfo{}
+	for k, v := range parseFields(value) {
+		var err error
+		switch k {
+		case "keys":
+			keyspace.Keys, err = parseInt(v)
+		case "expires":
+			keyspace.Expires, err = parseInt(v)
+		case "avg_ttl":
+			keyspace.AvgTTL, err = parseInt(v)
+		}
+		if err != nil {
+			return keyspace, err
+		}
+	}
+	return keyspace, nil
+}
+
+// parseFields splits "k1=v1,k2=v2"
+func parseFields(value string) map[string]string {
+	fields := make(map[string]string)
+	for _, field := range strings.Split(value, ",") {
+		if k, v, found := strings.Cut(field, "="); found {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+func parseInt(value string) (int64, error) {
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func parseFlag(value string) (bool, error) {
+	switch value {
+	case "0", "no":
+		return false, nil
+	case "1", "yes":
+		return true, nil
+	}
+	return false, errors.Errorf("invalid flag %q", value)
+}
@@ -0,0 +1,149 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    *Info
+		wantErr bool
+	}{
+		{
+			name: "master",
+			raw: "# Server\r\nredis_version:7.0.5\r\nredis_mode:standalone\r\ntcp_port:6379\r\nuptime_in_seconds:120\r\n\r\n" +
+				"# Clients\r\nconnected_clients:3\r\nmaxclients:10000\r\n\r\n" +
+				"# Memory\r\nused_memory:1024\r\nmaxmemory:0\r\nmaxmemory_policy:noeviction\r\n\r\n" +
+				"# Persistence\r\nloading:0\r\naof_enabled:1\r\nrdb_last_save_time:1600000000\r\nrdb_last_bgsave_status:ok\r\n\r\n" +
+				"# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+				"slave0:ip=redis-1.redis-headless.default.svc.cluster.local,port=6379,state=online,offset=1500,lag=0\r\n" +
+				"slave1:ip=fd00::5,port=6379,state=wait_bgsave,offset=0,lag=1\r\n" +
+				"master_replid:8e3c\r\nmaster_repl_offset:1520\r\n\r\n" +
+				"# Keyspace\r\ndb0:keys=10,expires=2,avg_ttl=300\r\n",
+			want: &Info{
+				Server:      ServerInfo{RedisVersion: "7.0.5", RedisMode: "standalone", TCPPort: 6379, UptimeInSeconds: 120},
+				Clients:     ClientsInfo{ConnectedClients: 3, MaxClients: 10000},
+				Memory:      MemoryInfo{UsedMemory: 1024, MaxMemoryPolicy: "noeviction"},
+				Persistence: PersistenceInfo{AOFEnabled: true, RDBLastSaveTime: 1600000000, RDBLastBgsaveStatus: "ok"},
+				Replication: ReplicationInfo{
+					Role:            RoleMaster,
+					ConnectedSlaves: 2,
+					Slaves: []SlaveInfo{
+						{IP: "redis-1.redis-headless.default.svc.cluster.local", Port: 6379, State: "online", Offset: 1500},
+						{IP: "fd00::5", Port: 6379, State: "wait_bgsave", Lag: 1},
+					},
+					MasterReplID:     "8e3c",
+					MasterReplOffset: 1520,
+				},
+				Keyspace: map[string]KeyspaceInfo{"db0": {Keys: 10, Expires: 2, AvgTTL: 300}},
+			},
+		},
+		{
+			name: "slave",
+			raw: "# Replication\nrole:slave\nmaster_host:redis-0.redis-headless.default.svc.cluster.local\nmaster_port:6379\n" +
+				"master_link_status:up\nmaster_last_io_seconds_ago:1\nmaster_sync_in_progress:0\nslave_repl_offset:1500\n" +
+				"slave_priority:100\nslave_read_only:1\nconnected_slaves:0\nmaster_repl_offset:1500\n",
+			want: &Info{
+				Replication: ReplicationInfo{
+					Role:                   RoleSlave,
+					MasterHost:             "redis-0.redis-headless.default.svc.cluster.local",
+					MasterPort:             6379,
+					MasterLinkStatus:       MasterLinkUp,
+					MasterLastIOSecondsAgo: 1,
+					SlaveReplOffset:        1500,
+					SlavePriority:          100,
+					SlaveReadOnly:          true,
+					MasterReplOffset:       1500,
+				},
+				Keyspace: map[string]KeyspaceInfo{},
+			},
+		},
+		{
+			name:    "invalid number",
+			raw:     "# Replication\nrole:master\nmaster_repl_offset:abc\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseInfo(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicationInfo(t *testing.T) {
+	slave := &ReplicationInfo{Role: RoleSlave, MasterLinkStatus: "down", SlaveReplOffset: 10, MasterReplOffset: 20}
+	if slave.IsMaster() || slave.IsLinkUp() {
+		t.Errorf("slave with link down reported as master or linked")
+	}
+	if slave.ProcessedOffset() != 10 {
+		t.Errorf("ProcessedOffset() = %d, want 10", slave.ProcessedOffset())
+	}
+
+	master := &ReplicationInfo{Role: RoleMaster, MasterReplOffset: 20}
+	if !master.IsMaster() || master.ProcessedOffset() != 20 {
+		t.Errorf("master not reported correctly: %+v", master)
+	}
+}
+
+func TestSupportsPauseWrite(t *testing.T) {
+	for version, want := range map[string]bool{
+		"7.0.5": true, "6.2.0": true, "6.0.16": false, "5.0.14": false, "10.0.0": true, "": false,
+	} {
+		server := &ServerInfo{RedisVersion: version}
+		if got := server.SupportsPauseWrite(); got != want {
+			t.Errorf("SupportsPauseWrite() for %q = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestParseModuleList(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply interface{}
+		want  []Module
+	}{
+		{
+			name: "RESP2",
+			reply: []interface{}{
+				[]interface{}{"name", "search", "ver", int64(20606), "path", "/redis/modules/redisearch.so", "args", []interface{}{}},
+				[]interface{}{"name", "ReJSON", "ver", int64(20407), "path", "/redis/modules/rejson.so", "args", []interface{}{}},
+			},
+			want: []Module{{Name: "ReJSON", Version: 20407}, {Name: "search", Version: 20606}},
+		},
+		{
+			name: "RESP3",
+			reply: []interface{}{
+				map[interface{}]interface{}{"name": "bf", "ver": int64(20405), "path": "/redis/modules/redisbloom.so"},
+			},
+			want: []Module{{Name: "bf", Version: 20405}},
+		},
+		{
+			name:  "no module",
+			reply: []interface{}{},
+			want:  []Module{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseModuleList(tt.reply)
+			if err != nil {
+				t.Fatalf("parseModuleList() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseModuleList() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
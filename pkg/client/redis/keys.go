@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v9"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// DumpedKey is a key serialized by DUMP with its remaining time to live, zero when it does not expire
+type DumpedKey struct {
+	Key   string
+	Value string
+	TTL   time.Duration
+}
+
+// ScanDump scans a batch of keys of db 0 from cursor and dumps them. Keys deleted or expired between
+// SCAN and DUMP are skipped. The returned cursor is 0 once the whole keyspace has been scanned.
+func (c *Client) ScanDump(ip string, port int32, password string, cursor uint64, count int64) ([]DumpedKey, uint64, error) {
+	ctx := context.Background()
+	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
+
+	keys, next, err := rclient.Scan(ctx, cursor, "", count).Result()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to scan keys")
+	}
+	if len(keys) == 0 {
+		return nil, next, nil
+	}
+
+	pipe := rclient.Pipeline()
+	dumps := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		dumps[i] = pipe.Dump(ctx, key)
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	// the error of every command is checked below, a missing key is not an error
+	_, _ = pipe.Exec(ctx)
+
+	dumped := make([]DumpedKey, 0, len(keys))
+	for i, key := range keys {
+		value, err := dumps[i].Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to dump key %q", key)
+		}
+		ttl, err := ttls[i].Result()
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to get ttl of key %q", key)
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+		dumped = append(dumped, DumpedKey{Key: key, Value: value, TTL: ttl})
+	}
+
+	return dumped, next, nil
+}
+
+// RestoreKeys restores dumped keys into db 0, replacing existing keys
+func (c *Client) RestoreKeys(ip string, port int32, password string, keys []DumpedKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
+
+	pipe := rclient.Pipeline()
+	for _, key := range keys {
+		pipe.RestoreReplace(ctx, key.Key, key.TTL, key.Value)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, "failed to restore keys")
+	}
+
+	return nil
+}
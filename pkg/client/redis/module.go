@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// Module is an entry of MODULE LIST
+type Module struct {
+	Name    string
+	Version int64
+}
+
+// parseModuleList parses the reply of MODULE LIST, every module is a flat key/value array in RESP2
+// and a map in RESP3. Modules are sorted by name.
+func parseModuleList(reply interface{}) ([]Module, error) {
+	entries, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("unexpected MODULE LIST reply %T", reply)
+	}
+
+	modules := make([]Module, 0, len(entries))
+	for _, entry := range entries {
+		fields := map[string]interface{}{}
+		switch e := entry.(type) {
+		case []interface{}:
+			for i := 0; i+1 < len(e); i += 2 {
+				fields[fmt.Sprint(e[i])] = e[i+1]
+			}
+		case map[interface{}]interface{}:
+			for k, v := range e {
+				fields[fmt.Sprint(k)] = v
+			}
+		default:
+			return nil, errors.Errorf("unexpected MODULE LIST entry %T", entry)
+		}
+
+		name, ok := fields["name"].(string)
+		if !ok {
+			return nil, errors.New("MODULE LIST entry has no name")
+		}
+		version, _ := fields["ver"].(int64)
+		modules = append(modules, Module{Name: name, Version: version})
+	}
+
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Name < modules[j].Name
+	})
+	return modules, nil
+}
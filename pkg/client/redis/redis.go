@@ -2,20 +2,43 @@ package redis
 
 import (
 	"context"
-	"fmt"
 	"github.com/go-redis/redis/v9"
 	"github.com/pkg/errors"
+	"net"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var _ Clienter = (*Client)(nil)
 
+// ErrNoMonitor is returned when the sentinel does not monitor mymaster, e.g. after SENTINEL REMOVE
+var ErrNoMonitor = errors.New("sentinel does not monitor mymaster")
+
 type Clienter interface {
-	GetReplication(ip string, port int32, password string) (string, error)
+	GetInfo(ip string, port int32, password string, section string) (*Info, error)
 	GetSentinelMonitor(sentinelIP string, password string) (string, string, error)
+	GetSentinelMaster(sentinelIP string, password string) (*SentinelMaster, error)
 	SetAsMaster(ip string, port int32, password string) error
 	SetAsSlave(slaveIP, masterIP string, port int32, password string) error
+	ReplicateFrom(ip string, port int32, password string, masterHost string, masterPort int32) error
 	SetSentinelMonitor(sentinelIP string, password string, monitor map[string]interface{}) error
+	RemoveSentinelMonitor(sentinelIP string, password string) error
+	SetSentinelOption(sentinelIP string, password string, option, value string) error
+	GetSentinelPeers(sentinelIP string, password string) ([]map[string]string, error)
+	GetSentinelReplicas(sentinelIP string, password string) ([]map[string]string, error)
+	CheckSentinelQuorum(sentinelIP string, password string) (bool, string, error)
+	ResetSentinel(sentinelIP string, password string) error
+	SentinelFailover(sentinelIP string, password string) error
+	PauseWrites(ip string, port int32, password string, timeout time.Duration) error
+	UnpauseWrites(ip string, port int32, password string) error
+	BackgroundSave(ip string, port int32, password string) error
+	ListModules(ip string, port int32, password string) ([]Module, error)
+	GetConfig(ip string, port int32, password string, parameter string) (string, error)
+	SetConfig(ip string, port int32, password string, parameter, value string) error
+	SetSentinelConfig(sentinelIP string, password string, parameter, value string) error
+	ScanDump(ip string, port int32, password string, cursor uint64, count int64) ([]DumpedKey, uint64, error)
+	RestoreKeys(ip string, port int32, password string, keys []DumpedKey) error
 }
 
 type Client struct{}
@@ -24,21 +47,27 @@ func NewClient() *Client {
 	return &Client{}
 }
 
-// Get info replication
-func (c *Client) GetReplication(ip string, port int32, password string) (string, error) {
+// Get info of the given section, an empty section returns the default sections
+func (c *Client) GetInfo(ip string, port int32, password string, section string) (*Info, error) {
 	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
 
-	info, err := rclient.Info(context.Background(), "replication").Result()
+	var sections []string
+	if section != "" {
+		sections = append(sections, section)
+	}
+	raw, err := rclient.Info(context.Background(), sections...).Result()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get replication info")
+		return nil, errors.Wrapf(err, "failed to get %s info", section)
 	}
 
-	return info, nil
+	return ParseInfo(raw)
 }
 
 // set to master
 func (c *Client) SetAsMaster(ip string, port int32, password string) error {
 	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
 
 	if err := rclient.SlaveOf(context.Background(), "NO", "ONE").Err(); err != nil {
 		return errors.Wrap(err, "failed to set as master")
@@ -46,23 +75,40 @@ func (c *Client) SetAsMaster(ip string, port int32, password string) error {
 	return nil
 }
 
-// set to slave
-func (c *Client) SetAsSlave(slaveIP, masterIP string, port int32, password string) error {
+// set to slave, masterHost may be an IP or a DNS name announced by the master
+func (c *Client) SetAsSlave(slaveIP, masterHost string, port int32, password string) error {
 	rclient := c.initClient(slaveIP, port, password)
+	defer rclient.Close()
 
-	if err := rclient.SlaveOf(context.Background(), masterIP, strconv.Itoa(int(port))).Err(); err != nil {
+	if err := rclient.SlaveOf(context.Background(), masterHost, strconv.Itoa(int(port))).Err(); err != nil {
 		return errors.Wrap(err, "failed to set as slave")
 	}
 
 	return nil
 }
 
+// set to slave of a master listening on another port, e.g. a redis outside the cluster
+func (c *Client) ReplicateFrom(ip string, port int32, password string, masterHost string, masterPort int32) error {
+	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
+
+	if err := rclient.SlaveOf(context.Background(), masterHost, strconv.Itoa(int(masterPort))).Err(); err != nil {
+		return errors.Wrapf(err, "failed to replicate from %s", net.JoinHostPort(masterHost, strconv.Itoa(int(masterPort))))
+	}
+
+	return nil
+}
+
 // return result: masterIP, masterPort, error
 func (c *Client) GetSentinelMonitor(sentinelIP string, password string) (string, string, error) {
 	ctx := context.Background()
 	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
 
 	monitorInfo, err := rclient.GetMasterAddrByName(ctx, "mymaster").Result()
+	if err == redis.Nil || isNoSuchMaster(err) {
+		return "", "", errors.Wrap(ErrNoMonitor, "failed to get sentinel monitor info")
+	}
 	if err != nil {
 		return "", "", errors.Wrap(err, "failed to get sentinel monitor info")
 	}
@@ -70,13 +116,28 @@ func (c *Client) GetSentinelMonitor(sentinelIP string, password string) (string,
 	return monitorInfo[0], monitorInfo[1], nil
 }
 
-func (c *Client) SetSentinelMonitor(sentinelIP string, password string, monitor map[string]interface{}) error {
-	ctx := context.Background()
+// SENTINEL MASTER mymaster, returns ErrNoMonitor when the sentinel does not monitor it
+func (c *Client) GetSentinelMaster(sentinelIP string, password string) (*SentinelMaster, error) {
 	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
 
-	if err := rclient.Remove(ctx, "mymaster").Err(); err != nil {
-		return errors.Wrap(err, "failed to remove monitoring master")
+	fields, err := rclient.Master(context.Background(), "mymaster").Result()
+	if isNoSuchMaster(err) {
+		return nil, errors.Wrap(ErrNoMonitor, "failed to get sentinel master")
 	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get sentinel master")
+	}
+
+	return ParseSentinelMaster(fields), nil
+}
+
+// SENTINEL MONITOR mymaster, sentinel replies "ERR Duplicated master name" when it already monitors it,
+// changing the address of a monitored master requires SENTINEL REMOVE first
+func (c *Client) SetSentinelMonitor(sentinelIP string, password string, monitor map[string]interface{}) error {
+	ctx := context.Background()
+	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
 
 	monitorIP := monitor["masterIP"].(string)
 	monitorPort := monitor["port"].(int32)
@@ -94,9 +155,215 @@ func (c *Client) SetSentinelMonitor(sentinelIP string, password string, monitor
 	return nil
 }
 
+// SENTINEL REMOVE mymaster, removing a master that is not monitored is not an error
+func (c *Client) RemoveSentinelMonitor(sentinelIP string, password string) error {
+	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
+
+	if err := rclient.Remove(context.Background(), "mymaster").Err(); err != nil && !isNoSuchMaster(err) {
+		return errors.Wrap(err, "failed to remove monitoring master")
+	}
+	return nil
+}
+
+// SENTINEL SET mymaster, changes an option of the monitored master without forgetting its replicas and sentinels
+func (c *Client) SetSentinelOption(sentinelIP string, password string, option, value string) error {
+	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
+
+	if err := rclient.Set(context.Background(), "mymaster", option, value).Err(); err != nil {
+		return errors.Wrapf(err, "failed to set sentinel option %s", option)
+	}
+	return nil
+}
+
+// sentinel replies "ERR No such master with that name" for a master it does not monitor
+func isNoSuchMaster(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "No such master")
+}
+
+// return the other sentinels known by this sentinel, returns ErrNoMonitor when the sentinel does not monitor mymaster
+func (c *Client) GetSentinelPeers(sentinelIP string, password string) ([]map[string]string, error) {
+	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
+
+	peers, err := rclient.Sentinels(context.Background(), "mymaster").Result()
+	if isNoSuchMaster(err) {
+		return nil, errors.Wrap(ErrNoMonitor, "failed to get sentinel peers")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get sentinel peers")
+	}
+
+	return peers, nil
+}
+
+// return the replicas of mymaster known by this sentinel, returns ErrNoMonitor when the sentinel does not monitor mymaster
+func (c *Client) GetSentinelReplicas(sentinelIP string, password string) ([]map[string]string, error) {
+	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
+
+	replicas, err := rclient.Replicas(context.Background(), "mymaster").Result()
+	if isNoSuchMaster(err) {
+		return nil, errors.Wrap(ErrNoMonitor, "failed to get sentinel replicas")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get sentinel replicas")
+	}
+
+	return replicas, nil
+}
+
+// SENTINEL CKQUORUM mymaster, returns false and the reason when the reachable sentinels can not reach
+// the quorum or the majority needed to authorize a failover
+func (c *Client) CheckSentinelQuorum(sentinelIP string, password string) (bool, string, error) {
+	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
+
+	reply, err := rclient.CkQuorum(context.Background(), "mymaster").Result()
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "NOQUORUM") {
+			return false, err.Error(), nil
+		}
+		return false, "", errors.Wrap(err, "failed to check sentinel quorum")
+	}
+
+	return true, reply, nil
+}
+
+// forget all known replicas and sentinels, they will be rediscovered in a few seconds
+func (c *Client) ResetSentinel(sentinelIP string, password string) error {
+	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
+
+	if err := rclient.Reset(context.Background(), "mymaster").Err(); err != nil {
+		return errors.Wrap(err, "failed to reset sentinel")
+	}
+
+	return nil
+}
+
+// force a failover without asking the other sentinels for agreement
+func (c *Client) SentinelFailover(sentinelIP string, password string) error {
+	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
+
+	if err := rclient.Failover(context.Background(), "mymaster").Err(); err != nil {
+		return errors.Wrap(err, "failed to fail over")
+	}
+
+	return nil
+}
+
+// CLIENT PAUSE WRITE, reads are still served, the pause ends by itself after timeout.
+// Redis older than 6.2 has neither the WRITE mode nor CLIENT UNPAUSE, pausing all commands would also
+// block the replication commands of the switchover, so it is refused there
+func (c *Client) PauseWrites(ip string, port int32, password string, timeout time.Duration) error {
+	ctx := context.Background()
+	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
+
+	raw, err := rclient.Info(ctx, "server").Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to get server info")
+	}
+	info, err := ParseInfo(raw)
+	if err != nil {
+		return err
+	}
+	if !info.Server.SupportsPauseWrite() {
+		return errors.Errorf("pausing writes needs redis 6.2 or later, running %s", info.Server.RedisVersion)
+	}
+
+	cmd := redis.NewStatusCmd(ctx, "client", "pause", timeout.Milliseconds(), "write")
+	_ = rclient.Process(ctx, cmd)
+	if err := cmd.Err(); err != nil {
+		return errors.Wrap(err, "failed to pause writes")
+	}
+
+	return nil
+}
+
+func (c *Client) UnpauseWrites(ip string, port int32, password string) error {
+	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
+
+	if err := rclient.ClientUnpause(context.Background()).Err(); err != nil {
+		return errors.Wrap(err, "failed to unpause writes")
+	}
+
+	return nil
+}
+
+// BGSAVE, progress is reported by the persistence section of INFO
+func (c *Client) BackgroundSave(ip string, port int32, password string) error {
+	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
+
+	if err := rclient.BgSave(context.Background()).Err(); err != nil {
+		return errors.Wrap(err, "failed to start background save")
+	}
+
+	return nil
+}
+
+// MODULE LIST, sorted by module name
+func (c *Client) ListModules(ip string, port int32, password string) ([]Module, error) {
+	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
+
+	reply, err := rclient.Do(context.Background(), "MODULE", "LIST").Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list modules")
+	}
+
+	return parseModuleList(reply)
+}
+
+// CONFIG GET for a single parameter
+func (c *Client) GetConfig(ip string, port int32, password string, parameter string) (string, error) {
+	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
+
+	res, err := rclient.ConfigGet(context.Background(), parameter).Result()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get config %s", parameter)
+	}
+
+	return res[parameter], nil
+}
+
+// CONFIG SET, only affects the running instance
+func (c *Client) SetConfig(ip string, port int32, password string, parameter, value string) error {
+	rclient := c.initClient(ip, port, password)
+	defer rclient.Close()
+
+	if err := rclient.ConfigSet(context.Background(), parameter, value).Err(); err != nil {
+		return errors.Wrapf(err, "failed to set config %s", parameter)
+	}
+
+	return nil
+}
+
+// SENTINEL CONFIG SET, sentinel persists it into its own config file
+func (c *Client) SetSentinelConfig(sentinelIP string, password string, parameter, value string) error {
+	ctx := context.Background()
+	rclient := c.initClientForSentinel(sentinelIP, password)
+	defer rclient.Close()
+
+	cmd := redis.NewStatusCmd(ctx, "sentinel", "config", "set", parameter, value)
+	_ = rclient.Process(ctx, cmd)
+	if err := cmd.Err(); err != nil {
+		return errors.Wrapf(err, "failed to set sentinel config %s", parameter)
+	}
+
+	return nil
+}
+
+// JoinHostPort brackets IPv6 literals, "host:port" is ambiguous for them
 func (c *Client) initClient(ip string, port int32, password string) *redis.Client {
 	rClient := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", ip, port),
+		Addr:     net.JoinHostPort(ip, strconv.Itoa(int(port))),
 		Password: password,
 	})
 
@@ -105,7 +372,7 @@ func (c *Client) initClient(ip string, port int32, password string) *redis.Clien
 
 func (c *Client) initClientForSentinel(ip string, password string) *redis.SentinelClient {
 	rClient := redis.NewSentinelClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%d", ip, 26379),
+		Addr:     net.JoinHostPort(ip, strconv.Itoa(26379)),
 		Password: password,
 	})
 
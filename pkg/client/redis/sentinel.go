@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SentinelFlagFailoverInProgress is reported by SENTINEL MASTER while sentinel fails over the master
+const SentinelFlagFailoverInProgress = "failover_in_progress"
+
+// SentinelMaster is the state of mymaster as seen by one sentinel, as reported by SENTINEL MASTER
+type SentinelMaster struct {
+	IP          string
+	Port        string
+	Flags       []string
+	Quorum      int
+	ConfigEpoch int64
+	NumSlaves   int
+	// Number of the other sentinels monitoring the same master
+	NumOtherSentinels int
+}
+
+// ParseSentinelMaster parses the field-value reply of SENTINEL MASTER
+func ParseSentinelMaster(fields map[string]string) *SentinelMaster {
+	master := &SentinelMaster{
+		IP:   fields["ip"],
+		Port: fields["port"],
+	}
+	if flags := fields["flags"]; flags != "" {
+		master.Flags = strings.Split(flags, ",")
+	}
+	master.Quorum, _ = strconv.Atoi(fields["quorum"])
+	master.ConfigEpoch, _ = strconv.ParseInt(fields["config-epoch"], 10, 64)
+	master.NumSlaves, _ = strconv.Atoi(fields["num-slaves"])
+	master.NumOtherSentinels, _ = strconv.Atoi(fields["num-other-sentinels"])
+
+	return master
+}
+
+func (m *SentinelMaster) HasFlag(flag string) bool {
+	for _, f := range m.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFailoverInProgress reports whether the sentinel is failing over the master, from the election of a leader
+// until every replica has been reconfigured, the master address it reports may change at any time meanwhile
+func (m *SentinelMaster) IsFailoverInProgress() bool {
+	return m.HasFlag(SentinelFlagFailoverInProgress)
+}
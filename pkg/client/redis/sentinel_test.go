@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSentinelMaster(t *testing.T) {
+	tests := []struct {
+		name           string
+		fields         map[string]string
+		want           *SentinelMaster
+		wantInProgress bool
+	}{
+		{
+			name: "monitoring",
+			fields: map[string]string{
+				"name": "mymaster", "ip": "redis-0.redis-headless.default.svc.cluster.local", "port": "6379",
+				"flags": "master", "quorum": "2", "config-epoch": "3", "num-slaves": "2", "num-other-sentinels": "2",
+			},
+			want: &SentinelMaster{
+				IP: "redis-0.redis-headless.default.svc.cluster.local", Port: "6379", Flags: []string{"master"},
+				Quorum: 2, ConfigEpoch: 3, NumSlaves: 2, NumOtherSentinels: 2,
+			},
+		},
+		{
+			name: "failing over",
+			fields: map[string]string{
+				"name": "mymaster", "ip": "10.0.0.1", "port": "6379", "flags": "s_down,o_down,master,failover_in_progress", "quorum": "2",
+			},
+			want: &SentinelMaster{
+				IP: "10.0.0.1", Port: "6379", Flags: []string{"s_down", "o_down", "master", "failover_in_progress"}, Quorum: 2,
+			},
+			wantInProgress: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseSentinelMaster(tt.fields)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSentinelMaster() = %+v, want %+v", got, tt.want)
+			}
+			if got.IsFailoverInProgress() != tt.wantInProgress {
+				t.Errorf("IsFailoverInProgress() = %v, want %v", got.IsFailoverInProgress(), tt.wantInProgress)
+			}
+		})
+	}
+}
@@ -3,38 +3,61 @@ package controller
 import (
 	"github.com/go-logr/logr"
 	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
 	"github.com/hongqchen/redis-operator/pkg/service"
-	"github.com/hongqchen/redis-operator/pkg/util"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"time"
 )
 
 type RedisHandler struct {
-	logger logr.Logger
-	ensure service.Ensurer
-	check  service.CheckAndHealer
+	logger  logr.Logger
+	ensure  service.Ensurer
+	check   service.CheckAndHealer
+	observe service.Observer
 }
 
-func NewRedisHandler(cl client.Client, logger logr.Logger) *RedisHandler {
+func NewRedisHandler(cl client.Client, rcl redis.Clienter, logger logr.Logger) *RedisHandler {
 	return &RedisHandler{
-		logger: logger,
-		ensure: service.NewEnsure(cl, logger),
-		check:  service.NewCheckAndHeal(cl, logger),
+		logger:  logger,
+		ensure:  service.NewEnsure(cl, rcl, logger),
+		check:   service.NewCheckAndHeal(cl, rcl, logger),
+		observe: service.NewObserve(cl, rcl, logger),
 	}
 }
 
-func (rh *RedisHandler) Sync(cRedis *v1beta1.CustomRedis) time.Duration {
+// Sync 将集群调整到期望状态，返回的错误由调用方分类并决定重试间隔
+func (rh *RedisHandler) Sync(cRedis *v1beta1.CustomRedis) error {
+	var err error
 	// 判断不同模式集群
 	switch cRedis.Spec.ClusterMode {
 	case v1beta1.MasterSlave:
 		rh.logger.V(1).Info("Starting master-slave resource sync action")
-		return util.ErrorHandle(rh.logger, rh.syncMasterSlave(cRedis))
+		err = rh.syncMasterSlave(cRedis)
 	case v1beta1.Sentinel:
 		rh.logger.V(1).Info("Starting sentinel resource sync action")
-		return util.ErrorHandle(rh.logger, rh.syncSentinel(cRedis))
+		err = rh.syncSentinel(cRedis)
+	default:
+		return nil
 	}
 
-	return 0
+	// 集群状态正常后，执行用户请求的主从切换
+	if err == nil {
+		err = rh.ensure.EnsureSwitchover(cRedis)
+	}
+	// 开启 spec.autoRestart 时，在维护窗口内滚动更新 Pod
+	if err == nil {
+		err = rh.ensure.EnsureRollingUpdate(cRedis)
+	}
+	// 滚动更新完成后，确认模块均已加载，否则不标记为 Running
+	if err == nil {
+		err = rh.check.CheckModules(cRedis)
+	}
+
+	return err
+}
+
+// Observe 刷新 status 中观测到的集群状态，暂停时同样执行
+func (rh *RedisHandler) Observe(cRedis *v1beta1.CustomRedis) error {
+	return rh.observe.ObserveStatus(cRedis)
 }
 
 func (rh *RedisHandler) syncMasterSlave(cRedis *v1beta1.CustomRedis) error {
@@ -57,6 +80,29 @@ func (rh *RedisHandler) syncMasterSlave(cRedis *v1beta1.CustomRedis) error {
 	if err := rh.ensure.EnsureService(cRedis); err != nil {
 		return err
 	}
+	if err := rh.ensure.EnsureMaxMemory(cRedis); err != nil {
+		return err
+	}
+	// 备用集群复制外部 master，不在本地选举 master
+	if cRedis.IsStandby() {
+		// 先停止 sentinel 监听，避免原 master 降为 slave 后 sentinel 发起故障转移
+		if cRedis.Spec.ClusterMode == v1beta1.Sentinel {
+			if err := rh.ensure.EnsureSentinelMonitorRemoved(cRedis); err != nil {
+				return err
+			}
+		}
+		if err := rh.ensure.EnsureReplicaOf(cRedis); err != nil {
+			return err
+		}
+		if err := rh.ensure.EnsureLabels(cRedis); err != nil {
+			return err
+		}
+		return rh.ensure.EnsureExternalAnnounce(cRedis)
+	}
+	// 删除 spec.replicaOf 后，提升备用 Pod 为 master
+	if err := rh.ensure.EnsurePromotion(cRedis); err != nil {
+		return err
+	}
 	// 调用 check 方法，确保状态符合预期
 	if err := rh.check.CheckNumberOfMasters(cRedis); err != nil {
 		return err
@@ -67,6 +113,10 @@ func (rh *RedisHandler) syncMasterSlave(cRedis *v1beta1.CustomRedis) error {
 	if err := rh.ensure.EnsureLabels(cRedis); err != nil {
 		return err
 	}
+	// 外部地址尚未分配时返回 ExternalAddressPendingErr，放在最后，不阻塞主从关系的修复
+	if err := rh.ensure.EnsureExternalAnnounce(cRedis); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -75,15 +125,31 @@ func (rh *RedisHandler) syncSentinel(cRedis *v1beta1.CustomRedis) error {
 	if err := rh.syncMasterSlave(cRedis); err != nil {
 		return err
 	}
-	if err := rh.ensure.EnsureDeployment(cRedis); err != nil {
+	if err := rh.ensure.EnsureSentinelStatefulset(cRedis); err != nil {
 		return err
 	}
-	if err := rh.ensure.EnsurePodReadyForDeployment(cRedis); err != nil {
+	if err := rh.ensure.EnsurePodReadyForSentinel(cRedis); err != nil {
+		return err
+	}
+	if err := rh.ensure.EnsureLegacySentinelRemoved(cRedis); err != nil {
 		return err
 	}
 	if err := rh.ensure.EnsurePodOwnerForSentinel(cRedis); err != nil {
 		return err
 	}
+	if err := rh.ensure.EnsureExternalAnnounceForSentinel(cRedis); err != nil {
+		return err
+	}
+	// 新建的 sentinel 会监听配置文件中的占位地址，同样需要移除
+	if cRedis.IsStandby() {
+		if err := rh.ensure.EnsureSentinelMonitorRemoved(cRedis); err != nil {
+			return err
+		}
+		if err := rh.ensure.EnsureLabelsForSentinel(cRedis); err != nil {
+			return err
+		}
+		return rh.check.CheckSentinels(cRedis)
+	}
 	if err := rh.ensure.EnsureSentinelMonitor(cRedis); err != nil {
 		return err
 	}
@@ -93,6 +159,10 @@ func (rh *RedisHandler) syncSentinel(cRedis *v1beta1.CustomRedis) error {
 	if err := rh.ensure.EnsureLabelsForSentinel(cRedis); err != nil {
 		return err
 	}
+	// 检查 sentinel 之间是否一致，清除已不存在的 sentinel 与 replica
+	if err := rh.check.CheckSentinels(cRedis); err != nil {
+		return err
+	}
 
 	return nil
 }
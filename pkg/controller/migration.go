@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"github.com/hongqchen/redis-operator/pkg/service"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type MigrationHandler struct {
+	logger  logr.Logger
+	migrate service.Migrator
+}
+
+func NewMigrationHandler(cl client.Client, rcl redis.Clienter, logger logr.Logger) *MigrationHandler {
+	return &MigrationHandler{
+		logger:  logger,
+		migrate: service.NewMigrate(cl, rcl, logger),
+	}
+}
+
+// Sync 推进一次迁移，进度写入 rm.Status，由调用方持久化
+func (mh *MigrationHandler) Sync(rm *v1beta1.RedisMigration) error {
+	if rm.IsFinished() {
+		return nil
+	}
+
+	cRedis, err := mh.migrate.GetDestination(rm)
+	if err != nil {
+		return err
+	}
+	if rm.Status.Phase == "" || rm.Status.Phase == v1beta1.MigrationPending {
+		now := metav1.Now()
+		rm.Status.Phase = v1beta1.MigrationSyncing
+		rm.Status.StartTime = &now
+	}
+
+	switch rm.Spec.Method {
+	case v1beta1.MigrationDump:
+		mh.logger.V(1).Info("Starting dump migration sync action")
+		err = mh.syncDump(rm, cRedis)
+	default:
+		mh.logger.V(1).Info("Starting replication migration sync action")
+		err = mh.syncReplication(rm, cRedis)
+	}
+	if err != nil {
+		return err
+	}
+
+	rm.Status.Message = ""
+	return nil
+}
+
+// syncReplication 目标集群作为备用集群复制源实例，复制链路建立后为 Synced，切换时提升目标集群
+func (mh *MigrationHandler) syncReplication(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error {
+	if rm.Status.Phase != v1beta1.MigrationCuttingOver {
+		// 上一次观察到的复制延迟，连续两次为 0 时才允许切换
+		caughtUp := rm.Status.Phase == v1beta1.MigrationSynced && rm.Status.LagBytes == 0
+		if err := mh.migrate.EnsureReplicaOf(rm, cRedis); err != nil {
+			return err
+		}
+		if err := mh.migrate.ObserveReplication(rm, cRedis); err != nil {
+			return err
+		}
+
+		// 复制链路断开时回到 Syncing，重新全量同步前不允许切换
+		rm.Status.Phase = v1beta1.MigrationSyncing
+		if rm.Status.LinkStatus == redis.MasterLinkUp {
+			rm.Status.Phase = v1beta1.MigrationSynced
+		}
+		if !rm.Spec.Cutover || rm.Status.Phase != v1beta1.MigrationSynced {
+			return nil
+		}
+		// 切换前需追平源实例，避免丢失尚未复制的写入
+		if !caughtUp || rm.Status.LagBytes > 0 {
+			mh.logger.V(1).Info("Waiting for the destination to catch up before cutting over", "destination", cRedis.Name, "lagBytes", rm.Status.LagBytes)
+			return nil
+		}
+
+		mh.logger.Info("Cutting over", "destination", cRedis.Name, "lagBytes", rm.Status.LagBytes)
+		rm.Status.Phase = v1beta1.MigrationCuttingOver
+	}
+
+	if err := mh.migrate.EnsureDetached(rm, cRedis); err != nil {
+		return err
+	}
+	// 等待 CustomRedis controller 处理删除 replicaOf 后的 generation，并提升出新的 master
+	if cRedis.Status.ObservedGeneration < cRedis.Generation || cRedis.Status.Standby != nil || cRedis.Status.Master == "" {
+		mh.logger.V(2).Info("Waiting for the destination to be promoted", "destination", cRedis.Name)
+		return nil
+	}
+
+	rm.Status.LinkStatus = ""
+	rm.Status.LagBytes = 0
+	if err := mh.migrate.ObserveKeys(rm, cRedis); err != nil {
+		return err
+	}
+	mh.complete(rm)
+	return nil
+}
+
+// syncDump 每次 reconcile 复制一批 key，一轮扫描完成后为 Synced；切换时再完整扫描一轮，补齐第一轮之后写入的 key
+func (mh *MigrationHandler) syncDump(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error {
+	// 备用集群的 master 只读，且数据会被复制链路覆盖
+	if cRedis.Spec.ReplicaOf != nil {
+		return errors.Wrapf(util.MigrationConflictErr, "customredis %s has spec.replicaOf set", cRedis.Name)
+	}
+
+	if rm.Status.Phase == v1beta1.MigrationSynced {
+		if !rm.Spec.Cutover {
+			return mh.migrate.ObserveKeys(rm, cRedis)
+		}
+		mh.logger.Info("Cutting over, copying the keys one last time", "destination", cRedis.Name)
+		rm.Status.Phase = v1beta1.MigrationCuttingOver
+		rm.Status.CopiedKeys = 0
+		rm.Status.Cursor = ""
+	}
+
+	done, err := mh.migrate.CopyKeys(rm, cRedis)
+	if err != nil {
+		return err
+	}
+	if err := mh.migrate.ObserveKeys(rm, cRedis); err != nil {
+		return err
+	}
+	if !done {
+		return nil
+	}
+
+	if rm.Status.Phase == v1beta1.MigrationCuttingOver {
+		mh.complete(rm)
+		return nil
+	}
+	mh.logger.Info("Initial copy finished", "destination", cRedis.Name, "copiedKeys", rm.Status.CopiedKeys)
+	rm.Status.Phase = v1beta1.MigrationSynced
+	return nil
+}
+
+func (mh *MigrationHandler) complete(rm *v1beta1.RedisMigration) {
+	now := metav1.Now()
+	rm.Status.Phase = v1beta1.MigrationCompleted
+	rm.Status.CompletionTime = &now
+	mh.logger.Info("Migration completed", "sourceKeys", rm.Status.SourceKeys, "destinationKeys", rm.Status.DestinationKeys)
+}
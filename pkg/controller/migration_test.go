@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+const migrationSource = "192.168.0.10"
+
+// TestMigration migrates the keys of an external instance into a running cluster, writes to the
+// source once the destination is synced and checks they are present after the cutover.
+func TestMigration(t *testing.T) {
+	for _, method := range []v1beta1.MigrationMethod{v1beta1.MigrationReplication, v1beta1.MigrationDump} {
+		t.Run(string(method), func(t *testing.T) {
+			w := newWorld(t, v1beta1.Sentinel)
+			w.bootstrap(t)
+			w.reconcile(t)
+
+			w.redis.AddNode(migrationSource)
+			for i := 0; i < 5; i++ {
+				if err := w.redis.SetKey(migrationSource, fmt.Sprintf("key-%d", i), "value"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rm := &v1beta1.RedisMigration{
+				ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: w.cRedis.Namespace},
+				Spec: v1beta1.RedisMigrationSpec{
+					Destination: w.cRedis.Name,
+					Source:      v1beta1.ReplicaOfSpec{Host: migrationSource, Port: 6379},
+					Method:      method,
+					BatchSize:   2,
+				},
+			}
+			mh := NewMigrationHandler(w.k8s, w.redis, logr.Discard())
+
+			w.migrateUntil(t, mh, rm, v1beta1.MigrationSynced)
+			if rm.Status.SourceKeys != 5 || rm.Status.DestinationKeys != 5 {
+				t.Fatalf("synced with %d source keys and %d destination keys, want 5", rm.Status.SourceKeys, rm.Status.DestinationKeys)
+			}
+
+			if err := w.redis.SetKey(migrationSource, "key-5", "value"); err != nil {
+				t.Fatal(err)
+			}
+			rm.Spec.Cutover = true
+			w.migrateUntil(t, mh, rm, v1beta1.MigrationCompleted)
+
+			if rm.Status.DestinationKeys != 6 || rm.Status.CompletionTime == nil {
+				t.Fatalf("completed with %d destination keys, completion time %v", rm.Status.DestinationKeys, rm.Status.CompletionTime)
+			}
+			if w.cRedis.Spec.ReplicaOf != nil || w.cRedis.Status.Standby != nil {
+				t.Fatalf("destination is still a standby: %+v", w.cRedis.Spec.ReplicaOf)
+			}
+			if msg := w.converged(t); msg != "" {
+				t.Fatalf("destination not converged after the cutover: %s", msg)
+			}
+			if node, _ := w.redis.Node(w.podIP(t, w.master(t))); len(node.Keys) != 6 {
+				t.Fatalf("destination master has %d keys, want 6", len(node.Keys))
+			}
+		})
+	}
+}
+
+// TestMigrationCutoverWaitsForLag cuts over only once the standby pod has caught up with the source
+// on two consecutive observations.
+func TestMigrationCutoverWaitsForLag(t *testing.T) {
+	w := newWorld(t, v1beta1.Sentinel)
+	w.bootstrap(t)
+	w.reconcile(t)
+
+	w.redis.AddNode(migrationSource)
+	if err := w.redis.SetKey(migrationSource, "key-0", "value"); err != nil {
+		t.Fatal(err)
+	}
+	rm := &v1beta1.RedisMigration{
+		ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: w.cRedis.Namespace},
+		Spec: v1beta1.RedisMigrationSpec{
+			Destination: w.cRedis.Name,
+			Source:      v1beta1.ReplicaOfSpec{Host: migrationSource, Port: 6379},
+			Method:      v1beta1.MigrationReplication,
+		},
+	}
+	mh := NewMigrationHandler(w.k8s, w.redis, logr.Discard())
+	w.migrateUntil(t, mh, rm, v1beta1.MigrationSynced)
+
+	standby := w.podIP(t, w.cRedis.Status.Standby.Pod)
+	if err := w.redis.SetKey(migrationSource, "key-1", "value"); err != nil {
+		t.Fatal(err)
+	}
+	rm.Spec.Cutover = true
+	for _, lag := range []int64{100, 0} {
+		w.redis.SetLag(standby, lag)
+		if err := mh.Sync(rm); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+		if rm.Status.Phase != v1beta1.MigrationSynced || rm.Status.LagBytes != lag {
+			t.Fatalf("migration is %s with %d lag bytes, want Synced with %d", rm.Status.Phase, rm.Status.LagBytes, lag)
+		}
+	}
+	if err := mh.Sync(rm); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if rm.Status.Phase != v1beta1.MigrationCuttingOver {
+		t.Fatalf("migration is %s after two observations without lag, want CuttingOver", rm.Status.Phase)
+	}
+}
+
+func TestMigrationConflict(t *testing.T) {
+	w := newWorld(t, v1beta1.Sentinel)
+	w.bootstrap(t)
+	w.cRedis.Spec.ReplicaOf = &v1beta1.ReplicaOfSpec{Host: "192.168.0.20", Port: 6379}
+	if err := w.k8s.Update(context.TODO(), w.cRedis); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := &v1beta1.RedisMigration{
+		ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: w.cRedis.Namespace},
+		Spec: v1beta1.RedisMigrationSpec{
+			Destination: w.cRedis.Name,
+			Source:      v1beta1.ReplicaOfSpec{Host: migrationSource, Port: 6379},
+		},
+	}
+	err := NewMigrationHandler(w.k8s, w.redis, logr.Discard()).Sync(rm)
+	if !errors.Is(err, util.MigrationConflictErr) {
+		t.Fatalf("Sync() = %v, want %v", err, util.MigrationConflictErr)
+	}
+}
+
+// migrateUntil alternates migration and cluster reconciles until the migration reaches phase
+func (w *world) migrateUntil(t *testing.T, mh *MigrationHandler, rm *v1beta1.RedisMigration, phase v1beta1.MigrationPhase) {
+	t.Helper()
+
+	var err error
+	for round := 0; round < 10; round++ {
+		if err = mh.Sync(rm); err == nil && rm.Status.Phase == phase {
+			return
+		}
+		w.reconcile(t)
+	}
+	t.Fatalf("migration is %s after 10 reconciles, want %s, last error: %v", rm.Status.Phase, phase, err)
+}
+
+// reconcile reloads spec.replicaOf changed by the migration, reconciles the cluster and stores its status
+func (w *world) reconcile(t *testing.T) {
+	t.Helper()
+
+	stored := &v1beta1.CustomRedis{}
+	if err := w.k8s.Get(context.TODO(), client.ObjectKeyFromObject(w.cRedis), stored); err != nil {
+		t.Fatal(err)
+	}
+	w.cRedis.ObjectMeta = stored.ObjectMeta
+	w.cRedis.Spec.ReplicaOf = stored.Spec.ReplicaOf
+
+	if err := w.handler.Sync(w.cRedis); err != nil {
+		t.Logf("cluster reconcile: %v", err)
+	}
+	w.startPods(t)
+	if err := w.handler.Observe(w.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.k8s.Update(context.TODO(), w.cRedis); err != nil {
+		t.Fatal(err)
+	}
+}
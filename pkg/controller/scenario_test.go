@@ -0,0 +1,431 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis/fake"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	k8sfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+	"time"
+)
+
+// maxReconciles is the number of reconciles a scenario has to converge in after its fault is injected
+const maxReconciles = 5
+
+// TestScenarios injects faults into a running cluster and checks that reconciling converges to
+// exactly one master, with every other redis pod replicating from it and every sentinel monitoring it.
+func TestScenarios(t *testing.T) {
+	tests := []struct {
+		name string
+		mode v1beta1.ClusterMode
+		// fault is injected once the cluster is running
+		fault func(t *testing.T, w *world)
+		// tick runs before every reconcile, e.g. to clear a fault after some time
+		tick func(t *testing.T, w *world, round int)
+	}{
+		{
+			name: "master pod deleted while a replica is syncing",
+			mode: v1beta1.Sentinel,
+			fault: func(t *testing.T, w *world) {
+				master := w.master(t)
+				w.write(t, 100)
+				// the new replica has not caught up yet when the master goes away
+				w.redis.SetLoading(w.podIP(t, w.redisPod(2)), true)
+				w.write(t, 50)
+				w.redis.SetLoading(w.podIP(t, w.redisPod(2)), false)
+
+				w.redis.SetDown(w.podIP(t, master), true)
+				if _, err := w.redis.Failover(); err != nil {
+					t.Fatal(err)
+				}
+				w.deletePod(t, master)
+			},
+		},
+		{
+			name: "two masters after a network partition",
+			mode: v1beta1.Sentinel,
+			fault: func(t *testing.T, w *world) {
+				w.write(t, 100)
+				// a replica cut off from the sentinels promoted itself and accepted writes
+				partitioned := w.podIP(t, w.slaves(t)[0])
+				if err := w.redis.SetAsMaster(partitioned, 6379, ""); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.redis.Write(partitioned, 10); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "sentinels point at a dead IP",
+			mode: v1beta1.Sentinel,
+			fault: func(t *testing.T, w *world) {
+				w.monitor(t, "10.9.9.9")
+			},
+		},
+		{
+			name: "replica stuck loading",
+			mode: v1beta1.Sentinel,
+			fault: func(t *testing.T, w *world) {
+				w.write(t, 100)
+				// the replica restarted without replicaof in its config and is loading its RDB
+				replica := w.podIP(t, w.slaves(t)[0])
+				w.redis.Restart(replica)
+				w.redis.SetLoading(replica, true)
+			},
+			tick: func(t *testing.T, w *world, round int) {
+				if round == 2 {
+					for _, pod := range w.pods(t, w.cRedis.Name) {
+						w.redis.SetLoading(pod.Status.PodIP, false)
+					}
+				}
+			},
+		},
+		{
+			name: "standby promoted after a migration",
+			mode: v1beta1.Sentinel,
+			fault: func(t *testing.T, w *world) {
+				w.redis.AddNode("192.168.0.10")
+				if err := w.redis.Write("192.168.0.10", 100); err != nil {
+					t.Fatal(err)
+				}
+				w.cRedis.Spec.ReplicaOf = &v1beta1.ReplicaOfSpec{Host: "192.168.0.10", Port: 6379}
+			},
+			tick: func(t *testing.T, w *world, round int) {
+				if round != 2 {
+					return
+				}
+				// the cluster mirrors the external master before it is promoted
+				standby := w.cRedis.Status.Standby
+				if standby == nil {
+					t.Fatal("status.standby is not set after two reconciles")
+				}
+				if node, _ := w.redis.Node(w.podIP(t, standby.Pod)); node.MasterHost != "192.168.0.10" || node.Offset != 100 {
+					t.Fatalf("standby pod %s replicates from %q at offset %d", standby.Pod, node.MasterHost, node.Offset)
+				}
+				w.cRedis.Spec.ReplicaOf = nil
+			},
+		},
+		{
+			name: "scaling up master-slave",
+			mode: v1beta1.MasterSlave,
+			fault: func(t *testing.T, w *world) {
+				w.write(t, 100)
+				replicas := int32(4)
+				w.cRedis.Spec.Replicas = &replicas
+				w.cRedis.Status.Phase = util.CustomRedisScaling
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWorld(t, tt.mode)
+			w.bootstrap(t)
+
+			tt.fault(t, w)
+
+			for round := 0; round < maxReconciles; round++ {
+				if tt.tick != nil {
+					tt.tick(t, w, round)
+				}
+				err := w.handler.Sync(w.cRedis)
+				w.startPods(t)
+				if err == nil && w.converged(t) == "" {
+					return
+				}
+			}
+			t.Fatalf("not converged after %d reconciles: %s", maxReconciles, w.converged(t))
+		})
+	}
+}
+
+// world plays the API server, the statefulset controller, the kubelet and redis for the handler
+type world struct {
+	cRedis  *v1beta1.CustomRedis
+	k8s     client.Client
+	redis   *fake.Client
+	handler *RedisHandler
+	nextIP  int
+}
+
+func newWorld(t *testing.T, mode v1beta1.ClusterMode) *world {
+	t.Helper()
+
+	replicas := int32(3)
+	sentinelNum := int32(3)
+	cRedis := &v1beta1.CustomRedis{
+		ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "default", UID: "uid"},
+		Spec: v1beta1.CustomRedisSpec{
+			Replicas:    &replicas,
+			ClusterMode: mode,
+			RedisConfig: map[string]string{"port": "6379"},
+			SentinelNum: &sentinelNum,
+			Templates:   v1beta1.PodConfig{Image: "redis:7.0", InitImage: "busybox:1.28"},
+		},
+		Status: v1beta1.CustomRedisStatus{Phase: util.CustomRedisCreating},
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	k8sClient := k8sfake.NewClientBuilder().WithScheme(scheme).WithObjects(cRedis).Build()
+	redisClient := fake.NewClient()
+	return &world{
+		cRedis:  cRedis,
+		k8s:     k8sClient,
+		redis:   redisClient,
+		handler: NewRedisHandler(k8sClient, redisClient, logr.Discard()),
+	}
+}
+
+// bootstrap reconciles a new cluster until it is running
+func (w *world) bootstrap(t *testing.T) {
+	t.Helper()
+
+	for round := 0; round < 10; round++ {
+		err := w.handler.Sync(w.cRedis)
+		w.startPods(t)
+		if err == nil {
+			if msg := w.converged(t); msg != "" {
+				t.Fatalf("bootstrap: %s", msg)
+			}
+			w.cRedis.Status.Phase = util.CustomRedisRunning
+			// the controller refreshes the observed status after every reconcile
+			if err := w.handler.Observe(w.cRedis); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+	t.Fatal("bootstrap: cluster is not running after 10 reconciles")
+}
+
+// startPods creates the missing pods of every statefulset, new pods get a new IP and start as empty masters
+func (w *world) startPods(t *testing.T) {
+	t.Helper()
+
+	for _, stsName := range []string{w.cRedis.Name, fmt.Sprintf("%s-%s", w.cRedis.Name, util.SentinelResourceSuffix)} {
+		sts := &appv1.StatefulSet{}
+		if err := w.k8s.Get(context.TODO(), types.NamespacedName{Name: stsName, Namespace: w.cRedis.Namespace}, sts); err != nil {
+			if apierror.IsNotFound(err) {
+				continue
+			}
+			t.Fatal(err)
+		}
+
+		for i := 0; i < int(*sts.Spec.Replicas); i++ {
+			podName := fmt.Sprintf("%s-%d", sts.Name, i)
+			if err := w.k8s.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: sts.Namespace}, &corev1.Pod{}); err == nil {
+				continue
+			}
+
+			w.nextIP++
+			ip := fmt.Sprintf("10.0.0.%d", w.nextIP)
+			labels := make(map[string]string, len(sts.Spec.Selector.MatchLabels))
+			for k, v := range sts.Spec.Selector.MatchLabels {
+				labels[k] = v
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              podName,
+					Namespace:         sts.Namespace,
+					Labels:            labels,
+					CreationTimestamp: metav1.NewTime(time.Unix(1600000000+int64(w.nextIP), 0)),
+				},
+				Spec: corev1.PodSpec{Hostname: podName, Subdomain: sts.Spec.ServiceName},
+				Status: corev1.PodStatus{
+					Phase:  corev1.PodRunning,
+					PodIP:  ip,
+					PodIPs: []corev1.PodIP{{IP: ip}},
+					Conditions: []corev1.PodCondition{
+						{Type: corev1.PodInitialized, Status: corev1.ConditionTrue},
+						{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+					},
+				},
+			}
+			if err := w.k8s.Create(context.TODO(), pod); err != nil {
+				t.Fatal(err)
+			}
+
+			if stsName != w.cRedis.Name {
+				// sentinel.conf starts with a placeholder monitor
+				w.redis.AddSentinel(ip)
+				monitor := map[string]interface{}{"masterIP": util.SentinelPlaceholderIPv4, "port": int32(6379), "quorum": "2"}
+				if err := w.redis.SetSentinelMonitor(ip, "", monitor); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			fqdn := util.GetPodFQDN(podName, sts.Spec.ServiceName, sts.Namespace)
+			w.redis.AddNode(ip, fqdn)
+			if err := w.redis.SetConfig(ip, 6379, "", "replica-announce-ip", fqdn); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// converged returns why the cluster has not converged, an empty string once it has
+func (w *world) converged(t *testing.T) string {
+	t.Helper()
+
+	pods := w.pods(t, w.cRedis.Name)
+	if len(pods) != int(*w.cRedis.Spec.Replicas) {
+		return fmt.Sprintf("%d redis pods, want %d", len(pods), *w.cRedis.Spec.Replicas)
+	}
+
+	var master *corev1.Pod
+	for i := range pods {
+		info, err := w.redis.GetInfo(pods[i].Status.PodIP, 6379, "", "replication")
+		if err != nil {
+			return err.Error()
+		}
+		if info.Replication.IsMaster() {
+			if master != nil {
+				return fmt.Sprintf("%s and %s are both masters", master.Name, pods[i].Name)
+			}
+			master = &pods[i]
+		}
+	}
+	if master == nil {
+		return "no master"
+	}
+
+	for _, pod := range pods {
+		if pod.Name == master.Name {
+			continue
+		}
+		info, err := w.redis.GetInfo(pod.Status.PodIP, 6379, "", "replication")
+		if err != nil {
+			return err.Error()
+		}
+		if !info.Replication.IsLinkUp() {
+			return fmt.Sprintf("%s is not linked to a master, link %q", pod.Name, info.Replication.MasterLinkStatus)
+		}
+		if node, _ := w.redis.Node(info.Replication.MasterHost); node.IP != master.Status.PodIP {
+			return fmt.Sprintf("%s replicates from %s, not from master %s", pod.Name, info.Replication.MasterHost, master.Name)
+		}
+	}
+
+	if w.cRedis.Spec.ClusterMode == v1beta1.Sentinel {
+		for _, pod := range w.pods(t, fmt.Sprintf("%s-%s", w.cRedis.Name, util.SentinelResourceSuffix)) {
+			host, _, err := w.redis.GetSentinelMonitor(pod.Status.PodIP, "")
+			if err != nil {
+				return err.Error()
+			}
+			if node, _ := w.redis.Node(host); node.IP != master.Status.PodIP {
+				return fmt.Sprintf("sentinel %s monitors %s, not master %s", pod.Name, host, master.Name)
+			}
+		}
+	}
+
+	return ""
+}
+
+func (w *world) pods(t *testing.T, stsName string) []corev1.Pod {
+	t.Helper()
+
+	pods := &corev1.PodList{}
+	if err := w.k8s.List(context.TODO(), pods, client.InNamespace(w.cRedis.Namespace)); err != nil {
+		t.Fatal(err)
+	}
+
+	var stsPods []corev1.Pod
+	for _, pod := range pods.Items {
+		suffix := strings.TrimPrefix(pod.Name, stsName+"-")
+		if suffix != pod.Name && !strings.Contains(suffix, "-") {
+			stsPods = append(stsPods, pod)
+		}
+	}
+	return stsPods
+}
+
+func (w *world) redisPod(index int) string {
+	return fmt.Sprintf("%s-%d", w.cRedis.Name, index)
+}
+
+func (w *world) podIP(t *testing.T, podName string) string {
+	t.Helper()
+
+	pod := &corev1.Pod{}
+	if err := w.k8s.Get(context.TODO(), types.NamespacedName{Name: podName, Namespace: w.cRedis.Namespace}, pod); err != nil {
+		t.Fatal(err)
+	}
+	return pod.Status.PodIP
+}
+
+func (w *world) master(t *testing.T) string {
+	t.Helper()
+
+	for _, pod := range w.pods(t, w.cRedis.Name) {
+		if node, _ := w.redis.Node(pod.Status.PodIP); node.Role == "master" {
+			return pod.Name
+		}
+	}
+	t.Fatal("no master")
+	return ""
+}
+
+func (w *world) slaves(t *testing.T) []string {
+	t.Helper()
+
+	var slaves []string
+	for _, pod := range w.pods(t, w.cRedis.Name) {
+		if node, _ := w.redis.Node(pod.Status.PodIP); node.Role == "slave" {
+			slaves = append(slaves, pod.Name)
+		}
+	}
+	return slaves
+}
+
+func (w *world) write(t *testing.T, n int64) {
+	t.Helper()
+
+	if err := w.redis.Write(w.podIP(t, w.master(t)), n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (w *world) monitor(t *testing.T, host string) {
+	t.Helper()
+
+	for _, pod := range w.pods(t, fmt.Sprintf("%s-%s", w.cRedis.Name, util.SentinelResourceSuffix)) {
+		monitor := map[string]interface{}{"masterIP": host, "port": int32(6379), "quorum": "2"}
+		if err := w.redis.RemoveSentinelMonitor(pod.Status.PodIP, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.redis.SetSentinelMonitor(pod.Status.PodIP, "", monitor); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// deletePod deletes a redis pod, startPods recreates it with a new IP
+func (w *world) deletePod(t *testing.T, podName string) {
+	t.Helper()
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: w.cRedis.Namespace}}
+	ip := w.podIP(t, podName)
+	if err := w.k8s.Delete(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	w.redis.RemoveNode(ip)
+}
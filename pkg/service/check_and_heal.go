@@ -4,14 +4,24 @@ import (
 	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
 	"github.com/hongqchen/redis-operator/pkg/util"
 	"github.com/pkg/errors"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"net"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 type CheckAndHealer interface {
 	CheckNumberOfMasters(cRedis *v1beta1.CustomRedis) error
+	// 确认已更新到最新模板的 redis Pod 加载了 spec.modules 中的所有模块
+	CheckModules(cRedis *v1beta1.CustomRedis) error
+	// 检查 sentinel 之间的一致性与 quorum，结果写入 status.sentinel
+	CheckSentinels(cRedis *v1beta1.CustomRedis) error
 }
 
 type CheckAndHeal struct {
@@ -20,32 +30,76 @@ type CheckAndHeal struct {
 	redisService RedisServicer
 }
 
-func NewCheckAndHeal(cl client.Client, logger logr.Logger) *CheckAndHeal {
+func NewCheckAndHeal(cl client.Client, rcl redis.Clienter, logger logr.Logger) *CheckAndHeal {
 	return &CheckAndHeal{
 		logger:       logger,
-		k8sService:   NewkubernetesService(cl, logger),
-		redisService: NewRedisService(logger),
+		k8sService:   NewkubernetesService(cl, rcl, logger),
+		redisService: NewRedisService(rcl, logger),
 	}
 }
 
 // CheckNumberOfMasters 检查集群中 master 节点数量
 func (ch *CheckAndHeal) CheckNumberOfMasters(cRedis *v1beta1.CustomRedis) error {
 	ch.logger.V(1).Info("Checking the number of cluster masters")
-	masterIPs, err := ch.k8sService.GetMasterIPs(cRedis)
+	masterPods, err := ch.k8sService.GetMasterPods(cRedis)
 	if err != nil {
 		return err
 	}
 
-	switch len(masterIPs) {
+	switch len(masterPods) {
 	case 0:
 		return ch.healNoMasters(cRedis)
 	case 1:
-		return ch.healOneMaster(cRedis, masterIPs[0])
+		return ch.healOneMaster(cRedis, &masterPods[0])
 	default:
 		return ch.healManyMasters(cRedis)
 	}
 }
 
+// CheckModules 模块仅在 redis 启动时加载，尚未按新模板重建的 Pod 不参与检查
+func (ch *CheckAndHeal) CheckModules(cRedis *v1beta1.CustomRedis) error {
+	if len(cRedis.Spec.Modules) == 0 {
+		return nil
+	}
+	ch.logger.V(1).Info("Checking modules loaded by redis pods")
+
+	sts, err := ch.k8sService.GetStatefulset(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	pods, err := ch.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+
+	expected := make([]string, 0, len(cRedis.Spec.Modules))
+	for _, module := range cRedis.Spec.Modules {
+		expected = append(expected, module.Name)
+	}
+	sort.Strings(expected)
+
+	for _, pod := range pods {
+		revision := sts.Status.UpdateRevision
+		if revision != "" && pod.Labels[appv1.ControllerRevisionHashLabelKey] != revision {
+			continue
+		}
+		modules, err := ch.redisService.ListModules(cRedis, pod.Status.PodIP)
+		if err != nil {
+			return err
+		}
+		loaded := make([]string, 0, len(modules))
+		for _, module := range modules {
+			loaded = append(loaded, module.Name)
+		}
+		if strings.Join(loaded, ",") != strings.Join(expected, ",") {
+			return errors.Wrapf(util.ModulesMismatchErr, "pod %s loaded [%s], expected [%s]",
+				pod.Name, strings.Join(loaded, ","), strings.Join(expected, ","))
+		}
+	}
+
+	return nil
+}
+
 func (ch *CheckAndHeal) healNoMasters(cRedis *v1beta1.CustomRedis) error {
 	ch.logger.V(1).Info("Healing no master in cluster")
 	redisNodes, err := ch.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
@@ -74,7 +128,7 @@ func (ch *CheckAndHeal) healNoMasters(cRedis *v1beta1.CustomRedis) error {
 	return errors.New("unknown error")
 }
 
-func (ch *CheckAndHeal) healOneMaster(cRedis *v1beta1.CustomRedis, currentMaster string) error {
+func (ch *CheckAndHeal) healOneMaster(cRedis *v1beta1.CustomRedis, currentMaster *corev1.Pod) error {
 	ch.logger.V(1).Info("Healing only one master in cluster")
 	name := cRedis.Name
 	namespace := cRedis.Namespace
@@ -85,15 +139,28 @@ func (ch *CheckAndHeal) healOneMaster(cRedis *v1beta1.CustomRedis, currentMaster
 		return err
 	}
 
-	// 按照创建时间排序，slice[0] 为创建时间最长的 Pod
-	sort.Slice(redisNodes, func(i, j int) bool {
-		return redisNodes[i].CreationTimestamp.Before(&redisNodes[j].CreationTimestamp)
-	})
+	masterOffset, err := ch.redisService.GetReplicationOffset(cRedis, currentMaster.Status.PodIP)
+	if err != nil {
+		return err
+	}
+
+	// 复制关系基于稳定的 DNS 名称，Pod 重建不再改变 master 地址
+	// 若某个 slave 已处理的复制偏移量大于 master，说明 master 重启后丢失了数据，不应作为集群 master
+	for _, redisNode := range redisNodes {
+		if redisNode.Name == currentMaster.Name {
+			continue
+		}
 
-	// 列表中最后一个 Pod IP 等于当前 master IP
-	// 说明 master 被删除重建过，不应作为集群 master
-	if redisNodes[len(redisNodes)-1].Status.PodIP == currentMaster {
-		return util.DeprecatedErr
+		slaveOffset, err := ch.redisService.GetReplicationOffset(cRedis, redisNode.Status.PodIP)
+		if err != nil {
+			return err
+		}
+
+		if slaveOffset > masterOffset {
+			ch.logger.Info("Master is behind its slave, it may have lost data after a restart",
+				"master", currentMaster.Name, "masterOffset", masterOffset, "slave", redisNode.Name, "slaveOffset", slaveOffset)
+			return util.DeprecatedErr
+		}
 	}
 
 	return nil
@@ -114,6 +181,11 @@ func (ch *CheckAndHeal) healManyMasters(cRedis *v1beta1.CustomRedis) error {
 		return ch.redisService.SetOldestAsMaster(cRedis, redisNodes)
 	}
 
+	// 扩容时新加入的 Pod 以空 master 启动，设置为现有 master 的 slave
+	if attached, err := ch.attachEmptyMasters(cRedis); attached || err != nil {
+		return err
+	}
+
 	// 非首次创建
 	// master-slave，抛出异常，提醒需要人为选举一个 master，其他设置为 slave（手动操作）
 	if cRedis.Spec.ClusterMode == v1beta1.MasterSlave {
@@ -122,29 +194,38 @@ func (ch *CheckAndHeal) healManyMasters(cRedis *v1beta1.CustomRedis) error {
 
 	// sentinel，找出 sentinel 选出的 master，对比其IP，将其他的 master 设置为 slave（自动操作）
 	if cRedis.Spec.ClusterMode == v1beta1.Sentinel {
-		// 获取 sentinel 节点 IP
-		monitorIP, err := ch.getSentinelMonitor(cRedis)
+		// 获取 sentinel 监听的 master 地址
+		monitorHost, err := ch.getSentinelMonitor(cRedis)
 		if err != nil {
 			return err
 		}
 
-		// 存在的 masterIP 依次和 sentinel monitor 对比，不一致则设置为 slave
-		// 获取 master pod IP
-		masterIPs, err := ch.k8sService.GetMasterIPs(cRedis)
+		// 存在的 master 依次和 sentinel monitor 对比，不一致则设置为 slave
+		masterPods, err := ch.k8sService.GetMasterPods(cRedis)
 		if err != nil {
 			return err
 		}
 
-		for _, masterIP := range masterIPs {
-			if masterIP == monitorIP {
+		// sentinel 监听的可能是对外宣告的地址，复制关系统一使用集群内的地址
+		targetHost := monitorHost
+		for i := range masterPods {
+			if isRedisHost(cRedis, &masterPods[i], monitorHost) {
+				targetHost = getRedisHost(cRedis, &masterPods[i])
+				break
+			}
+		}
+
+		for i := range masterPods {
+			masterPod := &masterPods[i]
+			if isRedisHost(cRedis, masterPod, monitorHost) {
 				// 当前 pod 角色为 master
-				// sentinel 监听的 IP 和当前 IP 一致
+				// sentinel 监听的地址和当前 Pod 一致
 				// 跳过
 				continue
 			}
 
-			// IP 不一致，redis node(Pod)设置为 slave
-			if err := ch.redisService.SetAsSlave(cRedis, masterIP, monitorIP); err != nil {
+			// 地址不一致，redis node(Pod)设置为 slave
+			if err := ch.redisService.SetAsSlave(cRedis, masterPod.Status.PodIP, targetHost); err != nil {
 				return err
 			}
 		}
@@ -154,56 +235,267 @@ func (ch *CheckAndHeal) healManyMasters(cRedis *v1beta1.CustomRedis) error {
 	return util.UnknownErr
 }
 
+// attachEmptyMasters 除一个 master 外，其余 master 均没有 key 且没有 slave 时，将其设置为该 master 的 slave
+// 以 keyspace 判断是否为空：重启后从磁盘加载数据、或从未有过 slave 的 master，复制偏移量同样为 0
+// master-slave 模式下仅处理扩容新增的 Pod，其余情况由人工决定；存在多个有数据的 master（如网络分区后），不做处理，返回 false
+func (ch *CheckAndHeal) attachEmptyMasters(cRedis *v1beta1.CustomRedis) (bool, error) {
+	masterPods, err := ch.k8sService.GetMasterPods(cRedis)
+	if err != nil {
+		return false, err
+	}
+
+	var currentMaster *corev1.Pod
+	var emptyMasters []*corev1.Pod
+	for i := range masterPods {
+		info, err := ch.redisService.GetInfo(cRedis, masterPods[i].Status.PodIP)
+		if err != nil {
+			return false, err
+		}
+
+		if info.Replication.ConnectedSlaves == 0 && info.Keys() == 0 && isAttachable(cRedis, &masterPods[i]) {
+			emptyMasters = append(emptyMasters, &masterPods[i])
+			continue
+		}
+		if currentMaster != nil {
+			return false, nil
+		}
+		currentMaster = &masterPods[i]
+	}
+	if currentMaster == nil || len(emptyMasters) == 0 {
+		return false, nil
+	}
+
+	masterHost := getRedisHost(cRedis, currentMaster)
+	for _, pod := range emptyMasters {
+		ch.logger.Info("Attaching new empty master as slave", "pod", pod.Name, "masterHost", masterHost)
+		if err := ch.redisService.SetAsSlave(cRedis, pod.Status.PodIP, masterHost); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// isAttachable master-slave 模式下，只有序号不小于上次观测到的 ready 数量的 Pod 由扩容新增，可以自动设置为 slave
+func isAttachable(cRedis *v1beta1.CustomRedis, pod *corev1.Pod) bool {
+	if cRedis.Spec.ClusterMode != v1beta1.MasterSlave {
+		return true
+	}
+
+	ordinal, err := strconv.Atoi(pod.Name[strings.LastIndex(pod.Name, "-")+1:])
+	return err == nil && cRedis.Status.ReadyReplicas > 0 && int32(ordinal) >= cRedis.Status.ReadyReplicas
+}
+
+// getSentinelMonitor 返回多数 sentinel 监听的 master 地址，跳过仍在监听占位地址或未监听任何 master 的 sentinel
+// 刚完成故障转移时 sentinel 之间可能短暂不一致，没有多数时等待 sentinel 收敛
 func (ch *CheckAndHeal) getSentinelMonitor(cRedis *v1beta1.CustomRedis) (string, error) {
 	ch.logger.V(1).Info("Getting sentinel monitor info")
-	name := cRedis.Name
-	namespace := cRedis.Namespace
-	sentinelName := fmt.Sprintf("%s-%s", name, util.SentinelResourceSuffix)
+	sentinelName := fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix)
 
 	// 获取 sentinel pod list
-	sentinelPods, err := ch.k8sService.GetDeploymentReadyPods(sentinelName, namespace)
+	sentinelPods, err := ch.k8sService.GetStatefulsetReadyPods(sentinelName, cRedis.Namespace)
 	if err != nil {
 		return "", err
 	}
 
-	// 废弃
-	// 获取 sentinel 集群监控的 master IP
-	//monitorIPs := make(map[string]struct{})
-	//for _, pod := range sentinelPods {
-	//	storedMonitor, _, err := ch.redisService.GetSentinelMonitor(cRedis, pod.Status.PodIP)
-	//	if err != nil {
-	//		return "", err
-	//	}
-	//
-	//	_, exists := monitorIPs[storedMonitor]
-	//	if exists {
-	//		continue
-	//	}
-	//	monitorIPs[storedMonitor] = struct{}{}
-	//}
-	//
-	//if len(monitorIPs) == 1 {
-	//	for key := range monitorIPs {
-	//		return key, nil
-	//	}
-	//}
-	//
-	//return "", util.ManyMonitorsOnSentinelErr
-
-	monitorIP := ""
+	votes := make(map[string]int)
+	answers := 0
 	for _, pod := range sentinelPods {
 		storedMonitor, _, err := ch.redisService.GetSentinelMonitor(cRedis, pod.Status.PodIP)
 		if err != nil {
+			if errors.Is(err, redis.ErrNoMonitor) {
+				continue
+			}
 			return "", err
 		}
 
-		if storedMonitor == "127.0.0.1" {
+		// 跳过仍在监听占位地址的 sentinel
+		if util.IsLoopbackHost(storedMonitor) {
+			continue
+		}
+		votes[storedMonitor]++
+		answers++
+	}
+
+	for monitorHost, count := range votes {
+		if count*2 > answers {
+			return monitorHost, nil
+		}
+	}
+	if answers == 0 {
+		return "", errors.Wrap(util.MasterBeElectingErr, "no sentinel monitors a master")
+	}
+	return "", errors.Wrapf(util.MasterBeElectingErr, "sentinels monitor different masters: %v", votes)
+}
+
+// CheckSentinels 依次检查每个 sentinel 监听的 master、已知的其他 sentinel 与 replica，并执行 CKQUORUM
+// 与 statefulset 的任何 Pod 都不对应的 sentinel 或 replica 会影响故障转移的多数派判断与 replica 的选择，通过 SENTINEL RESET 清除；
+// 重置后的 sentinel 需要数秒重新发现其他 sentinel，期间不再重置其他 sentinel，每次 reconcile 最多重置一个；
+// 缺失的条目由 sentinel 自动发现，只记录在 status 中
+func (ch *CheckAndHeal) CheckSentinels(cRedis *v1beta1.CustomRedis) error {
+	if cRedis.Spec.ClusterMode != v1beta1.Sentinel || cRedis.IsStandby() {
+		cRedis.Status.Sentinel = nil
+		return nil
+	}
+	ch.logger.V(1).Info("Checking sentinel consistency")
+
+	masterPods, err := ch.k8sService.GetMasterPods(cRedis)
+	if err != nil {
+		return err
+	}
+	if len(masterPods) == 0 {
+		return errors.Wrap(util.MasterBeElectingErr, "no redis pod reports the master role")
+	}
+	if len(masterPods) != 1 {
+		return util.ManyMastersErr
+	}
+	masterPod := &masterPods[0]
+	masterHost, masterPort := getRedisAnnounceAddr(cRedis, masterPod)
+
+	sentinelName := fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix)
+	redisPods, err := ch.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	allRedisPods, err := ch.k8sService.GetStatefulsetPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	sentinelPods, err := ch.k8sService.GetStatefulsetReadyPods(sentinelName, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	allSentinelPods, err := ch.k8sService.GetStatefulsetPods(sentinelName, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+
+	status := &v1beta1.SentinelStatus{Monitor: net.JoinHostPort(masterHost, strconv.Itoa(int(masterPort)))}
+	// 没有 sentinel 监听 master 时，无法执行 CKQUORUM，视为无法达成 quorum
+	quorumChecked := false
+	quorumReachable := true
+	// 需要重置的 sentinel，以及是否有 sentinel 尚未发现所有其他 sentinel
+	var staleSentinels []*corev1.Pod
+	rediscovering := false
+	for i := range sentinelPods {
+		sentinelPod := &sentinelPods[i]
+		sentinelIP := sentinelPod.Status.PodIP
+
+		monitorHost, monitorPort, err := ch.redisService.GetSentinelMonitor(cRedis, sentinelIP)
+		if err != nil && !errors.Is(err, redis.ErrNoMonitor) {
+			return err
+		}
+		if monitorHost != masterHost || monitorPort != strconv.Itoa(int(masterPort)) {
+			status.Inconsistencies = append(status.Inconsistencies, fmt.Sprintf("%s monitors %q, not the master %s", sentinelPod.Name, net.JoinHostPort(monitorHost, monitorPort), status.Monitor))
 			continue
 		}
 
-		monitorIP = storedMonitor
-		break
+		ok, reply, err := ch.redisService.CheckSentinelQuorum(cRedis, sentinelIP)
+		if err != nil {
+			return err
+		}
+		quorumChecked = true
+		if !ok {
+			quorumReachable = false
+			status.Inconsistencies = append(status.Inconsistencies, fmt.Sprintf("%s: %s", sentinelPod.Name, reply))
+		}
+
+		peerIssues, stalePeers, missingPeers, err := ch.checkSentinelPeers(cRedis, sentinelPod, sentinelPods, allSentinelPods)
+		if err != nil {
+			return err
+		}
+		replicaIssues, staleReplicas, err := ch.checkSentinelReplicas(cRedis, sentinelPod, masterPod, redisPods, allRedisPods)
+		if err != nil {
+			return err
+		}
+		status.Inconsistencies = append(status.Inconsistencies, peerIssues...)
+		status.Inconsistencies = append(status.Inconsistencies, replicaIssues...)
+
+		rediscovering = rediscovering || missingPeers
+		if stalePeers || staleReplicas {
+			staleSentinels = append(staleSentinels, sentinelPod)
+		}
+	}
+
+	if len(staleSentinels) > 0 {
+		if rediscovering {
+			ch.logger.Info("Sentinels are still discovering each other, deferring the reset of stale sentinels", "sentinel", staleSentinels[0].Name)
+		} else {
+			ch.logger.Info("Sentinel knows stale sentinels or replicas, resetting", "sentinel", staleSentinels[0].Name)
+			if err := ch.redisService.ResetSentinel(cRedis, staleSentinels[0].Status.PodIP); err != nil {
+				return err
+			}
+		}
+	}
+
+	status.QuorumReachable = quorumChecked && quorumReachable
+	if len(status.Inconsistencies) > 0 {
+		ch.logger.Info("Sentinels are inconsistent", "inconsistencies", status.Inconsistencies)
+	}
+	cRedis.Status.Sentinel = status
+	return nil
+}
+
+// checkSentinelPeers 对比 SENTINEL SENTINELS 与实际的 sentinel Pod，返回发现的问题、是否存在已不存在的 sentinel，
+// 以及是否有 ready 的 sentinel 尚未被发现；重启中的 sentinel 仍对应 statefulset 的 Pod，不视为已不存在
+func (ch *CheckAndHeal) checkSentinelPeers(cRedis *v1beta1.CustomRedis, sentinelPod *corev1.Pod, readyPods, allPods []corev1.Pod) ([]string, bool, bool, error) {
+	peers, err := ch.redisService.GetSentinelPeers(cRedis, sentinelPod.Status.PodIP)
+	if err != nil {
+		return nil, false, false, err
 	}
 
-	return monitorIP, nil
+	var issues []string
+	stale, missing := false, false
+	known := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		found := false
+		for i := range allPods {
+			if isRedisHost(cRedis, &allPods[i], peer["ip"]) {
+				known[allPods[i].Name] = true
+				found = true
+			}
+		}
+		if !found {
+			stale = true
+			issues = append(issues, fmt.Sprintf("%s knows stale sentinel %s", sentinelPod.Name, peer["ip"]))
+		}
+	}
+	for i := range readyPods {
+		if readyPods[i].Name != sentinelPod.Name && !known[readyPods[i].Name] {
+			missing = true
+			issues = append(issues, fmt.Sprintf("%s does not know sentinel %s", sentinelPod.Name, readyPods[i].Name))
+		}
+	}
+	return issues, stale, missing, nil
+}
+
+// checkSentinelReplicas 对比 SENTINEL REPLICAS 与 master 以外的 redis Pod，返回发现的问题以及是否存在已不存在的 replica
+func (ch *CheckAndHeal) checkSentinelReplicas(cRedis *v1beta1.CustomRedis, sentinelPod, masterPod *corev1.Pod, readyPods, allPods []corev1.Pod) ([]string, bool, error) {
+	replicas, err := ch.redisService.GetSentinelReplicas(cRedis, sentinelPod.Status.PodIP)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var issues []string
+	stale := false
+	known := make(map[string]bool, len(replicas))
+	for _, replica := range replicas {
+		found := false
+		for i := range allPods {
+			if allPods[i].Name != masterPod.Name && isRedisHost(cRedis, &allPods[i], replica["ip"]) {
+				known[allPods[i].Name] = true
+				found = true
+			}
+		}
+		if !found {
+			stale = true
+			issues = append(issues, fmt.Sprintf("%s knows stale replica %s", sentinelPod.Name, replica["ip"]))
+		}
+	}
+	for i := range readyPods {
+		if readyPods[i].Name != masterPod.Name && !known[readyPods[i].Name] {
+			issues = append(issues, fmt.Sprintf("%s does not know replica %s", sentinelPod.Name, readyPods[i].Name))
+		}
+	}
+	return issues, stale, nil
 }
@@ -0,0 +1,416 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"testing"
+)
+
+func TestCheckNumberOfMasters(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  v1beta1.ClusterMode
+		phase util.CustomRedisPhase
+		setup func(t *testing.T, tc *testCluster)
+		// master expected after healing, -1 when the topology is left untouched
+		wantMaster int
+		wantErr    error
+	}{
+		{
+			name:       "creating, fresh nodes are all masters",
+			mode:       v1beta1.Sentinel,
+			phase:      util.CustomRedisCreating,
+			setup:      func(t *testing.T, tc *testCluster) {},
+			wantMaster: 0,
+		},
+		{
+			name:  "healthy cluster",
+			mode:  v1beta1.Sentinel,
+			phase: util.CustomRedisRunning,
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 1)
+				_ = tc.redis.Write(tc.ip(1), 100)
+			},
+			wantMaster: 1,
+		},
+		{
+			name:  "split brain, sentinel decides",
+			mode:  v1beta1.Sentinel,
+			phase: util.CustomRedisRunning,
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 2)
+				tc.monitor(t, tc.fqdn(2))
+				_ = tc.redis.Write(tc.ip(2), 100)
+				// pod 0 was partitioned and promoted itself
+				_ = tc.redis.SetAsMaster(tc.ip(0), 6379, "")
+			},
+			wantMaster: 2,
+		},
+		{
+			name:  "split brain, sentinel monitors an IP after an old failover",
+			mode:  v1beta1.Sentinel,
+			phase: util.CustomRedisRunning,
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 1)
+				tc.monitor(t, tc.ip(1))
+				_ = tc.redis.Write(tc.ip(1), 100)
+				_ = tc.redis.SetAsMaster(tc.ip(2), 6379, "")
+			},
+			wantMaster: 1,
+		},
+		{
+			name:  "split brain, sentinels disagree and the majority decides",
+			mode:  v1beta1.Sentinel,
+			phase: util.CustomRedisRunning,
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 2)
+				tc.monitor(t, tc.fqdn(2))
+				_ = tc.redis.Write(tc.ip(2), 100)
+				_ = tc.redis.SetAsMaster(tc.ip(0), 6379, "")
+				// the first sentinel has not learnt about the failover yet
+				monitor := map[string]interface{}{"masterIP": tc.fqdn(0), "port": int32(6379), "quorum": "2"}
+				_ = tc.redis.RemoveSentinelMonitor(tc.sentinelIPs()[0], "")
+				_ = tc.redis.SetSentinelMonitor(tc.sentinelIPs()[0], "", monitor)
+			},
+			wantMaster: 2,
+		},
+		{
+			name:  "split brain in master-slave mode needs a human",
+			mode:  v1beta1.MasterSlave,
+			phase: util.CustomRedisRunning,
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				_ = tc.redis.Write(tc.ip(0), 100)
+				_ = tc.redis.SetAsMaster(tc.ip(1), 6379, "")
+			},
+			wantMaster: -1,
+			wantErr:    util.ManyMastersErr,
+		},
+		{
+			name:  "scaling up, the new empty pod joins the master",
+			mode:  v1beta1.MasterSlave,
+			phase: util.CustomRedisScaling,
+			setup: func(t *testing.T, tc *testCluster) {
+				_ = tc.redis.SetAsMaster(tc.ip(1), 6379, "")
+				_ = tc.redis.SetAsSlave(tc.ip(0), tc.fqdn(1), 6379, "")
+				// pod 2 is the new pod
+				tc.cRedis.Status.ReadyReplicas = 2
+			},
+			wantMaster: 1,
+		},
+		{
+			name:  "empty master of a running master-slave cluster needs a human",
+			mode:  v1beta1.MasterSlave,
+			phase: util.CustomRedisScaling,
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				_ = tc.redis.Write(tc.ip(0), 100)
+				// pod 1 restarted without its data and promoted itself
+				_ = tc.redis.SetAsMaster(tc.ip(1), 6379, "")
+				tc.cRedis.Status.ReadyReplicas = 3
+			},
+			wantMaster: -1,
+			wantErr:    util.ManyMastersErr,
+		},
+		{
+			name:  "master restarted from disk is not replaced by a full sync",
+			mode:  v1beta1.MasterSlave,
+			phase: util.CustomRedisScaling,
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 1)
+				_ = tc.redis.Write(tc.ip(1), 100)
+				_ = tc.redis.SetKey(tc.ip(1), "key", "value")
+				// pod 2 loaded its keys from disk, its replication offset starts at 0
+				tc.redis.RestartFromDisk(tc.ip(2))
+				tc.cRedis.Status.ReadyReplicas = 2
+			},
+			wantMaster: -1,
+			wantErr:    util.ManyMastersErr,
+		},
+		{
+			name:  "no master while sentinel elects one",
+			mode:  v1beta1.Sentinel,
+			phase: util.CustomRedisRunning,
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				_ = tc.redis.SetAsSlave(tc.ip(0), tc.fqdn(1), 6379, "")
+			},
+			wantMaster: -1,
+			wantErr:    util.MasterBeElectingErr,
+		},
+		{
+			name:  "master restarted without data",
+			mode:  v1beta1.Sentinel,
+			phase: util.CustomRedisRunning,
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				_ = tc.redis.Write(tc.ip(0), 100)
+				// the slaves still hold the data until they resync
+				tc.redis.Restart(tc.ip(0))
+			},
+			wantMaster: -1,
+			wantErr:    util.DeprecatedErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, tt.mode, tt.phase)
+			tt.setup(t, tc)
+
+			err := tc.checkAndHeal().CheckNumberOfMasters(tc.cRedis)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckNumberOfMasters() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMaster >= 0 {
+				tc.assertTopology(t, tt.wantMaster)
+			}
+		})
+	}
+}
+
+func TestCheckSentinels(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, tc *testCluster)
+		// sentinels expected to be reset, by index
+		wantResets []int
+		wantQuorum bool
+		// inconsistencies expected in status, none when nil
+		wantInconsistencies func(tc *testCluster) []string
+	}{
+		{
+			name:       "consistent sentinels",
+			setup:      func(t *testing.T, tc *testCluster) {},
+			wantQuorum: true,
+		},
+		{
+			name: "stale replica and sentinel are reset",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.redis.AddStaleReplica(tc.sentinelIPs()[1], "10.0.0.9")
+				tc.redis.AddStalePeer(tc.sentinelIPs()[2], "10.0.1.9")
+			},
+			// one sentinel per reconcile
+			wantResets: []int{1},
+			wantQuorum: true,
+			wantInconsistencies: func(tc *testCluster) []string {
+				return []string{
+					"redis-sentinel-1 knows stale replica 10.0.0.9",
+					"redis-sentinel-2 knows stale sentinel 10.0.1.9",
+				}
+			},
+		},
+		{
+			name: "sentinel monitoring another master",
+			setup: func(t *testing.T, tc *testCluster) {
+				monitor := map[string]interface{}{"masterIP": tc.fqdn(1), "port": int32(6379), "quorum": "2"}
+				_ = tc.redis.RemoveSentinelMonitor(tc.sentinelIPs()[0], "")
+				_ = tc.redis.SetSentinelMonitor(tc.sentinelIPs()[0], "", monitor)
+			},
+			wantQuorum: true,
+			wantInconsistencies: func(tc *testCluster) []string {
+				return []string{
+					fmt.Sprintf("redis-sentinel-0 monitors %q, not the master %s:6379", tc.fqdn(1)+":6379", tc.fqdn(0)),
+					"redis-sentinel-1 does not know sentinel redis-sentinel-0",
+					"redis-sentinel-2 does not know sentinel redis-sentinel-0",
+				}
+			},
+		},
+		{
+			name: "restarting replica is not stale",
+			setup: func(t *testing.T, tc *testCluster) {
+				pod := &corev1.Pod{}
+				if err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: "redis-2", Namespace: tc.cRedis.Namespace}, pod); err != nil {
+					t.Fatal(err)
+				}
+				pod.Status.Conditions[1].Status = corev1.ConditionFalse
+				if err := tc.k8sClient.Update(context.TODO(), pod); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantQuorum: true,
+		},
+		{
+			name: "no reset while a sentinel is not known by its peers",
+			setup: func(t *testing.T, tc *testCluster) {
+				monitor := map[string]interface{}{"masterIP": tc.fqdn(1), "port": int32(6379), "quorum": "2"}
+				_ = tc.redis.RemoveSentinelMonitor(tc.sentinelIPs()[0], "")
+				_ = tc.redis.SetSentinelMonitor(tc.sentinelIPs()[0], "", monitor)
+				tc.redis.AddStalePeer(tc.sentinelIPs()[1], "10.0.1.9")
+			},
+			wantQuorum: true,
+			wantInconsistencies: func(tc *testCluster) []string {
+				return []string{
+					fmt.Sprintf("redis-sentinel-0 monitors %q, not the master %s:6379", tc.fqdn(1)+":6379", tc.fqdn(0)),
+					"redis-sentinel-1 knows stale sentinel 10.0.1.9",
+					"redis-sentinel-1 does not know sentinel redis-sentinel-0",
+					"redis-sentinel-2 does not know sentinel redis-sentinel-0",
+				}
+			},
+		},
+		{
+			name: "quorum lost",
+			setup: func(t *testing.T, tc *testCluster) {
+				// three sentinels were removed without a reset, the remaining three are no majority
+				for _, ip := range tc.sentinelIPs() {
+					for _, stale := range []string{"10.0.1.7", "10.0.1.8", "10.0.1.9"} {
+						tc.redis.AddStalePeer(ip, stale)
+					}
+				}
+			},
+			wantResets: []int{0},
+			wantQuorum: false,
+			wantInconsistencies: func(tc *testCluster) []string {
+				var inconsistencies []string
+				for i := range tc.sentinelIPs() {
+					inconsistencies = append(inconsistencies, fmt.Sprintf("redis-sentinel-%d: NOQUORUM 3 usable Sentinels. "+
+						"Not enough available Sentinels to reach the majority and authorize a failover", i))
+					for _, stale := range []string{"10.0.1.7", "10.0.1.8", "10.0.1.9"} {
+						inconsistencies = append(inconsistencies, fmt.Sprintf("redis-sentinel-%d knows stale sentinel %s", i, stale))
+					}
+				}
+				return inconsistencies
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+			tc.replicate(t, 0)
+			tc.monitor(t, tc.fqdn(0))
+			tt.setup(t, tc)
+
+			if err := tc.checkAndHeal().CheckSentinels(tc.cRedis); err != nil {
+				t.Fatalf("CheckSentinels() error = %v", err)
+			}
+
+			status := tc.cRedis.Status.Sentinel
+			if status == nil || status.Monitor != tc.fqdn(0)+":6379" || status.QuorumReachable != tt.wantQuorum {
+				t.Fatalf("status.sentinel = %+v, want monitor %s:6379 and quorum reachable %v", status, tc.fqdn(0), tt.wantQuorum)
+			}
+			var wantInconsistencies []string
+			if tt.wantInconsistencies != nil {
+				wantInconsistencies = tt.wantInconsistencies(tc)
+			}
+			if strings.Join(status.Inconsistencies, "\n") != strings.Join(wantInconsistencies, "\n") {
+				t.Errorf("inconsistencies = %q, want %q", status.Inconsistencies, wantInconsistencies)
+			}
+			for i, ip := range tc.sentinelIPs() {
+				sentinel, _ := tc.redis.Sentinel(ip)
+				wantResets := 0
+				for _, reset := range tt.wantResets {
+					if reset == i {
+						wantResets = 1
+					}
+				}
+				if sentinel.Resets != wantResets {
+					t.Errorf("sentinel %d reset %d times, want %d", i, sentinel.Resets, wantResets)
+				}
+			}
+		})
+	}
+}
+
+// a failover in progress leaves no master for a moment, it must not be reported as a split brain
+func TestCheckSentinelsNoMaster(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+	tc.replicate(t, 0)
+	tc.monitor(t, tc.fqdn(0))
+	_ = tc.redis.SetAsSlave(tc.ip(0), tc.fqdn(1), 6379, "")
+
+	err := tc.checkAndHeal().CheckSentinels(tc.cRedis)
+	if !errors.Is(err, util.MasterBeElectingErr) {
+		t.Fatalf("CheckSentinels() error = %v, want %v", err, util.MasterBeElectingErr)
+	}
+	if category := util.ClassifyError(err); category != util.ErrorWaiting {
+		t.Errorf("error is classified %s, want %s", category, util.ErrorWaiting)
+	}
+}
+
+func TestCheckModules(t *testing.T) {
+	search := redis.Module{Name: "search", Version: 20610}
+	rejson := redis.Module{Name: "ReJSON", Version: 20606}
+
+	tests := []struct {
+		name    string
+		modules []v1beta1.ModuleConfig
+		loaded  map[int][]redis.Module
+		// pods already running the latest revision, all pods when empty
+		updated []int
+		wantErr error
+	}{
+		{
+			name:   "no modules configured",
+			loaded: map[int][]redis.Module{0: {search}},
+		},
+		{
+			name:    "all pods loaded the modules",
+			modules: []v1beta1.ModuleConfig{{Name: "search", Path: "/opt/redisearch.so"}, {Name: "ReJSON", Path: "/opt/rejson.so"}},
+			loaded:  map[int][]redis.Module{0: {rejson, search}, 1: {rejson, search}, 2: {rejson, search}},
+		},
+		{
+			name:    "a pod is missing a module",
+			modules: []v1beta1.ModuleConfig{{Name: "search", Path: "/opt/redisearch.so"}, {Name: "ReJSON", Path: "/opt/rejson.so"}},
+			loaded:  map[int][]redis.Module{0: {rejson, search}, 1: {search}, 2: {rejson, search}},
+			wantErr: util.ModulesMismatchErr,
+		},
+		{
+			name:    "module name differs from spec",
+			modules: []v1beta1.ModuleConfig{{Name: "json", Path: "/opt/rejson.so"}},
+			loaded:  map[int][]redis.Module{0: {rejson}, 1: {rejson}, 2: {rejson}},
+			wantErr: util.ModulesMismatchErr,
+		},
+		{
+			name:    "outdated pods are not checked",
+			modules: []v1beta1.ModuleConfig{{Name: "search", Path: "/opt/redisearch.so"}},
+			loaded:  map[int][]redis.Module{2: {search}},
+			updated: []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+			tc.cRedis.Spec.Modules = tt.modules
+			for i, modules := range tt.loaded {
+				tc.redis.SetModules(tc.ip(i), modules...)
+			}
+
+			if len(tt.updated) > 0 {
+				ctx := context.TODO()
+				sts := &appv1.StatefulSet{}
+				if err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: tc.cRedis.Name, Namespace: tc.cRedis.Namespace}, sts); err != nil {
+					t.Fatal(err)
+				}
+				sts.Status.UpdateRevision = "redis-2"
+				if err := tc.k8sClient.Status().Update(ctx, sts); err != nil {
+					t.Fatal(err)
+				}
+				for _, i := range tt.updated {
+					pod := &corev1.Pod{}
+					if err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("redis-%d", i), Namespace: tc.cRedis.Namespace}, pod); err != nil {
+						t.Fatal(err)
+					}
+					pod.Labels[appv1.ControllerRevisionHashLabelKey] = "redis-2"
+					if err := tc.k8sClient.Update(ctx, pod); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			err := tc.checkAndHeal().CheckModules(tc.cRedis)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckModules() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
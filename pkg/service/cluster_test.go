@@ -0,0 +1,185 @@
+package service
+
+import (
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis/fake"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	k8sfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+	"time"
+)
+
+// testCluster is a CustomRedis whose statefulsets and ready pods exist in a fake API server,
+// every pod being backed by a node of the fake redis topology
+type testCluster struct {
+	cRedis    *v1beta1.CustomRedis
+	k8sClient client.Client
+	redis     *fake.Client
+}
+
+func newTestCluster(t *testing.T, mode v1beta1.ClusterMode, phase util.CustomRedisPhase) *testCluster {
+	t.Helper()
+
+	replicas := int32(3)
+	sentinelNum := int32(3)
+	cRedis := &v1beta1.CustomRedis{
+		ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "default", UID: "uid"},
+		Spec: v1beta1.CustomRedisSpec{
+			Replicas:    &replicas,
+			ClusterMode: mode,
+			RedisConfig: map[string]string{"port": "6379"},
+			SentinelNum: &sentinelNum,
+			Templates:   v1beta1.PodConfig{Image: "redis:7.0", InitImage: "busybox:1.28"},
+		},
+		Status: v1beta1.CustomRedisStatus{Phase: phase},
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	g := newGenerate()
+	objs := []client.Object{cRedis}
+	rcl := fake.NewClient()
+
+	sts := g.statefulset(cRedis)
+	objs = append(objs, sts)
+	for i := 0; i < int(replicas); i++ {
+		pod := newReadyPod(sts.Name, i, sts.Spec.Selector.MatchLabels, fmt.Sprintf("10.0.0.%d", i+1))
+		pod.Spec.Subdomain = sts.Spec.ServiceName
+		objs = append(objs, pod)
+
+		// replica-announce-ip 由 init container 写入配置文件
+		fqdn := util.GetPodFQDN(pod.Name, sts.Spec.ServiceName, pod.Namespace)
+		rcl.AddNode(pod.Status.PodIP, fqdn)
+		if err := rcl.SetConfig(pod.Status.PodIP, 6379, "", "replica-announce-ip", fqdn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if mode == v1beta1.Sentinel {
+		sentinelSts := g.statefulsetForSentinel(cRedis)
+		objs = append(objs, sentinelSts)
+		for i := 0; i < int(sentinelNum); i++ {
+			pod := newReadyPod(sentinelSts.Name, i, sentinelSts.Spec.Selector.MatchLabels, fmt.Sprintf("10.0.1.%d", i+1))
+			objs = append(objs, pod)
+			rcl.AddSentinel(pod.Status.PodIP)
+		}
+	}
+
+	return &testCluster{
+		cRedis:    cRedis,
+		k8sClient: k8sfake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		redis:     rcl,
+	}
+}
+
+// pods are created one second apart, pod 0 is the oldest
+func newReadyPod(stsName string, index int, labels map[string]string, ip string) *corev1.Pod {
+	podLabels := make(map[string]string, len(labels))
+	for k, v := range labels {
+		podLabels[k] = v
+	}
+
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              fmt.Sprintf("%s-%d", stsName, index),
+			Namespace:         "default",
+			Labels:            podLabels,
+			CreationTimestamp: metav1.NewTime(time.Unix(1600000000+int64(index), 0)),
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: ip,
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodInitialized, Status: corev1.ConditionTrue},
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func (tc *testCluster) fqdn(index int) string {
+	name := fmt.Sprintf("%s-%d", tc.cRedis.Name, index)
+	return util.GetPodFQDN(name, fmt.Sprintf("%s-%s", tc.cRedis.Name, util.HeadlessServiceSuffix), tc.cRedis.Namespace)
+}
+
+func (tc *testCluster) ip(index int) string {
+	return fmt.Sprintf("10.0.0.%d", index+1)
+}
+
+func (tc *testCluster) sentinelIPs() []string {
+	var ips []string
+	for i := 0; i < int(*tc.cRedis.Spec.SentinelNum); i++ {
+		ips = append(ips, fmt.Sprintf("10.0.1.%d", i+1))
+	}
+	return ips
+}
+
+// replicate makes index the master and every other node its slave
+func (tc *testCluster) replicate(t *testing.T, master int) {
+	t.Helper()
+
+	if err := tc.redis.SetAsMaster(tc.ip(master), 6379, ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < int(*tc.cRedis.Spec.Replicas); i++ {
+		if i == master {
+			continue
+		}
+		if err := tc.redis.SetAsSlave(tc.ip(i), tc.fqdn(master), 6379, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (tc *testCluster) monitor(t *testing.T, host string) {
+	t.Helper()
+
+	for _, ip := range tc.sentinelIPs() {
+		monitor := map[string]interface{}{"masterIP": host, "port": int32(6379), "quorum": "2"}
+		if err := tc.redis.RemoveSentinelMonitor(ip, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.redis.SetSentinelMonitor(ip, "", monitor); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (tc *testCluster) ensure() *Ensure {
+	return NewEnsure(tc.k8sClient, tc.redis, logr.Discard())
+}
+
+func (tc *testCluster) checkAndHeal() *CheckAndHeal {
+	return NewCheckAndHeal(tc.k8sClient, tc.redis, logr.Discard())
+}
+
+// assertTopology checks there is exactly one master and every other node replicates from it
+func (tc *testCluster) assertTopology(t *testing.T, master int) {
+	t.Helper()
+
+	if masters := tc.redis.Masters(); len(masters) != 1 || masters[0] != tc.ip(master) {
+		t.Fatalf("masters = %v, want [%s]", masters, tc.ip(master))
+	}
+	for i := 0; i < int(*tc.cRedis.Spec.Replicas); i++ {
+		if i == master {
+			continue
+		}
+		node, _ := tc.redis.Node(tc.ip(i))
+		if node.MasterHost != tc.fqdn(master) {
+			t.Errorf("node %d replicates from %q, want %q", i, node.MasterHost, tc.fqdn(master))
+		}
+	}
+}
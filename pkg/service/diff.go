@@ -0,0 +1,187 @@
+package service
+
+import (
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+)
+
+// 比较 generate 生成的资源与集群中已存在的资源，返回不一致的字段，无差异时跳过更新
+// 生成的对象中未设置的字段（由 API server 填充默认值或由其他组件设置）不参与比较
+
+func configmapDiff(desired, stored *corev1.ConfigMap) []string {
+	fields := metadataDiff(&desired.ObjectMeta, &stored.ObjectMeta)
+	if !reflect.DeepEqual(desired.Data, stored.Data) {
+		fields = append(fields, "data")
+	}
+	return fields
+}
+
+func serviceDiff(desired, stored *corev1.Service) []string {
+	fields := metadataDiff(&desired.ObjectMeta, &stored.ObjectMeta)
+	if !equality.Semantic.DeepDerivative(desired.Annotations, stored.Annotations) {
+		fields = append(fields, "metadata.annotations")
+	}
+	if !reflect.DeepEqual(desired.Spec.Selector, stored.Spec.Selector) {
+		fields = append(fields, "spec.selector")
+	}
+	if portsDiffer(desired.Spec.Ports, stored.Spec.Ports) {
+		fields = append(fields, "spec.ports")
+	}
+	if serviceType(desired) != serviceType(stored) {
+		fields = append(fields, "spec.type")
+	}
+	if desired.Spec.PublishNotReadyAddresses != stored.Spec.PublishNotReadyAddresses {
+		fields = append(fields, "spec.publishNotReadyAddresses")
+	}
+	if !equality.Semantic.DeepDerivative(desired.Spec.IPFamilyPolicy, stored.Spec.IPFamilyPolicy) ||
+		!equality.Semantic.DeepDerivative(desired.Spec.IPFamilies, stored.Spec.IPFamilies) {
+		fields = append(fields, "spec.ipFamilies")
+	}
+	return fields
+}
+
+// statefulsetDiff serviceName、selector、volumeClaimTemplates 不可修改，不参与比较
+// desired 需已由 API server 填充默认值，pod 模板完全一致才视为无差异，删除的注解、label 等字段同样需要更新
+func statefulsetDiff(desired, stored *appv1.StatefulSet) []string {
+	fields := metadataDiff(&desired.ObjectMeta, &stored.ObjectMeta)
+	if !equality.Semantic.DeepEqual(desired.Spec.Replicas, stored.Spec.Replicas) {
+		fields = append(fields, "spec.replicas")
+	}
+	if !equality.Semantic.DeepEqual(desired.Spec.Template, stored.Spec.Template) {
+		fields = append(fields, "spec.template")
+	}
+	if updateStrategyDiffers(desired.Spec.UpdateStrategy, stored.Spec.UpdateStrategy) {
+		fields = append(fields, "spec.updateStrategy")
+	}
+	return fields
+}
+
+func metadataDiff(desired, stored *metav1.ObjectMeta) []string {
+	var fields []string
+	if !equality.Semantic.DeepDerivative(desired.Labels, stored.Labels) {
+		fields = append(fields, "metadata.labels")
+	}
+	if !equality.Semantic.DeepDerivative(desired.OwnerReferences, stored.OwnerReferences) {
+		fields = append(fields, "metadata.ownerReferences")
+	}
+	return fields
+}
+
+// portsDiffer targetPort、nodePort 未指定时由 API server 分配，不参与比较
+func portsDiffer(desired, stored []corev1.ServicePort) bool {
+	if len(desired) != len(stored) {
+		return true
+	}
+	for i := range desired {
+		d, s := desired[i], stored[i]
+		if d.Name != s.Name || d.Port != s.Port || d.Protocol != s.Protocol {
+			return true
+		}
+		if d.TargetPort.String() != "0" && d.TargetPort != s.TargetPort {
+			return true
+		}
+		if d.NodePort != 0 && d.NodePort != s.NodePort {
+			return true
+		}
+	}
+	return false
+}
+
+// updateStrategyDiffers 未指定的策略由 API server 默认为 RollingUpdate、partition 0，
+// 维护窗口开启后需要据此移除 holdRollout 设置的 partition
+func updateStrategyDiffers(desired, stored appv1.StatefulSetUpdateStrategy) bool {
+	return updateStrategyType(desired) != updateStrategyType(stored) || partition(desired) != partition(stored)
+}
+
+func updateStrategyType(strategy appv1.StatefulSetUpdateStrategy) appv1.StatefulSetUpdateStrategyType {
+	if strategy.Type == "" {
+		return appv1.RollingUpdateStatefulSetStrategyType
+	}
+	return strategy.Type
+}
+
+func partition(strategy appv1.StatefulSetUpdateStrategy) int32 {
+	if strategy.RollingUpdate == nil || strategy.RollingUpdate.Partition == nil {
+		return 0
+	}
+	return *strategy.RollingUpdate.Partition
+}
+
+func serviceType(svc *corev1.Service) corev1.ServiceType {
+	if svc.Spec.Type == "" {
+		return corev1.ServiceTypeClusterIP
+	}
+	return svc.Spec.Type
+}
+
+// 以集群中已存在的资源为基础，只覆盖 operator 管理的字段，
+// 保留 resourceVersion 及其他组件设置的字段（如 clusterIP、分配的 nodePort、注解）
+
+func mergeConfigmap(desired, stored *corev1.ConfigMap) *corev1.ConfigMap {
+	merged := stored.DeepCopy()
+	mergeMetadata(&desired.ObjectMeta, &merged.ObjectMeta)
+	merged.Data = desired.Data
+	return merged
+}
+
+func mergeService(desired, stored *corev1.Service) *corev1.Service {
+	merged := stored.DeepCopy()
+	mergeMetadata(&desired.ObjectMeta, &merged.ObjectMeta)
+	for k, v := range desired.Annotations {
+		if merged.Annotations == nil {
+			merged.Annotations = make(map[string]string, len(desired.Annotations))
+		}
+		merged.Annotations[k] = v
+	}
+
+	merged.Spec.Type = serviceType(desired)
+	merged.Spec.Selector = desired.Spec.Selector
+	merged.Spec.PublishNotReadyAddresses = desired.Spec.PublishNotReadyAddresses
+	if desired.Spec.IPFamilyPolicy != nil {
+		merged.Spec.IPFamilyPolicy = desired.Spec.IPFamilyPolicy
+	}
+	if len(desired.Spec.IPFamilies) > 0 {
+		merged.Spec.IPFamilies = desired.Spec.IPFamilies
+	}
+
+	// 保留已分配的 nodePort，ClusterIP 类型的 service 不能设置 nodePort
+	ports := make([]corev1.ServicePort, 0, len(desired.Spec.Ports))
+	for _, port := range desired.Spec.Ports {
+		for _, storedPort := range stored.Spec.Ports {
+			if storedPort.Name != port.Name {
+				continue
+			}
+			if port.NodePort == 0 && merged.Spec.Type != corev1.ServiceTypeClusterIP {
+				port.NodePort = storedPort.NodePort
+			}
+			if port.TargetPort.String() == "0" && storedPort.Port == port.Port {
+				port.TargetPort = storedPort.TargetPort
+			}
+		}
+		ports = append(ports, port)
+	}
+	merged.Spec.Ports = ports
+	return merged
+}
+
+func mergeStatefulset(desired, stored *appv1.StatefulSet) *appv1.StatefulSet {
+	merged := stored.DeepCopy()
+	mergeMetadata(&desired.ObjectMeta, &merged.ObjectMeta)
+	merged.Spec.Replicas = desired.Spec.Replicas
+	merged.Spec.Template = desired.Spec.Template
+	merged.Spec.UpdateStrategy = desired.Spec.UpdateStrategy
+	return merged
+}
+
+func mergeMetadata(desired, merged *metav1.ObjectMeta) {
+	for k, v := range desired.Labels {
+		if merged.Labels == nil {
+			merged.Labels = make(map[string]string, len(desired.Labels))
+		}
+		merged.Labels[k] = v
+	}
+	merged.OwnerReferences = desired.OwnerReferences
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"reflect"
+	"testing"
+)
+
+func TestServiceDiff(t *testing.T) {
+	g := newGenerate()
+	tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+	desired := g.service(tc.cRedis)["redis-master"]
+
+	tests := []struct {
+		name   string
+		modify func(svc *corev1.Service)
+		want   []string
+	}{
+		{
+			name: "defaults set by the API server",
+			modify: func(svc *corev1.Service) {
+				svc.Spec.ClusterIP = "10.96.0.10"
+				svc.Spec.Type = corev1.ServiceTypeClusterIP
+				svc.Spec.SessionAffinity = corev1.ServiceAffinityNone
+				svc.Spec.Ports[0].Protocol = corev1.ProtocolTCP
+				svc.Spec.Ports[0].TargetPort = intstr.FromInt(6379)
+				svc.Annotations = map[string]string{"other": "value"}
+			},
+		},
+		{
+			name: "selector edited",
+			modify: func(svc *corev1.Service) {
+				svc.Spec.Type = corev1.ServiceTypeClusterIP
+				svc.Spec.Selector = map[string]string{"app": "other"}
+			},
+			want: []string{"spec.selector"},
+		},
+		{
+			name: "port and type edited",
+			modify: func(svc *corev1.Service) {
+				svc.Spec.Type = corev1.ServiceTypeNodePort
+				svc.Spec.Ports[0].Port = 6380
+			},
+			want: []string{"spec.ports", "spec.type"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := desired.DeepCopy()
+			tt.modify(stored)
+			if got := serviceDiff(desired, stored); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("serviceDiff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatefulsetDiff(t *testing.T) {
+	g := newGenerate()
+	tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+	tc.cRedis.Spec.Templates.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}
+	desired := g.statefulset(tc.cRedis)
+
+	// desired is compared once the API server applied its defaults
+	desired.Spec.Template.Spec.Containers[0].TerminationMessagePath = corev1.TerminationMessagePathDefault
+	desired.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	stored := desired.DeepCopy()
+	stored.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory] = resource.MustParse("1024Mi")
+	stored.Spec.UpdateStrategy.RollingUpdate = nil
+	if got := statefulsetDiff(desired, stored); len(got) != 0 {
+		t.Errorf("statefulsetDiff() = %v for defaulted fields, want none", got)
+	}
+
+	stored.Spec.Template.Spec.Containers[0].Image = "redis:6.2"
+	if got := statefulsetDiff(desired, stored); !reflect.DeepEqual(got, []string{"spec.template"}) {
+		t.Errorf("statefulsetDiff() = %v, want [spec.template]", got)
+	}
+
+	// fields removed from the spec are removed from the pods too
+	stored = desired.DeepCopy()
+	stored.Spec.Template.Annotations = map[string]string{"example.com/removed": "true"}
+	stored.Spec.Template.Spec.ServiceAccountName = "removed"
+	if got := statefulsetDiff(desired, stored); !reflect.DeepEqual(got, []string{"spec.template"}) {
+		t.Errorf("statefulsetDiff() = %v for removed fields, want [spec.template]", got)
+	}
+
+	// a partition left over from a held rollout is removed once the strategy is no longer set
+	desired.Spec.UpdateStrategy = appv1.StatefulSetUpdateStrategy{}
+	stored = desired.DeepCopy()
+	partition := int32(3)
+	stored.Spec.UpdateStrategy = appv1.StatefulSetUpdateStrategy{
+		Type:          appv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+	}
+	if got := statefulsetDiff(desired, stored); !reflect.DeepEqual(got, []string{"spec.updateStrategy"}) {
+		t.Errorf("statefulsetDiff() = %v, want [spec.updateStrategy]", got)
+	}
+}
+
+func TestEnsureServiceUpdate(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+	tc.cRedis.Spec.Service = &v1beta1.ServiceConfig{Type: corev1.ServiceTypeNodePort}
+	ensure := tc.ensure()
+	if err := ensure.EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+
+	// fields allocated by the API server and annotations added by other controllers
+	svc, _ := ensure.k8sService.GetService("redis-master", "default")
+	svc.Spec.ClusterIP = "10.96.0.10"
+	svc.Spec.Ports[0].NodePort = 30079
+	svc.Annotations = map[string]string{"cloud.example.com/lb": "internal"}
+	if err := ensure.k8sService.UpdateService(svc); err != nil {
+		t.Fatal(err)
+	}
+	svc, _ = ensure.k8sService.GetService("redis-master", "default")
+
+	if err := tc.ensure().EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	unchanged, _ := ensure.k8sService.GetService("redis-master", "default")
+	if unchanged.ResourceVersion != svc.ResourceVersion {
+		t.Errorf("service updated without any change, resourceVersion %s -> %s", svc.ResourceVersion, unchanged.ResourceVersion)
+	}
+
+	tc.cRedis.Spec.Service.Annotations = map[string]string{"team": "cache"}
+	if err := tc.ensure().EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	updated, _ := ensure.k8sService.GetService("redis-master", "default")
+	if updated.Spec.ClusterIP != "10.96.0.10" || updated.Spec.Ports[0].NodePort != 30079 {
+		t.Errorf("clusterIP %s, nodePort %d, want the allocated 10.96.0.10 and 30079", updated.Spec.ClusterIP, updated.Spec.Ports[0].NodePort)
+	}
+	want := map[string]string{"cloud.example.com/lb": "internal", "team": "cache"}
+	if !reflect.DeepEqual(updated.Annotations, want) {
+		t.Errorf("annotations = %v, want %v", updated.Annotations, want)
+	}
+
+	// nodePort is released when the service goes back to ClusterIP
+	tc.cRedis.Spec.Service.Type = corev1.ServiceTypeClusterIP
+	if err := tc.ensure().EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	if updated, _ = ensure.k8sService.GetService("redis-master", "default"); updated.Spec.Ports[0].NodePort != 0 {
+		t.Errorf("nodePort = %d after switching to ClusterIP, want 0", updated.Spec.Ports[0].NodePort)
+	}
+}
+
+func TestEnsureServiceDrift(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+	ensure := tc.ensure()
+	if err := ensure.EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	if tc.cRedis.Status.Drift != nil {
+		t.Fatalf("status.drift = %+v after creating the services", tc.cRedis.Status.Drift)
+	}
+
+	svc, _ := ensure.k8sService.GetService("redis-master", "default")
+	svc.Spec.Selector = map[string]string{"app": "other"}
+	if err := ensure.k8sService.UpdateService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes made while the spec is being rolled out are not drift
+	tc.cRedis.Generation = 2
+	tc.cRedis.Status.ObservedGeneration = 1
+	if err := tc.ensure().EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	if tc.cRedis.Status.Drift != nil {
+		t.Fatalf("status.drift = %+v while the spec changed", tc.cRedis.Status.Drift)
+	}
+
+	svc, _ = ensure.k8sService.GetService("redis-master", "default")
+	svc.Spec.Selector = map[string]string{"app": "other"}
+	if err := ensure.k8sService.UpdateService(svc); err != nil {
+		t.Fatal(err)
+	}
+	tc.cRedis.Status.ObservedGeneration = 2
+	if err := tc.ensure().EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	drift := tc.cRedis.Status.Drift
+	if drift == nil || !reflect.DeepEqual(drift.Resources, []string{"Service/redis-master: spec.selector"}) {
+		t.Fatalf("status.drift = %+v, want the master service selector", drift)
+	}
+	if svc, _ = ensure.k8sService.GetService("redis-master", "default"); svc.Spec.Selector["app"] == "other" {
+		t.Errorf("selector %v was not reverted", svc.Spec.Selector)
+	}
+}
@@ -4,10 +4,20 @@ import (
 	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
 	"github.com/hongqchen/redis-operator/pkg/util"
+	"github.com/pkg/errors"
+	appv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"net"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Ensurer interface {
@@ -15,36 +25,64 @@ type Ensurer interface {
 	EnsureConfigmap(cRedis *v1beta1.CustomRedis) error
 	EnsureStatefulset(cRedis *v1beta1.CustomRedis) error
 	EnsureService(cRedis *v1beta1.CustomRedis) error
-	EnsureDeployment(cRedis *v1beta1.CustomRedis) error
+	EnsureSentinelStatefulset(cRedis *v1beta1.CustomRedis) error
+	// 清理旧版本以 deployment 方式部署的 sentinel
+	EnsureLegacySentinelRemoved(cRedis *v1beta1.CustomRedis) error
 
 	EnsurePodOwner(cRedis *v1beta1.CustomRedis) error
 	EnsurePodOwnerForSentinel(cRedis *v1beta1.CustomRedis) error
 
 	// 确认资源状态正常，所有 pod ready
 	EnsurePodReadyForStatefulset(cRedis *v1beta1.CustomRedis) error
-	EnsurePodReadyForDeployment(cRedis *v1beta1.CustomRedis) error
+	EnsurePodReadyForSentinel(cRedis *v1beta1.CustomRedis) error
 	// 确认 sentinel 监听了正确 master IP
 	EnsureSentinelMonitor(cRedis *v1beta1.CustomRedis) error
 	// 确认 slave 节点监听的 master 是正确的 IP
 	EnsureSlaveOfMaster(cRedis *v1beta1.CustomRedis) error
+	// 开启 per-pod service 时，设置 Pod 对外宣告的地址
+	EnsureExternalAnnounce(cRedis *v1beta1.CustomRedis) error
+	EnsureExternalAnnounceForSentinel(cRedis *v1beta1.CustomRedis) error
+	// 开启内存策略时，按 Pod 实际的内存 limit 在线设置 maxmemory
+	EnsureMaxMemory(cRedis *v1beta1.CustomRedis) error
+	// 维护窗口内，依次重建模板已过期的 redis Pod
+	EnsureRollingUpdate(cRedis *v1beta1.CustomRedis) error
+	// 按 spec.switchover 将指定的 slave 提升为 master
+	EnsureSwitchover(cRedis *v1beta1.CustomRedis) error
+	// 按 spec.replicaOf 复制外部 master，删除该字段后将备用集群提升为主集群
+	EnsureReplicaOf(cRedis *v1beta1.CustomRedis) error
+	EnsurePromotion(cRedis *v1beta1.CustomRedis) error
+	// 备用集群的 sentinel 不监听任何 master，避免在本地发起故障转移
+	EnsureSentinelMonitorRemoved(cRedis *v1beta1.CustomRedis) error
 	// 为不同角色的 Pod 添加 label
 	EnsureLabels(cRedis *v1beta1.CustomRedis) error
 	EnsureLabelsForSentinel(cRedis *v1beta1.CustomRedis) error
 }
 
+const (
+	// 切换期间 master 暂停写入的最长时间，operator 异常退出时由 redis 自动恢复写入
+	switchoverPauseTimeout = 30 * time.Second
+	// 等待目标 slave 追平复制偏移量，以及等待 sentinel 完成故障转移的时间
+	switchoverWaitTimeout  = 10 * time.Second
+	switchoverPollInterval = 100 * time.Millisecond
+	// 查找 master 的 init container 连续失败的次数达到该值时，视为 sentinel 无法选出新的 master
+	emptyMasterRefusedRestarts = 3
+)
+
 type Ensure struct {
 	logger       logr.Logger
 	generate     generater
 	k8sService   kubernetesServicer
 	redisService RedisServicer
+	// 本次 reconcile 发现的被外部修改的资源
+	drifts []string
 }
 
-func NewEnsure(cl client.Client, logger logr.Logger) *Ensure {
+func NewEnsure(cl client.Client, rcl redis.Clienter, logger logr.Logger) *Ensure {
 	return &Ensure{
 		logger:       logger,
 		generate:     newGenerate(),
-		k8sService:   NewkubernetesService(cl, logger),
-		redisService: NewRedisService(logger),
+		k8sService:   NewkubernetesService(cl, rcl, logger),
+		redisService: NewRedisService(rcl, logger),
 	}
 }
 
@@ -67,7 +105,8 @@ func (e *Ensure) EnsureConfigmap(cRedis *v1beta1.CustomRedis) error {
 		namespace := baseCm[k].Namespace
 		namespacedName := fmt.Sprintf("%s/%s", namespace, name)
 
-		if _, err := e.k8sService.GetConfigmap(name, namespace); err != nil {
+		storedCm, err := e.k8sService.GetConfigmap(name, namespace)
+		if err != nil {
 			if apierror.IsNotFound(err) {
 				// configmap 不存在，需要创建
 				e.logger.V(2).Info("Configmap not found", "configmap", namespacedName)
@@ -80,9 +119,14 @@ func (e *Ensure) EnsureConfigmap(cRedis *v1beta1.CustomRedis) error {
 			return err
 		}
 
-		// configmap 已存在，更新
-		e.logger.V(2).Info("Configmap already exists, need to update it", "configmap", namespacedName)
-		if err := e.k8sService.UpdateConfigmap(baseCm[k]); err != nil {
+		// configmap 已存在，与期望不一致时更新
+		fields := configmapDiff(baseCm[k], storedCm)
+		if len(fields) == 0 {
+			continue
+		}
+		e.logger.V(2).Info("Configmap already exists, need to update it", "configmap", namespacedName, "fields", fields)
+		e.detectDrift(cRedis, "ConfigMap/"+name, fields)
+		if err := e.k8sService.UpdateConfigmap(mergeConfigmap(baseCm[k], storedCm)); err != nil {
 			return err
 		}
 	}
@@ -96,7 +140,8 @@ func (e *Ensure) EnsureStatefulset(cRedis *v1beta1.CustomRedis) error {
 
 	e.logger.V(3).Info(fmt.Sprintf("Statefulset info: %+v\n", sts))
 
-	if _, err := e.k8sService.GetStatefulset(cRedis.Name, cRedis.Namespace); err != nil {
+	storedSts, err := e.k8sService.GetStatefulset(cRedis.Name, cRedis.Namespace)
+	if err != nil {
 		if apierror.IsNotFound(err) {
 			e.logger.V(2).Info("Statefulset not found")
 			return e.k8sService.CreateStatefulset(sts)
@@ -104,8 +149,32 @@ func (e *Ensure) EnsureStatefulset(cRedis *v1beta1.CustomRedis) error {
 		return err
 	}
 
-	e.logger.V(2).Info("Statefulset already exists, need to update it")
-	return e.k8sService.UpdateStatefulset(sts)
+	// serviceName 不可修改，旧版本创建的 statefulset 以 orphan 方式删除，
+	// 下一次 reconcile 重建后由新的 statefulset 接管现有 Pod
+	if storedSts.Spec.ServiceName != sts.Spec.ServiceName {
+		e.logger.Info("Statefulset serviceName changed, recreating it", "serviceName", sts.Spec.ServiceName)
+		return e.k8sService.OrphanDeleteStatefulset(cRedis.Name, cRedis.Namespace)
+	}
+	if recreated, err := e.ensureVolumeClaimTemplates(sts, storedSts); recreated || err != nil {
+		return err
+	}
+
+	fields, err := e.statefulsetDiff(sts, storedSts)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	e.logger.V(2).Info("Statefulset already exists, need to update it", "fields", fields)
+	e.detectDrift(cRedis, "StatefulSet/"+sts.Name, fields)
+	return e.k8sService.UpdateStatefulset(mergeStatefulset(sts, storedSts))
+}
+
+// statefulsetDiff 以 dry-run 得到 API server 填充默认值后的期望对象，再与集群中的对象比较
+func (e *Ensure) statefulsetDiff(desired, stored *appv1.StatefulSet) ([]string, error) {
+	defaulted, err := e.k8sService.DryRunUpdateStatefulset(mergeStatefulset(desired, stored))
+	if err != nil {
+		return nil, err
+	}
+	return statefulsetDiff(defaulted, stored), nil
 }
 
 func (e *Ensure) EnsureService(cRedis *v1beta1.CustomRedis) error {
@@ -119,7 +188,7 @@ func (e *Ensure) EnsureService(cRedis *v1beta1.CustomRedis) error {
 	for serviceName, serviceObj := range services {
 		svcName := serviceName
 		svc := serviceObj
-		if _, err := e.k8sService.GetService(svcName, namespace); err != nil {
+		if storedSvc, err := e.k8sService.GetService(svcName, namespace); err != nil {
 			if !apierror.IsNotFound(err) {
 				return err
 			}
@@ -128,32 +197,75 @@ func (e *Ensure) EnsureService(cRedis *v1beta1.CustomRedis) error {
 				return err
 			}
 		} else {
-			return e.k8sService.UpdateService(svc)
+			fields := serviceDiff(svc, storedSvc)
+			if len(fields) == 0 {
+				continue
+			}
+			e.logger.V(2).Info("Service already exists, need to update it", "service", svcName, "fields", fields)
+			e.detectDrift(cRedis, "Service/"+svcName, fields)
+			if err := e.k8sService.UpdateService(mergeService(svc, storedSvc)); err != nil {
+				return err
+			}
 		}
 	}
 
+	return e.removeExternalServices(cRedis, services)
+}
+
+// removeExternalServices 删除缩容后序号超出副本数的 Pod，以及关闭 per-pod service 后的全部 external service
+func (e *Ensure) removeExternalServices(cRedis *v1beta1.CustomRedis, services map[string]*corev1.Service) error {
+	storedServices, err := e.k8sService.GetServices(cRedis.Namespace, e.generate.createLabels(cRedis))
+	if err != nil {
+		return err
+	}
+
+	for _, svc := range storedServices {
+		if _, desired := services[svc.Name]; desired || !strings.HasSuffix(svc.Name, "-"+util.ExternalServiceSuffix) {
+			continue
+		}
+		e.logger.Info("Deleting external service no longer needed", "service", svc.Name)
+		if err := e.k8sService.DeleteService(svc.Name, svc.Namespace); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (e *Ensure) EnsureDeployment(cRedis *v1beta1.CustomRedis) error {
-	e.logger.V(1).Info("Ensuring deployment(sentinel cluster)")
-	deploy := e.generate.deployment(cRedis)
+func (e *Ensure) EnsureSentinelStatefulset(cRedis *v1beta1.CustomRedis) error {
+	e.logger.V(1).Info("Ensuring statefulset(sentinel cluster)")
+	sts := e.generate.statefulsetForSentinel(cRedis)
+
+	e.holdRollout(cRedis, sts)
+	e.logger.V(3).Info(fmt.Sprintf("Sentinel statefulset info: %+v\n", sts))
 
-	if _, err := e.k8sService.GetDeployment(fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix), cRedis.Namespace); err != nil {
+	storedSts, err := e.k8sService.GetStatefulset(sts.Name, sts.Namespace)
+	if err != nil {
 		if apierror.IsNotFound(err) {
-			return e.k8sService.CreateDeployment(deploy)
+			e.logger.V(2).Info("Sentinel statefulset not found")
+			return e.k8sService.CreateStatefulset(sts)
 		}
 		return err
 	}
-	return e.k8sService.UpdateDeployment(deploy)
+	if recreated, err := e.ensureVolumeClaimTemplates(sts, storedSts); recreated || err != nil {
+		return err
+	}
+
+	fields, err := e.statefulsetDiff(sts, storedSts)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	e.logger.V(2).Info("Sentinel statefulset already exists, need to update it", "fields", fields)
+	// partition 随维护窗口变化，不属于外部修改
+	e.detectDrift(cRedis, "StatefulSet/"+sts.Name, withoutField(fields, "spec.updateStrategy"))
+	return e.k8sService.UpdateStatefulset(mergeStatefulset(sts, storedSts))
 }
 
-func (e *Ensure) EnsurePodReadyForDeployment(cRedis *v1beta1.CustomRedis) error {
-	e.logger.V(1).Info("Ensuring all pods for deployment(sentinel nodes) are ready")
+func (e *Ensure) EnsurePodReadyForSentinel(cRedis *v1beta1.CustomRedis) error {
+	e.logger.V(1).Info("Ensuring all pods for statefulset(sentinel nodes) are ready")
 	name := fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix)
 	namespace := cRedis.Namespace
-	// 判断哨兵节点个数是否满足 spec.sentinelnums 的一半以上
-	sentinelNodes, err := e.k8sService.GetDeploymentReadyPods(name, namespace)
+	// 判断哨兵节点个数是否满足 spec.sentinelnums
+	sentinelNodes, err := e.k8sService.GetStatefulsetReadyPods(name, namespace)
 	if err != nil {
 		return err
 	}
@@ -163,6 +275,27 @@ func (e *Ensure) EnsurePodReadyForDeployment(cRedis *v1beta1.CustomRedis) error
 	return nil
 }
 
+// EnsureLegacySentinelRemoved 在新的 sentinel statefulset 就绪后，删除旧版本遗留的 sentinel deployment
+// 被删除的 sentinel 仍会残留在其余 sentinel 的已知列表中，由 CheckSentinels 逐个执行 SENTINEL RESET 清除
+func (e *Ensure) EnsureLegacySentinelRemoved(cRedis *v1beta1.CustomRedis) error {
+	e.logger.V(1).Info("Ensuring legacy sentinel deployment is removed")
+	name := fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix)
+	namespace := cRedis.Namespace
+
+	if _, err := e.k8sService.GetDeployment(name, namespace); err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	e.logger.Info("Removing legacy sentinel deployment", "deployment", fmt.Sprintf("%s/%s", namespace, name))
+	if err := e.k8sService.DeleteDeployment(name, namespace); err != nil && !apierror.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
 func (e *Ensure) EnsurePodReadyForStatefulset(cRedis *v1beta1.CustomRedis) error {
 	e.logger.V(1).Info("Ensuring all pods for statefulset(redis nodes) are ready")
 	pods, err := e.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
@@ -171,11 +304,30 @@ func (e *Ensure) EnsurePodReadyForStatefulset(cRedis *v1beta1.CustomRedis) error
 	}
 
 	if len(pods) != int(*cRedis.Spec.Replicas) {
-		return util.AllPodReadyErr
+		return e.checkEmptyMasterRefused(cRedis)
 	}
 	return nil
 }
 
+// checkEmptyMasterRefused 没有持久化数据的 Pod 找不到 master 时拒绝启动，sentinel 迟迟未完成故障转移则需要人工处理
+func (e *Ensure) checkEmptyMasterRefused(cRedis *v1beta1.CustomRedis) error {
+	pods, err := e.k8sService.GetStatefulsetPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name != util.FindMasterContainerName || status.RestartCount < emptyMasterRefusedRestarts {
+				continue
+			}
+			if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.ExitCode != 0 {
+				return errors.Wrapf(util.EmptyMasterRefusedErr, "pod %s restarted %d times", pod.Name, status.RestartCount)
+			}
+		}
+	}
+	return util.AllPodReadyErr
+}
+
 func (e *Ensure) EnsurePodOwner(cRedis *v1beta1.CustomRedis) error {
 	e.logger.V(1).Info("Ensuring a second owner references for redis pod")
 	name := cRedis.Name
@@ -196,7 +348,7 @@ func (e *Ensure) EnsurePodOwnerForSentinel(cRedis *v1beta1.CustomRedis) error {
 	namespace := cRedis.Namespace
 	sentinelName := fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix)
 
-	sentinelPods, err := e.k8sService.GetDeploymentReadyPods(sentinelName, namespace)
+	sentinelPods, err := e.k8sService.GetStatefulsetReadyPods(sentinelName, namespace)
 	if err != nil {
 		return err
 	}
@@ -244,41 +396,91 @@ func (e *Ensure) addOwnerForPods(cRedis *v1beta1.CustomRedis, allPods []corev1.P
 	return nil
 }
 
+// EnsureSentinelMonitor sentinel 模式下以 sentinel 选出的 master 为准，不与 sentinel 的故障转移竞争：
+// 任一 sentinel 正在故障转移，或多数 sentinel 选出的 master 与 redis 实际的 master 不一致时，等待 sentinel 完成；
+// 否则只修正单个 sentinel，quorum 通过 SENTINEL SET 修改，仅对地址错误的 sentinel 执行 REMOVE 与 MONITOR，
+// 避免所有 sentinel 同时丢失已知的 replica 与其他 sentinel
 func (e *Ensure) EnsureSentinelMonitor(cRedis *v1beta1.CustomRedis) error {
 	e.logger.V(1).Info("Ensuring that sentinel listens to the correct master IP")
 	// 获取当前集群中的 master 节点列表
 	// 如果节点个数 ！= 1
 	// 返回错误，重新触发 reconcile
 	// 为了重新调用 CheckNumberOfMasters 方法，确保最后只有一个 master
-	masterIPs, err := e.k8sService.GetMasterIPs(cRedis)
+	masterPods, err := e.k8sService.GetMasterPods(cRedis)
 	if err != nil {
 		return err
 	}
-	if len(masterIPs) != 1 {
+	if len(masterPods) != 1 {
 		return util.ManyMastersErr
 	}
 
-	masterIP := masterIPs[0]
+	// 开启 per-pod service 时，sentinel 监听 master 对外宣告的地址，集群外的客户端可直接访问
+	masterPod := &masterPods[0]
+	masterHost, masterPort := getRedisAnnounceAddr(cRedis, masterPod)
 
 	name := cRedis.Name
 	namespace := cRedis.Namespace
 	sentinelName := fmt.Sprintf("%s-%s", name, util.SentinelResourceSuffix)
 
-	sentinelPods, err := e.k8sService.GetDeploymentReadyPods(sentinelName, namespace)
+	redisPods, err := e.k8sService.GetStatefulsetReadyPods(name, namespace)
+	if err != nil {
+		return err
+	}
+	sentinelPods, err := e.k8sService.GetStatefulsetReadyPods(sentinelName, namespace)
 	if err != nil {
 		return err
 	}
 
-	for _, sentinelPod := range sentinelPods {
+	// 先获取所有 sentinel 的状态，再决定是否修改
+	// 备用集群提升后，sentinel 尚未监听任何 master，对应的 state 为 nil
+	states := make([]*redis.SentinelMaster, len(sentinelPods))
+	for i, sentinelPod := range sentinelPods {
+		state, err := e.redisService.GetSentinelMaster(cRedis, sentinelPod.Status.PodIP)
+		if err != nil && !errors.Is(err, redis.ErrNoMonitor) {
+			return err
+		}
+		if state != nil && state.IsFailoverInProgress() {
+			return errors.Wrapf(util.MasterBeElectingErr, "sentinel %s is failing over %s", sentinelPod.Name, state.IP)
+		}
+		states[i] = state
+	}
+
+	// 多数 sentinel 选出了另一个仍在运行的 Pod，复制关系尚未按 sentinel 的决定更新
+	if elected := electedSentinelMaster(states); elected != "" && !isRedisHost(cRedis, masterPod, elected) {
+		for i := range redisPods {
+			if isRedisHost(cRedis, &redisPods[i], elected) {
+				return errors.Wrapf(util.MasterBeElectingErr, "sentinels elected %s, redis reports %s as master", redisPods[i].Name, masterPod.Name)
+			}
+		}
+	}
+
+	for i, sentinelPod := range sentinelPods {
 		sentinelIP := sentinelPod.Status.PodIP
+		state := states[i]
 
-		monitorIP, _, err := e.redisService.GetSentinelMonitor(cRedis, sentinelIP)
-		if err != nil {
-			return err
+		if state == nil {
+			if err := e.redisService.SetSentinelMonitor(cRedis, sentinelIP, masterHost, masterPort); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// 占位地址、已不存在的 Pod 或其他 Pod 的地址，此时多数派与 redis 的 master 一致，只修正该 sentinel
+		// 设置 sentinel monitor 为实际 master 的稳定 DNS 名称
+		if state.IP != masterHost || state.Port != strconv.Itoa(int(masterPort)) {
+			e.logger.Info("Sentinel monitors a wrong master, monitoring the current master again",
+				"sentinel", sentinelPod.Name, "monitor", net.JoinHostPort(state.IP, state.Port), "master", masterPod.Name)
+			if err := e.redisService.RemoveSentinelMonitor(cRedis, sentinelIP); err != nil {
+				return err
+			}
+			if err := e.redisService.SetSentinelMonitor(cRedis, sentinelIP, masterHost, masterPort); err != nil {
+				return err
+			}
+			continue
 		}
-		if monitorIP == "127.0.0.1" || monitorIP != masterIP {
-			// 设置 sentinel monitor 为实际的 master IP
-			if err := e.redisService.SetSentinelMonitor(cRedis, sentinelIP, masterIP); err != nil {
+
+		if state.Quorum != sentinelQuorum(cRedis) {
+			if err := e.redisService.SetSentinelQuorum(cRedis, sentinelIP); err != nil {
 				return err
 			}
 		}
@@ -287,20 +489,39 @@ func (e *Ensure) EnsureSentinelMonitor(cRedis *v1beta1.CustomRedis) error {
 	return nil
 }
 
+// electedSentinelMaster 超过半数 sentinel 监听的 master 地址，不包括仍在监听占位地址的 sentinel
+func electedSentinelMaster(states []*redis.SentinelMaster) string {
+	votes := make(map[string]int)
+	for _, state := range states {
+		if state == nil || util.IsLoopbackHost(state.IP) {
+			continue
+		}
+		votes[state.IP]++
+	}
+
+	for host, count := range votes {
+		if count*2 > len(states) {
+			return host
+		}
+	}
+	return ""
+}
+
 func (e *Ensure) EnsureSlaveOfMaster(cRedis *v1beta1.CustomRedis) error {
 	e.logger.V(1).Info("Ensuring all slave pods are listening to the correct master")
-	masterIPs, err := e.k8sService.GetMasterIPs(cRedis)
+	masterPods, err := e.k8sService.GetMasterPods(cRedis)
 	if err != nil {
 		return err
 	}
 
-	e.logger.V(3).Info(fmt.Sprintf("MasterIps length: %d, detail: %+v\n", len(masterIPs), masterIPs))
+	e.logger.V(3).Info(fmt.Sprintf("Master pods length: %d", len(masterPods)))
 
-	if len(masterIPs) != 1 {
+	if len(masterPods) != 1 {
 		return util.ManyMastersErr
 	}
 
-	masterIP := masterIPs[0]
+	masterPod := &masterPods[0]
+	masterHost := getRedisHost(cRedis, masterPod)
 	name := cRedis.Name
 	namespace := cRedis.Namespace
 
@@ -311,21 +532,24 @@ func (e *Ensure) EnsureSlaveOfMaster(cRedis *v1beta1.CustomRedis) error {
 
 	e.logger.V(3).Info(fmt.Sprintf("Redis nodes length: %d, detail: %+v\n", len(redisNodes), redisNodes))
 	for _, redisNode := range redisNodes {
-		slaveIP := redisNode.Status.PodIP
-		if slaveIP == masterIP {
+		if redisNode.Name == masterPod.Name {
 			continue
 		}
 
+		slaveIP := redisNode.Status.PodIP
 		storedMaster, err := e.redisService.GetReplicationOfMasterHost(cRedis, slaveIP)
 		if err != nil {
 			return err
 		}
 
-		if storedMaster == masterIP {
+		// 旧版本通过 IP 建立的复制关系，也会被修正为基于 DNS 名称
+		// sentinel 故障转移后，slave 可能指向 master 对外宣告的地址，同样视为正确
+		announceHost, _ := getRedisAnnounceAddr(cRedis, masterPod)
+		if storedMaster == masterHost || storedMaster == announceHost {
 			continue
 		}
 
-		if err := e.redisService.SetAsSlave(cRedis, slaveIP, masterIP); err != nil {
+		if err := e.redisService.SetAsSlave(cRedis, slaveIP, masterHost); err != nil {
 			return err
 		}
 	}
@@ -333,30 +557,40 @@ func (e *Ensure) EnsureSlaveOfMaster(cRedis *v1beta1.CustomRedis) error {
 	return nil
 }
 
-func (e *Ensure) EnsureLabels(cRedis *v1beta1.CustomRedis) error {
-	e.logger.V(1).Info("Ensuring pod's label for redis")
-	name := cRedis.Name
-	namespace := cRedis.Namespace
-
-	pods, err := e.k8sService.GetStatefulsetReadyPods(name, namespace)
+// EnsureExternalAnnounce 开启 per-pod service 时，redis 节点对外宣告其 service 的外部地址
+// 运行时通过 CONFIG SET 设置，Pod 重启后由下一次 reconcile 重新设置；关闭后恢复宣告 Pod 的 DNS 名称
+func (e *Ensure) EnsureExternalAnnounce(cRedis *v1beta1.CustomRedis) error {
+	e.logger.V(1).Info("Ensuring redis pods announce their external address")
+	pods, err := e.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
 	if err != nil {
 		return err
 	}
+
 	for _, pod := range pods {
-		podObj := pod.DeepCopy()
-		ip := podObj.Status.PodIP
-		ismaster, err := e.redisService.IsMaster(cRedis, ip)
+		// 关闭 per-pod service 后恢复宣告 Pod 的 DNS 名称
+		if !cRedis.IsPerPodServiceEnabled() {
+			if _, exists := pod.Annotations[util.AnnounceIPAnnotation]; !exists {
+				continue
+			}
+			if err := e.redisService.SetReplicaAnnounce(cRedis, pod.Status.PodIP, getRedisHost(cRedis, &pod), 0); err != nil {
+				return err
+			}
+			if err := e.removeAnnounceAddr(&pod); err != nil {
+				return err
+			}
+			continue
+		}
+
+		announceIP, announcePort, err := e.k8sService.GetPodExternalAddress(&pod)
 		if err != nil {
 			return err
 		}
-		if ismaster {
-			// 添加 role: master label
-			podObj.ObjectMeta.Labels["redis.hongqchen/role"] = "master"
-		} else {
-			// 添加 role: slave label
-			podObj.ObjectMeta.Labels["redis.hongqchen/role"] = "slave"
+
+		if err := e.redisService.SetReplicaAnnounce(cRedis, pod.Status.PodIP, announceIP, announcePort); err != nil {
+			return err
 		}
-		if err := e.k8sService.UpdatePodIfExists(podObj); err != nil {
+
+		if err := e.annotateAnnounceAddr(&pod, announceIP, announcePort); err != nil {
 			return err
 		}
 	}
@@ -364,18 +598,891 @@ func (e *Ensure) EnsureLabels(cRedis *v1beta1.CustomRedis) error {
 	return nil
 }
 
-func (e *Ensure) EnsureLabelsForSentinel(cRedis *v1beta1.CustomRedis) error {
-	e.logger.V(1).Info("Ensuring pod's label for sentinel")
+// EnsureExternalAnnounceForSentinel sentinel 通过 SENTINEL CONFIG SET 设置的地址会写入其配置文件，
+// 因此以 Pod 注解判断是否已设置，关闭 per-pod service 后同样需要恢复
+func (e *Ensure) EnsureExternalAnnounceForSentinel(cRedis *v1beta1.CustomRedis) error {
+	e.logger.V(1).Info("Ensuring sentinel pods announce their external address")
+	sentinelName := fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix)
+	sentinelPods, err := e.k8sService.GetStatefulsetReadyPods(sentinelName, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, sentinelPod := range sentinelPods {
+		if !cRedis.IsPerPodServiceEnabled() {
+			if _, exists := sentinelPod.Annotations[util.AnnounceIPAnnotation]; !exists {
+				continue
+			}
+			sentinelHost := util.GetPodFQDN(sentinelPod.Name, sentinelPod.Spec.Subdomain, sentinelPod.Namespace)
+			if err := e.redisService.SetSentinelAnnounce(cRedis, sentinelPod.Status.PodIP, sentinelHost, 0); err != nil {
+				return err
+			}
+			if err := e.removeAnnounceAddr(&sentinelPod); err != nil {
+				return err
+			}
+			continue
+		}
+
+		announceIP, announcePort, err := e.k8sService.GetPodExternalAddress(&sentinelPod)
+		if err != nil {
+			return err
+		}
+
+		if sentinelPod.Annotations[util.AnnounceIPAnnotation] == announceIP &&
+			sentinelPod.Annotations[util.AnnouncePortAnnotation] == strconv.Itoa(int(announcePort)) {
+			continue
+		}
+
+		if err := e.redisService.SetSentinelAnnounce(cRedis, sentinelPod.Status.PodIP, announceIP, announcePort); err != nil {
+			return err
+		}
+
+		if err := e.annotateAnnounceAddr(&sentinelPod, announceIP, announcePort); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Ensure) annotateAnnounceAddr(pod *corev1.Pod, announceIP string, announcePort int32) error {
+	port := strconv.Itoa(int(announcePort))
+	if pod.Annotations[util.AnnounceIPAnnotation] == announceIP && pod.Annotations[util.AnnouncePortAnnotation] == port {
+		return nil
+	}
+
+	podObj := pod.DeepCopy()
+	if podObj.Annotations == nil {
+		podObj.Annotations = make(map[string]string, 2)
+	}
+	podObj.Annotations[util.AnnounceIPAnnotation] = announceIP
+	podObj.Annotations[util.AnnouncePortAnnotation] = port
+
+	return e.k8sService.UpdatePodIfExists(podObj)
+}
+
+func (e *Ensure) removeAnnounceAddr(pod *corev1.Pod) error {
+	podObj := pod.DeepCopy()
+	delete(podObj.Annotations, util.AnnounceIPAnnotation)
+	delete(podObj.Annotations, util.AnnouncePortAnnotation)
+
+	return e.k8sService.UpdatePodIfExists(podObj)
+}
+
+// detectDrift 记录 CustomRedis spec 未变化时被外部修改的资源，随后的更新会将其还原
+// spec 变化后资源与生成结果不一致是预期的，不视为漂移
+func (e *Ensure) detectDrift(cRedis *v1beta1.CustomRedis, resource string, fields []string) {
+	if len(fields) == 0 || cRedis.Status.ObservedGeneration == 0 || cRedis.Status.ObservedGeneration != cRedis.Generation {
+		return
+	}
+
+	e.logger.Info("Reverting change made outside the operator", "resource", resource, "fields", fields)
+	e.drifts = append(e.drifts, fmt.Sprintf("%s: %s", resource, strings.Join(fields, ", ")))
+	cRedis.Status.Drift = &v1beta1.DriftStatus{
+		Resources:    append([]string(nil), e.drifts...),
+		DetectedTime: metav1.Now(),
+	}
+}
+
+// ensureVolumeClaimTemplates volumeClaimTemplates 不可修改，新增或移除数据卷、存储扩大时，
+// 以 orphan 方式删除 statefulset，下一次 reconcile 以新的模板重建，由其接管现有 Pod
+// 存储扩大时先逐个扩容已有的 PVC，任一 PVC 无法扩容时保持原有大小
+// 返回 true 表示 statefulset 已删除
+func (e *Ensure) ensureVolumeClaimTemplates(sts, storedSts *appv1.StatefulSet) (bool, error) {
+	recreate := !sameClaimTemplates(sts, storedSts)
+	if recreate {
+		e.logger.Info("Volume claim templates added or removed", "statefulset", sts.Name)
+	}
+
+	for _, template := range sts.Spec.VolumeClaimTemplates {
+		expanded, err := e.expandVolumes(sts, storedSts, template.Name)
+		if err != nil {
+			return false, err
+		}
+		recreate = recreate || expanded
+	}
+	if !recreate {
+		return false, nil
+	}
+
+	e.logger.Info("Recreating statefulset with the new volume claim templates", "statefulset", sts.Name)
+	return true, e.k8sService.OrphanDeleteStatefulset(sts.Name, sts.Namespace)
+}
+
+// expandVolumes 将名为 template 的数据卷的所有 PVC 扩容到期望大小，返回 true 表示需要重建 statefulset
+func (e *Ensure) expandVolumes(sts, storedSts *appv1.StatefulSet, template string) (bool, error) {
+	desired, stored := claimStorage(sts, template), claimStorage(storedSts, template)
+	if desired == nil || stored == nil || desired.Cmp(*stored) == 0 {
+		return false, nil
+	}
+	if desired.Cmp(*stored) < 0 {
+		e.logger.Info("Volumes cannot be shrunk, keeping the current size",
+			"statefulset", sts.Name, "volume", template, "size", stored.String(), "requested", desired.String())
+		return false, nil
+	}
+
+	// 缩容后保留的 PVC 同样扩容，避免再次扩容时 Pod 使用旧的大小
+	replicas := *sts.Spec.Replicas
+	if *storedSts.Spec.Replicas > replicas {
+		replicas = *storedSts.Spec.Replicas
+	}
+	var pvcs []*corev1.PersistentVolumeClaim
+	for i := 0; i < int(replicas); i++ {
+		pvc, err := e.k8sService.GetPersistentVolumeClaim(volumeClaimName(template, sts.Name, i), sts.Namespace)
+		if err != nil {
+			if apierror.IsNotFound(err) {
+				continue
+			}
+			return false, err
+		}
+
+		storageClassName := ""
+		if pvc.Spec.StorageClassName != nil {
+			storageClassName = *pvc.Spec.StorageClassName
+		}
+		allowed, err := e.k8sService.IsVolumeExpansionAllowed(storageClassName)
+		if err != nil {
+			return false, err
+		}
+		// 任一 PVC 无法扩容时不扩容，status.volumes 中记录原因
+		if !allowed {
+			e.logger.Info("Storage class does not allow volume expansion, keeping the current size", "pvc", pvc.Name, "storageClass", storageClassName)
+			return false, nil
+		}
+		pvcs = append(pvcs, pvc)
+	}
+
+	for _, pvc := range pvcs {
+		if claimed := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; claimed.Cmp(*desired) >= 0 {
+			continue
+		}
+		e.logger.Info("Expanding volume", "pvc", pvc.Name, "size", desired.String())
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = *desired
+		if err := e.k8sService.UpdatePersistentVolumeClaim(pvc); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func withoutField(fields []string, field string) []string {
+	filtered := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f != field {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
+
+// holdRollout 维护窗口之外，通过 partition 阻止 statefulset 滚动更新已有 Pod
+// 窗口开启后重新生成的 statefulset 不含 partition，滚动更新继续
+func (e *Ensure) holdRollout(cRedis *v1beta1.CustomRedis, sts *appv1.StatefulSet) {
+	if cRedis.IsDisruptionAllowed(time.Now()) {
+		return
+	}
+
+	e.logger.V(2).Info("Outside maintenance windows, holding statefulset rollout", "statefulset", sts.Name)
+	partition := *sts.Spec.Replicas
+	sts.Spec.UpdateStrategy = appv1.StatefulSetUpdateStrategy{
+		Type: appv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appv1.RollingUpdateStatefulSetStrategy{
+			Partition: &partition,
+		},
+	}
+}
+
+// EnsureMaxMemory 配置文件仅在 redis 启动时生效，内存策略或 limit 变化后通过 CONFIG SET 应用到运行中的实例
+// OnDelete 策略下 Pod 在重建前仍使用旧的 limit，因此按每个 Pod 自身的 limit 计算，避免 maxmemory 超出 limit
+func (e *Ensure) EnsureMaxMemory(cRedis *v1beta1.CustomRedis) error {
+	if cRedis.Spec.Memory == nil {
+		return nil
+	}
+	e.logger.V(1).Info("Ensuring maxmemory of redis pods")
+
+	pods, err := e.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		pod := &pods[i]
+		config := map[string]string{}
+		for _, container := range pod.Spec.Containers {
+			if container.Name != cRedis.Name {
+				continue
+			}
+			if maxmemory, ok := cRedis.MaxMemory(container.Resources.Limits); ok {
+				config["maxmemory"] = strconv.FormatInt(maxmemory, 10)
+			}
+		}
+		if policy := cRedis.EvictionPolicy(); policy != "" {
+			config["maxmemory-policy"] = policy
+		}
+
+		for parameter, value := range config {
+			stored, err := e.redisService.GetConfig(cRedis, pod.Status.PodIP, parameter)
+			if err != nil {
+				return err
+			}
+			if stored == value {
+				continue
+			}
+			e.logger.Info("Setting redis config", "pod", pod.Name, "parameter", parameter, "value", value, "previous", stored)
+			if err := e.redisService.SetConfig(cRedis, pod.Status.PodIP, parameter, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// EnsureRollingUpdate redis statefulset 使用 OnDelete 策略，开启 spec.autoRestart 后，模板变更由 operator 删除 Pod 触发重建
+// 每次 reconcile 只重建一个 Pod，先 slave 后 master；master 先通过 sentinel 故障转移降为 slave 再重建，
+// master-slave 模式下 master 需要手动切换后重建
+func (e *Ensure) EnsureRollingUpdate(cRedis *v1beta1.CustomRedis) error {
+	// 未开启时与 OnDelete 策略一致，由用户删除 Pod
+	if !cRedis.Spec.AutoRestart {
+		return nil
+	}
+	e.logger.V(1).Info("Ensuring redis pods run the latest statefulset revision")
 	name := cRedis.Name
 	namespace := cRedis.Namespace
-	sentinelName := fmt.Sprintf("%s-%s", name, util.SentinelResourceSuffix)
 
-	sentinelPods, err := e.k8sService.GetDeploymentReadyPods(sentinelName, namespace)
+	storedSts, err := e.k8sService.GetStatefulset(name, namespace)
 	if err != nil {
 		return err
 	}
+	updateRevision := storedSts.Status.UpdateRevision
+	if updateRevision == "" {
+		return nil
+	}
 
-	for _, sentinelPod := range sentinelPods {
+	pods, err := e.k8sService.GetStatefulsetReadyPods(name, namespace)
+	if err != nil {
+		return err
+	}
+
+	var outdated []corev1.Pod
+	for _, pod := range pods {
+		if pod.Labels[appv1.ControllerRevisionHashLabelKey] != updateRevision {
+			outdated = append(outdated, pod)
+		}
+	}
+	if len(outdated) == 0 {
+		return nil
+	}
+
+	// 维护窗口之外既不删除 slave，也不对 master 发起故障转移
+	if !cRedis.IsDisruptionAllowed(time.Now()) {
+		e.logger.Info("Outside maintenance windows, deferring rolling update", "outdatedPods", len(outdated))
+		return nil
+	}
+
+	var outdatedSlaves, outdatedMasters []corev1.Pod
+	for _, pod := range outdated {
+		ismaster, err := e.redisService.IsMaster(cRedis, pod.Status.PodIP)
+		if err != nil {
+			return err
+		}
+		if ismaster {
+			outdatedMasters = append(outdatedMasters, pod)
+		} else {
+			outdatedSlaves = append(outdatedSlaves, pod)
+		}
+	}
+
+	// 与 statefulset 一致，从序号最大的 Pod 开始
+	sort.Slice(outdatedSlaves, func(i, j int) bool {
+		return outdatedSlaves[i].Name > outdatedSlaves[j].Name
+	})
+	if len(outdatedSlaves) > 0 {
+		pod := outdatedSlaves[0]
+		e.logger.Info("Restarting outdated slave", "pod", pod.Name, "revision", updateRevision)
+		if err := e.k8sService.DeletePod(pod.Name, namespace); err != nil {
+			return err
+		}
+		return util.RollingUpdateErr
+	}
+
+	if cRedis.Spec.ClusterMode != v1beta1.Sentinel {
+		e.logger.Info("Master runs an outdated revision, delete it manually after a switchover", "pod", outdatedMasters[0].Name)
+		return nil
+	}
+
+	// master 降为 slave 后，下一次 reconcile 按 slave 重建
+	sentinelPods, err := e.k8sService.GetStatefulsetReadyPods(fmt.Sprintf("%s-%s", name, util.SentinelResourceSuffix), namespace)
+	if err != nil {
+		return err
+	}
+	if len(sentinelPods) == 0 {
+		return util.AllPodReadyErr
+	}
+	e.logger.Info("Failing over outdated master before restarting it", "pod", outdatedMasters[0].Name, "revision", updateRevision)
+	if err := e.redisService.SentinelFailover(cRedis, sentinelPods[0].Status.PodIP); err != nil {
+		return err
+	}
+	return util.RollingUpdateErr
+}
+
+// EnsureSwitchover 将 spec.switchover 指定的 slave 平滑提升为 master，每个目标 Pod 与 request ID 只执行一次
+// 先暂停 master 写入，待目标 slave 追平复制偏移量后再切换，结果记录在 status.switchover
+func (e *Ensure) EnsureSwitchover(cRedis *v1beta1.CustomRedis) error {
+	if !cRedis.IsSwitchoverRequested() {
+		return nil
+	}
+
+	target := cRedis.Spec.Switchover.TargetPod
+	requestID := cRedis.Spec.Switchover.RequestID
+	e.logger.V(1).Info("Ensuring switchover", "targetPod", target, "requestID", requestID)
+
+	// 备用集群没有本地 master，需先删除 spec.replicaOf 提升集群
+	if cRedis.IsStandby() {
+		cRedis.Status.Switchover = &v1beta1.SwitchoverStatus{
+			TargetPod:          target,
+			RequestID:          requestID,
+			Phase:              v1beta1.SwitchoverFailed,
+			Message:            "switchover is not supported while spec.replicaOf is set",
+			ObservedGeneration: cRedis.Generation,
+			CompletionTime:     metav1.Now(),
+		}
+		return nil
+	}
+
+	masterPods, err := e.k8sService.GetMasterPods(cRedis)
+	if err != nil {
+		return err
+	}
+	// sentinel 上一次未在等待时间内完成故障转移，根据实际的 master 记录结果，不再次发起切换
+	if last := cRedis.Status.Switchover; last != nil && last.Phase == v1beta1.SwitchoverInProgress &&
+		last.TargetPod == target && last.RequestID == requestID {
+		return e.completeSwitchover(cRedis, last, masterPods)
+	}
+
+	// 集群存在多个 master 时，等待 CheckNumberOfMasters 处理后再切换
+	if len(masterPods) != 1 {
+		return util.ManyMastersErr
+	}
+	masterPod := &masterPods[0]
+
+	result := &v1beta1.SwitchoverStatus{
+		TargetPod:          target,
+		RequestID:          requestID,
+		From:               masterPod.Name,
+		Phase:              v1beta1.SwitchoverSucceeded,
+		ObservedGeneration: cRedis.Generation,
+	}
+	err = e.switchover(cRedis, masterPod, result)
+	switch {
+	case errors.Is(err, util.MasterBeElectingErr):
+		e.logger.Info("Sentinel is still failing over, checking the result later", "from", masterPod.Name, "targetPod", target)
+		result.Phase = v1beta1.SwitchoverInProgress
+		result.Message = err.Error()
+		cRedis.Status.Switchover = result
+		return err
+	case err != nil:
+		e.logger.Error(err, "Switchover failed", "from", masterPod.Name, "targetPod", target)
+		result.Phase = v1beta1.SwitchoverFailed
+		result.Message = err.Error()
+	default:
+		e.logger.Info("Switchover succeeded", "from", masterPod.Name, "targetPod", target)
+	}
+	result.CompletionTime = metav1.Now()
+	cRedis.Status.Switchover = result
+
+	return nil
+}
+
+// completeSwitchover sentinel 完成故障转移后，目标 Pod 为唯一的 master 时切换成功，sentinel 仍在故障转移时继续等待
+// 故障转移结束后恢复其余 slave 的 replica-priority
+func (e *Ensure) completeSwitchover(cRedis *v1beta1.CustomRedis, result *v1beta1.SwitchoverStatus, masterPods []corev1.Pod) error {
+	failingOver, err := e.isSentinelFailingOver(cRedis)
+	if err != nil {
+		return err
+	}
+	if failingOver || len(masterPods) != 1 {
+		return errors.Wrapf(util.MasterBeElectingErr, "sentinel is still promoting pod %s", result.TargetPod)
+	}
+
+	if len(result.ReplicaPriorities) > 0 {
+		pods, err := e.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
+		if err != nil {
+			return err
+		}
+		e.restoreReplicaPriorities(cRedis, result.ReplicaPriorities, pods)
+		result.ReplicaPriorities = nil
+	}
+
+	if masterPods[0].Name == result.TargetPod {
+		e.logger.Info("Switchover succeeded", "from", result.From, "targetPod", result.TargetPod)
+		result.Phase = v1beta1.SwitchoverSucceeded
+		result.Message = ""
+	} else {
+		e.logger.Info("Switchover failed, sentinel did not promote the target", "master", masterPods[0].Name, "targetPod", result.TargetPod)
+		result.Phase = v1beta1.SwitchoverFailed
+		result.Message = fmt.Sprintf("sentinel did not promote pod %s, %s is master", result.TargetPod, masterPods[0].Name)
+	}
+	result.CompletionTime = metav1.Now()
+	return nil
+}
+
+// isSentinelFailingOver 是否有 sentinel 报告 master 正在故障转移
+func (e *Ensure) isSentinelFailingOver(cRedis *v1beta1.CustomRedis) (bool, error) {
+	sentinelPods, err := e.k8sService.GetStatefulsetReadyPods(fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix), cRedis.Namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, sentinelPod := range sentinelPods {
+		state, err := e.redisService.GetSentinelMaster(cRedis, sentinelPod.Status.PodIP)
+		if errors.Is(err, redis.ErrNoMonitor) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if state.IsFailoverInProgress() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (e *Ensure) switchover(cRedis *v1beta1.CustomRedis, masterPod *corev1.Pod, result *v1beta1.SwitchoverStatus) error {
+	target := result.TargetPod
+	if masterPod.Name == target {
+		return nil
+	}
+
+	pods, err := e.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	var targetPod *corev1.Pod
+	for i := range pods {
+		if pods[i].Name == target {
+			targetPod = &pods[i]
+		}
+	}
+	if targetPod == nil {
+		return errors.Errorf("pod %s is not a ready redis pod", target)
+	}
+
+	replication, err := e.redisService.GetReplication(cRedis, targetPod.Status.PodIP)
+	if err != nil {
+		return err
+	}
+	if !replication.IsLinkUp() || !isRedisHost(cRedis, masterPod, replication.MasterHost) {
+		return errors.Errorf("pod %s is not in sync with master %s", target, masterPod.Name)
+	}
+
+	// 暂停写入直到切换完成，old master 降为 slave 后再恢复
+	masterIP := masterPod.Status.PodIP
+	if err := e.redisService.PauseWrites(cRedis, masterIP, switchoverPauseTimeout); err != nil {
+		return err
+	}
+	defer func() {
+		if err := e.redisService.UnpauseWrites(cRedis, masterIP); err != nil {
+			e.logger.Error(err, "Failed to unpause writes, they resume after the pause timeout", "pod", masterPod.Name)
+		}
+	}()
+
+	if err := e.waitForCatchUp(cRedis, masterIP, targetPod); err != nil {
+		return err
+	}
+
+	if cRedis.Spec.ClusterMode == v1beta1.Sentinel {
+		err = e.promoteBySentinel(cRedis, masterPod, targetPod, pods, result)
+	} else {
+		err = e.promote(cRedis, masterPod, targetPod)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 其余 slave 指向新 master，更新 role label 使 master/slave service 指向新的 Pod
+	if err := e.EnsureSlaveOfMaster(cRedis); err != nil {
+		return err
+	}
+	return e.EnsureLabels(cRedis)
+}
+
+// waitForCatchUp 等待 slave 处理完 master 暂停写入前的全部复制数据
+func (e *Ensure) waitForCatchUp(cRedis *v1beta1.CustomRedis, masterIP string, slavePod *corev1.Pod) error {
+	master, err := e.redisService.GetReplication(cRedis, masterIP)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	err = wait.PollImmediate(switchoverPollInterval, switchoverWaitTimeout, func() (bool, error) {
+		slave, err := e.redisService.GetReplication(cRedis, slavePod.Status.PodIP)
+		if err != nil {
+			return false, err
+		}
+		offset = slave.ProcessedOffset()
+		return slave.IsLinkUp() && offset >= master.MasterReplOffset, nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "pod %s did not catch up with the master, offset %d of %d", slavePod.Name, offset, master.MasterReplOffset)
+	}
+	return nil
+}
+
+// promote master-slave 模式下直接提升目标 slave，old master 随即降为其 slave
+func (e *Ensure) promote(cRedis *v1beta1.CustomRedis, masterPod, targetPod *corev1.Pod) error {
+	if err := e.redisService.SetAsMaster(cRedis, targetPod.Status.PodIP); err != nil {
+		return err
+	}
+	return e.redisService.SetAsSlave(cRedis, masterPod.Status.PodIP, getRedisHost(cRedis, targetPod))
+}
+
+// promoteBySentinel 由 sentinel 执行故障转移，保证 sentinel 记录的 master 与实际一致
+// 故障转移期间将其余 slave 的 replica-priority 置为 0，sentinel 只能选择目标 slave；
+// 等待时间内未完成故障转移时保持降低的优先级并记录在 result 中，由 completeSwitchover 恢复
+func (e *Ensure) promoteBySentinel(cRedis *v1beta1.CustomRedis, masterPod, targetPod *corev1.Pod, pods []corev1.Pod, result *v1beta1.SwitchoverStatus) (err error) {
+	priority, err := e.redisService.GetConfig(cRedis, targetPod.Status.PodIP, "replica-priority")
+	if err != nil {
+		return err
+	}
+	if priority == "0" {
+		return errors.Errorf("pod %s has replica-priority 0 and can not be promoted by sentinel", targetPod.Name)
+	}
+
+	storedPriorities := make(map[string]string, len(pods))
+	defer func() {
+		if errors.Is(err, util.MasterBeElectingErr) {
+			result.ReplicaPriorities = storedPriorities
+			return
+		}
+		e.restoreReplicaPriorities(cRedis, storedPriorities, pods)
+	}()
+	for _, pod := range pods {
+		if pod.Name == masterPod.Name || pod.Name == targetPod.Name {
+			continue
+		}
+		ip := pod.Status.PodIP
+		priority, err := e.redisService.GetConfig(cRedis, ip, "replica-priority")
+		if err != nil {
+			return err
+		}
+		if err := e.redisService.SetConfig(cRedis, ip, "replica-priority", "0"); err != nil {
+			return err
+		}
+		storedPriorities[pod.Name] = priority
+	}
+
+	sentinelName := fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix)
+	sentinelPods, err := e.k8sService.GetStatefulsetReadyPods(sentinelName, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	if len(sentinelPods) == 0 {
+		return util.AllPodReadyErr
+	}
+	sentinelIP := sentinelPods[0].Status.PodIP
+	if err := e.redisService.SentinelFailover(cRedis, sentinelIP); err != nil {
+		return err
+	}
+
+	err = wait.PollImmediate(switchoverPollInterval, switchoverWaitTimeout, func() (bool, error) {
+		monitorHost, _, err := e.redisService.GetSentinelMonitor(cRedis, sentinelIP)
+		if err != nil || !isRedisHost(cRedis, targetPod, monitorHost) {
+			return false, nil
+		}
+		return e.redisService.IsMaster(cRedis, targetPod.Status.PodIP)
+	})
+	if err != nil {
+		// sentinel 可能在等待时间之后才完成故障转移，重新读取实际的 master，不立即记录失败
+		if ismaster, masterErr := e.redisService.IsMaster(cRedis, targetPod.Status.PodIP); masterErr == nil && ismaster {
+			return nil
+		}
+		if failingOver, failoverErr := e.isSentinelFailingOver(cRedis); failoverErr == nil && failingOver {
+			return errors.Wrapf(util.MasterBeElectingErr, "sentinel is still promoting pod %s", targetPod.Name)
+		}
+		return errors.Wrapf(err, "sentinel did not promote pod %s", targetPod.Name)
+	}
+	return nil
+}
+
+// restoreReplicaPriorities 恢复切换期间被置为 0 的 replica-priority，重启过的 Pod 已从配置文件恢复，无需处理
+func (e *Ensure) restoreReplicaPriorities(cRedis *v1beta1.CustomRedis, priorities map[string]string, pods []corev1.Pod) {
+	for _, pod := range pods {
+		priority, ok := priorities[pod.Name]
+		if !ok {
+			continue
+		}
+		if err := e.redisService.SetConfig(cRedis, pod.Status.PodIP, "replica-priority", priority); err != nil {
+			e.logger.Error(err, "Failed to restore replica-priority", "pod", pod.Name, "priority", priority)
+		}
+	}
+}
+
+func (e *Ensure) EnsureLabels(cRedis *v1beta1.CustomRedis) error {
+	e.logger.V(1).Info("Ensuring pod's label for redis")
+	name := cRedis.Name
+	namespace := cRedis.Namespace
+
+	pods, err := e.k8sService.GetStatefulsetReadyPods(name, namespace)
+	if err != nil {
+		return err
+	}
+
+	infos := make([]*redis.Info, len(pods))
+	var masterOffset int64 = -1
+	for i, pod := range pods {
+		infos[i], err = e.redisService.GetInfo(cRedis, pod.Status.PodIP)
+		if err != nil {
+			return err
+		}
+		if infos[i].Replication.IsMaster() {
+			masterOffset = infos[i].Replication.MasterReplOffset
+		}
+	}
+	// 备用集群没有本地 master，以复制进度最快的 Pod 为准计算延迟
+	if masterOffset < 0 && cRedis.IsStandby() {
+		for _, info := range infos {
+			if offset := info.Replication.ProcessedOffset(); offset > masterOffset {
+				masterOffset = offset
+			}
+		}
+	}
+
+	for i, pod := range pods {
+		podObj := pod.DeepCopy()
+		if infos[i].Replication.IsMaster() {
+			// 添加 role: master label
+			podObj.ObjectMeta.Labels["redis.hongqchen/role"] = "master"
+		} else {
+			// 添加 role: slave label
+			podObj.ObjectMeta.Labels["redis.hongqchen/role"] = "slave"
+		}
+		eligible := isReadEligible(infos[i], masterOffset, cRedis.MaxReplicaLag())
+		podObj.ObjectMeta.Labels[util.ReadEligibleLabel] = strconv.FormatBool(eligible)
+		if pod.Labels[util.ReadEligibleLabel] != podObj.Labels[util.ReadEligibleLabel] {
+			e.logger.Info("Changing read eligibility of redis pod", "pod", pod.Name, "eligible", eligible,
+				"linkStatus", infos[i].Replication.MasterLinkStatus, "loading", infos[i].Persistence.Loading)
+		}
+		// label 未变化时不更新 Pod，避免每次 reconcile 都写入 apiserver
+		if pod.Labels["redis.hongqchen/role"] == podObj.Labels["redis.hongqchen/role"] &&
+			pod.Labels[util.ReadEligibleLabel] == podObj.Labels[util.ReadEligibleLabel] {
+			continue
+		}
+		if err := e.k8sService.UpdatePodIfExists(podObj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnsureReplicaOf 备用集群中由一个 Pod 复制外部 master，其余 Pod 复制该 Pod
+// 优先保留已在复制外部 master 的 Pod，其次是原 master，避免重新全量同步
+func (e *Ensure) EnsureReplicaOf(cRedis *v1beta1.CustomRedis) error {
+	host, port := cRedis.Spec.ReplicaOf.Address()
+	source := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	e.logger.V(1).Info("Ensuring the standby replicates from the external master", "source", source)
+
+	pods, err := e.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return util.AllPodReadyErr
+	}
+
+	password := ""
+	if secret := cRedis.Spec.ReplicaOf.PasswordSecret; secret != nil {
+		if password, err = e.k8sService.GetSecretValue(secret, cRedis.Namespace); err != nil {
+			return err
+		}
+	}
+
+	replications := make([]*redis.ReplicationInfo, len(pods))
+	primary := -1
+	for i := range pods {
+		if replications[i], err = e.redisService.GetReplication(cRedis, pods[i].Status.PodIP); err != nil {
+			return err
+		}
+		r := replications[i]
+		if primary < 0 && !r.IsMaster() && r.MasterHost == host && int32(r.MasterPort) == port {
+			primary = i
+		}
+	}
+	replicating := primary >= 0
+	if !replicating {
+		primary = standbyCandidate(cRedis, pods)
+	}
+	primaryPod := &pods[primary]
+
+	// masterauth 仅用于连接外部 master，提升时恢复
+	if err := e.ensureMasterAuth(cRedis, primaryPod, password); err != nil {
+		return err
+	}
+	if !replicating {
+		e.logger.Info("Replicating from external master", "pod", primaryPod.Name, "source", source)
+		if err := e.redisService.ReplicateFrom(cRedis, primaryPod.Status.PodIP, host, port); err != nil {
+			return err
+		}
+	}
+
+	primaryHost := getRedisHost(cRedis, primaryPod)
+	for i := range pods {
+		if i == primary || (!replications[i].IsMaster() && replications[i].MasterHost == primaryHost) {
+			continue
+		}
+		e.logger.Info("Replicating from standby pod", "pod", pods[i].Name, "standbyPod", primaryPod.Name)
+		if err := e.redisService.SetAsSlave(cRedis, pods[i].Status.PodIP, primaryHost); err != nil {
+			return err
+		}
+	}
+
+	if standby := cRedis.Status.Standby; standby == nil || standby.Source != source || standby.Pod != primaryPod.Name {
+		cRedis.Status.Standby = &v1beta1.StandbyStatus{Source: source, Pod: primaryPod.Name}
+	}
+	return nil
+}
+
+// standbyCandidate 返回成为备用 Pod 的下标，依次选择上一次的备用 Pod、原 master、创建时间最早的 Pod
+func standbyCandidate(cRedis *v1beta1.CustomRedis, pods []corev1.Pod) int {
+	for _, name := range []string{standbyPod(cRedis), cRedis.Status.Master} {
+		for i := range pods {
+			if name != "" && pods[i].Name == name {
+				return i
+			}
+		}
+	}
+
+	oldest := 0
+	for i := range pods {
+		if pods[i].CreationTimestamp.Before(&pods[oldest].CreationTimestamp) {
+			oldest = i
+		}
+	}
+	return oldest
+}
+
+func standbyPod(cRedis *v1beta1.CustomRedis) string {
+	if cRedis.Status.Standby == nil {
+		return ""
+	}
+	return cRedis.Status.Standby.Pod
+}
+
+// EnsurePromotion 删除 spec.replicaOf 后，断开备用 Pod 与外部 master 的复制并提升为 master
+// 其余 Pod 已在复制备用 Pod，之后由 CheckNumberOfMasters 与 sentinel 按正常集群维护
+func (e *Ensure) EnsurePromotion(cRedis *v1beta1.CustomRedis) error {
+	if cRedis.IsStandby() || cRedis.Status.Standby == nil {
+		return nil
+	}
+	standby := cRedis.Status.Standby
+	e.logger.V(1).Info("Ensuring the standby is promoted", "standbyPod", standby.Pod)
+
+	pods, err := e.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return err
+	}
+	var pod *corev1.Pod
+	for i := range pods {
+		if pods[i].Name == standby.Pod {
+			pod = &pods[i]
+		}
+	}
+	if pod == nil {
+		return errors.Wrapf(util.AllPodReadyErr, "standby pod %s is not ready", standby.Pod)
+	}
+
+	replication, err := e.redisService.GetReplication(cRedis, pod.Status.PodIP)
+	if err != nil {
+		return err
+	}
+	if !replication.IsMaster() {
+		e.logger.Info("Promoting standby pod to master", "pod", pod.Name, "source", standby.Source, "linkStatus", replication.MasterLinkStatus)
+		if err := e.redisService.SetAsMaster(cRedis, pod.Status.PodIP); err != nil {
+			return err
+		}
+	}
+	// 恢复集群内复制使用的密码，Pod 之后可能作为其他 Pod 的 slave
+	if err := e.ensureMasterAuth(cRedis, pod, localMasterAuth(cRedis)); err != nil {
+		return err
+	}
+
+	cRedis.Status.Standby = nil
+	return nil
+}
+
+func (e *Ensure) ensureMasterAuth(cRedis *v1beta1.CustomRedis, pod *corev1.Pod, password string) error {
+	stored, err := e.redisService.GetConfig(cRedis, pod.Status.PodIP, "masterauth")
+	if err != nil {
+		return err
+	}
+	if stored == password {
+		return nil
+	}
+	return e.redisService.SetConfig(cRedis, pod.Status.PodIP, "masterauth", password)
+}
+
+// localMasterAuth 与渲染配置文件时一致，未设置 masterauth 时使用 requirepass
+func localMasterAuth(cRedis *v1beta1.CustomRedis) string {
+	if masterauth, exists := cRedis.Spec.RedisConfig["masterauth"]; exists {
+		return masterauth
+	}
+	return cRedis.Spec.RedisConfig["requirepass"]
+}
+
+// EnsureSentinelMonitorRemoved sentinel statefulset 尚未创建时跳过，创建后再次移除
+func (e *Ensure) EnsureSentinelMonitorRemoved(cRedis *v1beta1.CustomRedis) error {
+	e.logger.V(1).Info("Ensuring that sentinel monitors no master")
+	sentinelName := fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix)
+	sentinelPods, err := e.k8sService.GetStatefulsetReadyPods(sentinelName, cRedis.Namespace)
+	if err != nil {
+		if apierror.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, sentinelPod := range sentinelPods {
+		sentinelIP := sentinelPod.Status.PodIP
+		_, _, err := e.redisService.GetSentinelMonitor(cRedis, sentinelIP)
+		if errors.Is(err, redis.ErrNoMonitor) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		e.logger.Info("Removing sentinel monitor of the standby", "sentinel", sentinelPod.Name)
+		if err := e.redisService.RemoveSentinelMonitor(cRedis, sentinelIP); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isReadEligible slave 复制链路正常、未在全量同步或加载数据，且落后 master 不超过 maxLag 字节时可以承担读流量
+// masterOffset 为 -1 时表示未找到 master，无法判断延迟
+func isReadEligible(info *redis.Info, masterOffset, maxLag int64) bool {
+	if !info.Replication.IsLinkUp() || info.Persistence.Loading || masterOffset < 0 {
+		return false
+	}
+	return masterOffset-info.Replication.ProcessedOffset() <= maxLag
+}
+
+func (e *Ensure) EnsureLabelsForSentinel(cRedis *v1beta1.CustomRedis) error {
+	e.logger.V(1).Info("Ensuring pod's label for sentinel")
+	name := cRedis.Name
+	namespace := cRedis.Namespace
+	sentinelName := fmt.Sprintf("%s-%s", name, util.SentinelResourceSuffix)
+
+	sentinelPods, err := e.k8sService.GetStatefulsetReadyPods(sentinelName, namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, sentinelPod := range sentinelPods {
+		if sentinelPod.Labels["redis.hongqchen/role"] == "sentinel" {
+			continue
+		}
 		sentinelPodObj := sentinelPod.DeepCopy()
 		sentinelPodObj.ObjectMeta.Labels["redis.hongqchen/role"] = "sentinel"
 
@@ -0,0 +1,863 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnsureSentinelMonitor(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, tc *testCluster)
+		want    int
+		wantErr error
+	}{
+		{
+			name: "placeholder from the initial config",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				tc.monitor(t, util.SentinelPlaceholderIPv4)
+			},
+			want: 0,
+		},
+		{
+			name: "monitor by pod IP is moved to the DNS name",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 1)
+				tc.monitor(t, tc.ip(1))
+			},
+			want: 1,
+		},
+		{
+			name: "after a sentinel failover",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				tc.monitor(t, tc.fqdn(0))
+				_ = tc.redis.Write(tc.ip(0), 10)
+				tc.redis.SetDown(tc.ip(0), true)
+				if _, err := tc.redis.Failover(); err != nil {
+					t.Fatal(err)
+				}
+				// the old master comes back as a slave
+				tc.redis.SetDown(tc.ip(0), false)
+			},
+			want: 1,
+		},
+		{
+			name: "two masters",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.monitor(t, util.SentinelPlaceholderIPv4)
+			},
+			wantErr: util.ManyMastersErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+			tt.setup(t, tc)
+
+			err := tc.ensure().EnsureSentinelMonitor(tc.cRedis)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EnsureSentinelMonitor() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			for _, ip := range tc.sentinelIPs() {
+				sentinel, _ := tc.redis.Sentinel(ip)
+				if sentinel.MonitorHost != tc.fqdn(tt.want) || sentinel.MonitorPort != "6379" || sentinel.Quorum != "2" {
+					t.Errorf("sentinel %s monitors %s:%s quorum %s, want %s:6379 quorum 2",
+						ip, sentinel.MonitorHost, sentinel.MonitorPort, sentinel.Quorum, tc.fqdn(tt.want))
+				}
+			}
+		})
+	}
+}
+
+// TestEnsureSentinelDecisions checks sentinels are not reconfigured against a failover they perform,
+// and that a sentinel already monitoring the master keeps the replicas and sentinels it knows.
+func TestEnsureSentinelDecisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, tc *testCluster)
+		wantErr error
+		// host each sentinel monitors afterwards
+		wantMonitors func(tc *testCluster) []string
+		// stale replicas still known by the first sentinel, they are only forgotten by SENTINEL REMOVE
+		wantStale int
+	}{
+		{
+			name: "failover in progress",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				tc.monitor(t, tc.ip(0))
+				tc.redis.SetFailoverInProgress(tc.sentinelIPs()[1], true)
+			},
+			wantErr: util.MasterBeElectingErr,
+			wantMonitors: func(tc *testCluster) []string {
+				return []string{tc.ip(0), tc.ip(0), tc.ip(0)}
+			},
+		},
+		{
+			name: "sentinels elected a slave the replicas do not follow yet",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				tc.monitor(t, tc.fqdn(1))
+				_ = tc.redis.RemoveSentinelMonitor(tc.sentinelIPs()[0], "")
+				_ = tc.redis.SetSentinelMonitor(tc.sentinelIPs()[0], "", map[string]interface{}{"masterIP": tc.fqdn(0), "port": int32(6379), "quorum": "2"})
+			},
+			wantErr: util.MasterBeElectingErr,
+			wantMonitors: func(tc *testCluster) []string {
+				return []string{tc.fqdn(0), tc.fqdn(1), tc.fqdn(1)}
+			},
+		},
+		{
+			name: "minority monitoring an old master is fixed alone",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				tc.monitor(t, tc.fqdn(0))
+				_ = tc.redis.RemoveSentinelMonitor(tc.sentinelIPs()[2], "")
+				_ = tc.redis.SetSentinelMonitor(tc.sentinelIPs()[2], "", map[string]interface{}{"masterIP": tc.fqdn(1), "port": int32(6379), "quorum": "2"})
+				tc.redis.AddStaleReplica(tc.sentinelIPs()[0], "10.0.0.9")
+			},
+			wantMonitors: func(tc *testCluster) []string {
+				return []string{tc.fqdn(0), tc.fqdn(0), tc.fqdn(0)}
+			},
+			wantStale: 1,
+		},
+		{
+			name: "quorum is changed in place",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.replicate(t, 0)
+				tc.monitor(t, tc.fqdn(0))
+				_ = tc.redis.SetSentinelOption(tc.sentinelIPs()[0], "", "quorum", "1")
+				tc.redis.AddStaleReplica(tc.sentinelIPs()[0], "10.0.0.9")
+			},
+			wantMonitors: func(tc *testCluster) []string {
+				return []string{tc.fqdn(0), tc.fqdn(0), tc.fqdn(0)}
+			},
+			wantStale: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+			tt.setup(t, tc)
+
+			err := tc.ensure().EnsureSentinelMonitor(tc.cRedis)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EnsureSentinelMonitor() error = %v, want %v", err, tt.wantErr)
+			}
+
+			want := tt.wantMonitors(tc)
+			for i, ip := range tc.sentinelIPs() {
+				sentinel, _ := tc.redis.Sentinel(ip)
+				if sentinel.MonitorHost != want[i] {
+					t.Errorf("sentinel %s monitors %s, want %s", ip, sentinel.MonitorHost, want[i])
+				}
+				if tt.wantErr == nil && sentinel.Quorum != "2" {
+					t.Errorf("sentinel %s has quorum %s, want 2", ip, sentinel.Quorum)
+				}
+			}
+			if sentinel, _ := tc.redis.Sentinel(tc.sentinelIPs()[0]); len(sentinel.StaleReplicas) != tt.wantStale {
+				t.Errorf("first sentinel knows %d stale replicas, want %d", len(sentinel.StaleReplicas), tt.wantStale)
+			}
+		})
+	}
+}
+
+func TestEnsureSlaveOfMaster(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+	tc.replicate(t, 0)
+	// replication set up by an older operator version through the pod IP
+	_ = tc.redis.SetAsSlave(tc.ip(2), tc.ip(0), 6379, "")
+
+	if err := tc.ensure().EnsureSlaveOfMaster(tc.cRedis); err != nil {
+		t.Fatalf("EnsureSlaveOfMaster() error = %v", err)
+	}
+	tc.assertTopology(t, 0)
+}
+
+func TestEnsureLegacySentinelRemoved(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+	tc.replicate(t, 0)
+	tc.monitor(t, tc.fqdn(0))
+	tc.redis.AddStalePeer(tc.sentinelIPs()[0], "10.0.2.1")
+	ctx := context.TODO()
+	key := types.NamespacedName{Name: "redis-sentinel", Namespace: "default"}
+	legacy := &appv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	if err := tc.k8sClient.Create(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := tc.ensure().EnsureLegacySentinelRemoved(tc.cRedis); err != nil {
+			t.Fatalf("EnsureLegacySentinelRemoved() error = %v", err)
+		}
+	}
+	if err := tc.k8sClient.Get(ctx, key, &appv1.Deployment{}); !apierror.IsNotFound(err) {
+		t.Errorf("legacy sentinel deployment still exists, err = %v", err)
+	}
+	// stale peers are left to CheckSentinels, which resets one sentinel per reconcile
+	for _, ip := range tc.sentinelIPs() {
+		if sentinel, _ := tc.redis.Sentinel(ip); sentinel.Resets != 0 {
+			t.Errorf("sentinel %s resets = %d, want 0", ip, sentinel.Resets)
+		}
+	}
+}
+
+func TestEnsurePodReadyEmptyMasterRefused(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		restarts int32
+		wantErr  error
+	}{
+		{name: "waiting for the sentinel failover", restarts: 1, wantErr: util.AllPodReadyErr},
+		{name: "sentinel never elects a master", restarts: 3, wantErr: util.EmptyMasterRefusedErr},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+			ctx := context.TODO()
+			pod := &corev1.Pod{}
+			if err := tc.k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "redis-0"}, pod); err != nil {
+				t.Fatal(err)
+			}
+			pod.Status.Conditions = nil
+			pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+				Name:                 util.FindMasterContainerName,
+				RestartCount:         tt.restarts,
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}},
+			}}
+			if err := tc.k8sClient.Status().Update(ctx, pod); err != nil {
+				t.Fatal(err)
+			}
+
+			err := tc.ensure().EnsurePodReadyForStatefulset(tc.cRedis)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EnsurePodReadyForStatefulset() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnsureRollingUpdate(t *testing.T) {
+	closedWindow := []v1beta1.MaintenanceWindow{{
+		Start:    time.Now().UTC().Add(2 * time.Hour).Format("15:04"),
+		Duration: metav1.Duration{Duration: time.Hour},
+	}}
+	tests := []struct {
+		name    string
+		mode    v1beta1.ClusterMode
+		windows []v1beta1.MaintenanceWindow
+		// spec.autoRestart is not set
+		manual bool
+		// pods already running the latest revision
+		updated     []int
+		wantDeleted string
+		wantMaster  int
+		wantErr     error
+	}{
+		{
+			name:        "slaves first, highest ordinal first",
+			mode:        v1beta1.Sentinel,
+			wantDeleted: "redis-2",
+			wantMaster:  0,
+			wantErr:     util.RollingUpdateErr,
+		},
+		{
+			name:       "outdated master fails over before being restarted",
+			mode:       v1beta1.Sentinel,
+			updated:    []int{1, 2},
+			wantMaster: 1,
+			wantErr:    util.RollingUpdateErr,
+		},
+		{
+			name:       "outdated master is left to a human in master-slave mode",
+			mode:       v1beta1.MasterSlave,
+			updated:    []int{1, 2},
+			wantMaster: 0,
+		},
+		{
+			name:       "pods are left to the user without autoRestart",
+			mode:       v1beta1.Sentinel,
+			manual:     true,
+			wantMaster: 0,
+		},
+		{
+			name:       "slaves are not restarted outside maintenance windows",
+			mode:       v1beta1.Sentinel,
+			windows:    closedWindow,
+			wantMaster: 0,
+		},
+		{
+			name:       "master does not fail over outside maintenance windows",
+			mode:       v1beta1.Sentinel,
+			windows:    closedWindow,
+			updated:    []int{1, 2},
+			wantMaster: 0,
+		},
+		{
+			name: "restarted within a maintenance window",
+			mode: v1beta1.Sentinel,
+			windows: []v1beta1.MaintenanceWindow{{
+				Start:    time.Now().UTC().Add(-time.Hour).Format("15:04"),
+				Duration: metav1.Duration{Duration: 2 * time.Hour},
+			}},
+			wantDeleted: "redis-2",
+			wantMaster:  0,
+			wantErr:     util.RollingUpdateErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, tt.mode, util.CustomRedisRunning)
+			tc.cRedis.Spec.MaintenanceWindows = tt.windows
+			tc.cRedis.Spec.AutoRestart = !tt.manual
+			tc.replicate(t, 0)
+			if tt.mode == v1beta1.Sentinel {
+				tc.monitor(t, tc.fqdn(0))
+			}
+
+			ctx := context.TODO()
+			sts := &appv1.StatefulSet{}
+			if err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: tc.cRedis.Name, Namespace: tc.cRedis.Namespace}, sts); err != nil {
+				t.Fatal(err)
+			}
+			sts.Status.UpdateRevision = "redis-2"
+			if err := tc.k8sClient.Status().Update(ctx, sts); err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range tt.updated {
+				pod := &corev1.Pod{}
+				if err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("redis-%d", i), Namespace: tc.cRedis.Namespace}, pod); err != nil {
+					t.Fatal(err)
+				}
+				pod.Labels[appv1.ControllerRevisionHashLabelKey] = "redis-2"
+				if err := tc.k8sClient.Update(ctx, pod); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := tc.ensure().EnsureRollingUpdate(tc.cRedis)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EnsureRollingUpdate() error = %v, want %v", err, tt.wantErr)
+			}
+
+			for i := 0; i < 3; i++ {
+				podName := fmt.Sprintf("redis-%d", i)
+				err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: podName, Namespace: tc.cRedis.Namespace}, &corev1.Pod{})
+				if deleted := apierror.IsNotFound(err); deleted != (podName == tt.wantDeleted) {
+					t.Errorf("pod %s deleted = %v, want %v", podName, deleted, !deleted)
+				}
+			}
+			if masters := tc.redis.Masters(); len(masters) != 1 || masters[0] != tc.ip(tt.wantMaster) {
+				t.Errorf("masters = %v, want [%s]", masters, tc.ip(tt.wantMaster))
+			}
+		})
+	}
+}
+
+func TestEnsureSwitchover(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   v1beta1.ClusterMode
+		target string
+		setup  func(t *testing.T, tc *testCluster)
+		// master expected after the switchover
+		wantMaster int
+		wantPhase  v1beta1.SwitchoverPhase
+	}{
+		{
+			name:       "master-slave",
+			mode:       v1beta1.MasterSlave,
+			target:     "redis-2",
+			setup:      func(t *testing.T, tc *testCluster) {},
+			wantMaster: 2,
+			wantPhase:  v1beta1.SwitchoverSucceeded,
+		},
+		{
+			name:   "sentinel promotes the target only",
+			mode:   v1beta1.Sentinel,
+			target: "redis-2",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.monitor(t, tc.fqdn(0))
+				// redis-1 would win an unconstrained failover
+				_ = tc.redis.SetConfig(tc.ip(1), 6379, "", "replica-priority", "10")
+			},
+			wantMaster: 2,
+			wantPhase:  v1beta1.SwitchoverSucceeded,
+		},
+		{
+			name:       "target is already master",
+			mode:       v1beta1.MasterSlave,
+			target:     "redis-0",
+			setup:      func(t *testing.T, tc *testCluster) {},
+			wantMaster: 0,
+			wantPhase:  v1beta1.SwitchoverSucceeded,
+		},
+		{
+			name:       "unknown target",
+			mode:       v1beta1.MasterSlave,
+			target:     "redis-5",
+			setup:      func(t *testing.T, tc *testCluster) {},
+			wantMaster: 0,
+			wantPhase:  v1beta1.SwitchoverFailed,
+		},
+		{
+			name:   "target not in sync",
+			mode:   v1beta1.Sentinel,
+			target: "redis-1",
+			setup: func(t *testing.T, tc *testCluster) {
+				tc.monitor(t, tc.fqdn(0))
+				tc.redis.SetLoading(tc.ip(1), true)
+			},
+			wantMaster: 0,
+			wantPhase:  v1beta1.SwitchoverFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, tt.mode, util.CustomRedisRunning)
+			tc.replicate(t, 0)
+			_ = tc.redis.Write(tc.ip(0), 100)
+			tt.setup(t, tc)
+			tc.cRedis.Generation = 2
+			tc.cRedis.Spec.Switchover = &v1beta1.SwitchoverSpec{TargetPod: tt.target, RequestID: "1"}
+
+			if err := tc.ensure().EnsureSwitchover(tc.cRedis); err != nil {
+				t.Fatalf("EnsureSwitchover() error = %v", err)
+			}
+
+			result := tc.cRedis.Status.Switchover
+			if result == nil || result.Phase != tt.wantPhase || result.From != "redis-0" || result.ObservedGeneration != 2 {
+				t.Fatalf("status.switchover = %+v, want phase %s from redis-0", result, tt.wantPhase)
+			}
+			if tt.wantPhase == v1beta1.SwitchoverFailed {
+				tc.redis.SetLoading(tc.ip(1), false)
+			}
+			tc.assertTopology(t, tt.wantMaster)
+
+			for i := 0; i < 3; i++ {
+				node, _ := tc.redis.Node(tc.ip(i))
+				if node.Paused {
+					t.Errorf("writes on node %d are still paused", i)
+				}
+			}
+			if tt.mode == v1beta1.Sentinel {
+				for _, ip := range tc.sentinelIPs() {
+					if sentinel, _ := tc.redis.Sentinel(ip); sentinel.MonitorHost != tc.fqdn(tt.wantMaster) {
+						t.Errorf("sentinel %s monitors %s, want %s", ip, sentinel.MonitorHost, tc.fqdn(tt.wantMaster))
+					}
+				}
+				if priority, _ := tc.redis.GetConfig(tc.ip(1), 6379, "", "replica-priority"); tt.target == "redis-2" && priority != "10" {
+					t.Errorf("replica-priority of node 1 = %q, want it restored to 10", priority)
+				}
+			}
+
+			// the switchover runs once per request, unrelated spec changes do not run it again
+			if tc.cRedis.IsSwitchoverRequested() {
+				t.Errorf("switchover still requested after it completed")
+			}
+			tc.cRedis.Generation = 3
+			if tc.cRedis.IsSwitchoverRequested() {
+				t.Errorf("switchover requested again by a new generation")
+			}
+			tc.cRedis.Spec.Switchover.RequestID = "2"
+			if !tc.cRedis.IsSwitchoverRequested() {
+				t.Errorf("switchover not requested by a new request ID")
+			}
+		})
+	}
+}
+
+func TestEnsureSwitchoverInProgress(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+	tc.replicate(t, 0)
+	tc.monitor(t, tc.fqdn(0))
+	// the previous reconcile timed out while sentinel was still failing over to redis-2
+	tc.cRedis.Spec.Switchover = &v1beta1.SwitchoverSpec{TargetPod: "redis-2", RequestID: "1"}
+	tc.cRedis.Status.Switchover = &v1beta1.SwitchoverStatus{
+		TargetPod: "redis-2",
+		RequestID: "1",
+		From:      "redis-0",
+		Phase:     v1beta1.SwitchoverInProgress,
+		// redis-1 was kept out of the election
+		ReplicaPriorities: map[string]string{"redis-1": "100"},
+	}
+	_ = tc.redis.SetConfig(tc.ip(1), 6379, "", "replica-priority", "0")
+	tc.redis.SetFailoverInProgress(tc.sentinelIPs()[0], true)
+
+	e := tc.ensure()
+	if err := e.EnsureSwitchover(tc.cRedis); !errors.Is(err, util.MasterBeElectingErr) {
+		t.Fatalf("EnsureSwitchover() error = %v, want %v", err, util.MasterBeElectingErr)
+	}
+	if result := tc.cRedis.Status.Switchover; result.Phase != v1beta1.SwitchoverInProgress {
+		t.Fatalf("status.switchover = %+v, want InProgress", result)
+	}
+	// no second failover is started while waiting, and redis-1 still can not be elected
+	tc.assertTopology(t, 0)
+	if priority, _ := tc.redis.GetConfig(tc.ip(1), 6379, "", "replica-priority"); priority != "0" {
+		t.Errorf("replica-priority of redis-1 = %s while sentinel is failing over, want 0", priority)
+	}
+
+	// sentinel completes the failover late
+	if _, err := tc.redis.Failover(); err != nil {
+		t.Fatal(err)
+	}
+	tc.redis.SetFailoverInProgress(tc.sentinelIPs()[0], false)
+	if err := e.EnsureSwitchover(tc.cRedis); err != nil {
+		t.Fatalf("EnsureSwitchover() error = %v", err)
+	}
+	if result := tc.cRedis.Status.Switchover; result.Phase != v1beta1.SwitchoverSucceeded || result.From != "redis-0" {
+		t.Errorf("status.switchover = %+v, want Succeeded from redis-0", result)
+	}
+	if tc.cRedis.IsSwitchoverRequested() {
+		t.Errorf("switchover still requested after it completed")
+	}
+	if priority, _ := tc.redis.GetConfig(tc.ip(1), 6379, "", "replica-priority"); priority != "100" || tc.cRedis.Status.Switchover.ReplicaPriorities != nil {
+		t.Errorf("replica-priority of redis-1 = %s, recorded %v, want it restored to 100", priority, tc.cRedis.Status.Switchover.ReplicaPriorities)
+	}
+}
+
+func TestEnsureSwitchoverOldRedis(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+	tc.replicate(t, 0)
+	tc.monitor(t, tc.fqdn(0))
+	tc.redis.SetVersion(tc.ip(0), "6.0.16")
+	tc.cRedis.Spec.Switchover = &v1beta1.SwitchoverSpec{TargetPod: "redis-1", RequestID: "1"}
+
+	if err := tc.ensure().EnsureSwitchover(tc.cRedis); err != nil {
+		t.Fatalf("EnsureSwitchover() error = %v", err)
+	}
+	if result := tc.cRedis.Status.Switchover; result.Phase != v1beta1.SwitchoverFailed || !strings.Contains(result.Message, "6.2") {
+		t.Errorf("status.switchover = %+v, want Failed because writes can not be paused", result)
+	}
+	tc.assertTopology(t, 0)
+}
+
+func TestEnsureExternalServicesRemoved(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+	tc.replicate(t, 0)
+	tc.cRedis.Spec.Service = &v1beta1.ServiceConfig{PerPodType: corev1.ServiceTypeNodePort}
+	e := tc.ensure()
+	external := func() map[string]bool {
+		names := map[string]bool{}
+		for i := 0; i < 3; i++ {
+			name := fmt.Sprintf("redis-%d-external", i)
+			if _, err := e.k8sService.GetService(name, "default"); err == nil {
+				names[name] = true
+			}
+		}
+		return names
+	}
+
+	if err := e.EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	if got := external(); len(got) != 3 {
+		t.Fatalf("external services = %v, want one per pod", got)
+	}
+
+	replicas := int32(2)
+	tc.cRedis.Spec.Replicas = &replicas
+	if err := e.EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	if got := external(); len(got) != 2 || got["redis-2-external"] {
+		t.Fatalf("external services = %v after scaling down, want redis-2-external removed", got)
+	}
+
+	// redis-0 still announces the address of its external service
+	pod := &corev1.Pod{}
+	if err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: "redis-0", Namespace: "default"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	pod.Annotations = map[string]string{util.AnnounceIPAnnotation: "203.0.113.10", util.AnnouncePortAnnotation: "30079"}
+	if err := tc.k8sClient.Update(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	_ = tc.redis.SetConfig(tc.ip(0), 6379, "", "replica-announce-ip", "203.0.113.10")
+	_ = tc.redis.SetConfig(tc.ip(0), 6379, "", "replica-announce-port", "30079")
+
+	tc.cRedis.Spec.Service.PerPodType = ""
+	if err := e.EnsureService(tc.cRedis); err != nil {
+		t.Fatal(err)
+	}
+	if got := external(); len(got) != 0 {
+		t.Fatalf("external services = %v after disabling them, want none", got)
+	}
+	if err := e.EnsureExternalAnnounce(tc.cRedis); err != nil {
+		t.Fatalf("EnsureExternalAnnounce() error = %v", err)
+	}
+	announceIP, _ := tc.redis.GetConfig(tc.ip(0), 6379, "", "replica-announce-ip")
+	announcePort, _ := tc.redis.GetConfig(tc.ip(0), 6379, "", "replica-announce-port")
+	if announceIP != tc.fqdn(0) || announcePort != "0" {
+		t.Errorf("redis-0 announces %s:%s, want its DNS name %s", announceIP, announcePort, tc.fqdn(0))
+	}
+	if err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: "redis-0", Namespace: "default"}, pod); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := pod.Annotations[util.AnnounceIPAnnotation]; exists {
+		t.Errorf("pod annotations = %v, want the announce address removed", pod.Annotations)
+	}
+}
+
+func TestEnsureMaxMemory(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+	tc.replicate(t, 0)
+	tc.cRedis.Spec.Memory = &v1beta1.MemoryPolicy{MaxMemoryPercent: 50, EvictionPolicy: "allkeys-lru"}
+	tc.cRedis.Spec.Templates.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}
+
+	// redis-1 has not been restarted with the new limit yet, redis-2 has no limit
+	limits := map[int]string{0: "1Gi", 1: "512Mi"}
+	ctx := context.TODO()
+	for i := 0; i < 3; i++ {
+		pod := &corev1.Pod{}
+		if err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("redis-%d", i), Namespace: "default"}, pod); err != nil {
+			t.Fatal(err)
+		}
+		container := corev1.Container{Name: "redis"}
+		if limit, ok := limits[i]; ok {
+			container.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(limit)}
+		}
+		pod.Spec.Containers = []corev1.Container{container}
+		if err := tc.k8sClient.Update(ctx, pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if conf := newGenerate().configmap(tc.cRedis).Data[util.RedisConfigFileName]; !strings.Contains(conf, "maxmemory 536870912\n") || !strings.Contains(conf, "maxmemory-policy allkeys-lru\n") {
+		t.Errorf("redis.conf does not set maxmemory from the limit:\n%s", conf)
+	}
+	if _, exists := tc.cRedis.Spec.RedisConfig["maxmemory"]; exists {
+		t.Errorf("rendering the config wrote maxmemory back to the spec")
+	}
+
+	if err := tc.ensure().EnsureMaxMemory(tc.cRedis); err != nil {
+		t.Fatalf("EnsureMaxMemory() error = %v", err)
+	}
+	want := map[int]string{0: "536870912", 1: "268435456", 2: ""}
+	for i, wantMaxMemory := range want {
+		maxmemory, _ := tc.redis.GetConfig(tc.ip(i), 6379, "", "maxmemory")
+		policy, _ := tc.redis.GetConfig(tc.ip(i), 6379, "", "maxmemory-policy")
+		if maxmemory != wantMaxMemory || policy != "allkeys-lru" {
+			t.Errorf("node %d maxmemory = %q, policy = %q, want %q and allkeys-lru", i, maxmemory, policy, wantMaxMemory)
+		}
+	}
+
+	// maxmemory set in redisConfig takes precedence
+	tc.cRedis.Spec.RedisConfig["maxmemory"] = "100mb"
+	_ = tc.redis.SetConfig(tc.ip(0), 6379, "", "maxmemory", "104857600")
+	if err := tc.ensure().EnsureMaxMemory(tc.cRedis); err != nil {
+		t.Fatalf("EnsureMaxMemory() error = %v", err)
+	}
+	if maxmemory, _ := tc.redis.GetConfig(tc.ip(0), 6379, "", "maxmemory"); maxmemory != "104857600" {
+		t.Errorf("node 0 maxmemory = %q, want the explicit 104857600", maxmemory)
+	}
+}
+
+func TestEnsureLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxLag *int64
+		setup  func(tc *testCluster)
+		// read-eligible label of redis-0 (the master), redis-1 and redis-2
+		want [3]string
+	}{
+		{
+			name:  "caught-up slaves serve reads",
+			setup: func(tc *testCluster) {},
+			want:  [3]string{"false", "true", "true"},
+		},
+		{
+			name: "lagging slave is left out",
+			setup: func(tc *testCluster) {
+				tc.redis.SetLag(tc.ip(1), 2*1024*1024)
+			},
+			want: [3]string{"false", "false", "true"},
+		},
+		{
+			name:   "lag within the configured limit",
+			maxLag: func() *int64 { lag := int64(4 * 1024 * 1024); return &lag }(),
+			setup: func(tc *testCluster) {
+				tc.redis.SetLag(tc.ip(1), 2*1024*1024)
+			},
+			want: [3]string{"false", "true", "true"},
+		},
+		{
+			name: "slave loading its dataset is left out",
+			setup: func(tc *testCluster) {
+				tc.redis.SetLoading(tc.ip(2), true)
+			},
+			want: [3]string{"false", "true", "false"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+			tc.replicate(t, 0)
+			if err := tc.redis.Write(tc.ip(0), 8*1024*1024); err != nil {
+				t.Fatal(err)
+			}
+			tc.cRedis.Spec.ReplicaReads = &v1beta1.ReplicaReadsConfig{MaxLagBytes: tt.maxLag}
+			tt.setup(tc)
+
+			if err := tc.ensure().EnsureLabels(tc.cRedis); err != nil {
+				t.Fatalf("EnsureLabels() error = %v", err)
+			}
+			for i, want := range tt.want {
+				pod := &corev1.Pod{}
+				if err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: fmt.Sprintf("redis-%d", i), Namespace: "default"}, pod); err != nil {
+					t.Fatal(err)
+				}
+				if got := pod.Labels[util.ReadEligibleLabel]; got != want {
+					t.Errorf("pod redis-%d %s = %q, want %q", i, util.ReadEligibleLabel, got, want)
+				}
+			}
+		})
+	}
+
+	selector := newGenerate().service(newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning).cRedis)["redis-slave"].Spec.Selector
+	if selector[util.ReadEligibleLabel] != "true" || selector["redis.hongqchen/role"] != "slave" {
+		t.Errorf("slave service selector = %v, want read-eligible slaves", selector)
+	}
+}
+
+func TestEnsureLabelsUnchanged(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+	tc.replicate(t, 0)
+	e := tc.ensure()
+	if err := e.EnsureLabels(tc.cRedis); err != nil {
+		t.Fatalf("EnsureLabels() error = %v", err)
+	}
+
+	versions := make(map[string]string)
+	pods := &corev1.PodList{}
+	if err := tc.k8sClient.List(context.TODO(), pods); err != nil {
+		t.Fatal(err)
+	}
+	for _, pod := range pods.Items {
+		versions[pod.Name] = pod.ResourceVersion
+	}
+
+	if err := e.EnsureLabels(tc.cRedis); err != nil {
+		t.Fatalf("EnsureLabels() error = %v", err)
+	}
+	if err := tc.k8sClient.List(context.TODO(), pods); err != nil {
+		t.Fatal(err)
+	}
+	for _, pod := range pods.Items {
+		if pod.ResourceVersion != versions[pod.Name] {
+			t.Errorf("pod %s was updated although its labels did not change", pod.Name)
+		}
+	}
+}
+
+func TestEnsureReplicaOf(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+	tc.replicate(t, 1)
+	tc.monitor(t, tc.fqdn(1))
+	tc.cRedis.Status.Master = "redis-1"
+
+	external := "192.168.0.10"
+	tc.redis.AddNode(external, "redis.example.com")
+	if err := tc.redis.Write(external, 100); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "source", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	if err := tc.k8sClient.Create(context.TODO(), secret); err != nil {
+		t.Fatal(err)
+	}
+	tc.cRedis.Spec.ReplicaOf = &v1beta1.ReplicaOfSpec{
+		Host: "redis.example.com",
+		Port: 6380,
+		PasswordSecret: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "source"},
+			Key:                  "password",
+		},
+	}
+
+	e := tc.ensure()
+	if err := e.EnsureSentinelMonitorRemoved(tc.cRedis); err != nil {
+		t.Fatalf("EnsureSentinelMonitorRemoved() error = %v", err)
+	}
+	for _, ip := range tc.sentinelIPs() {
+		if sentinel, _ := tc.redis.Sentinel(ip); sentinel.MonitorHost != "" {
+			t.Errorf("sentinel %s monitors %s, want no master", ip, sentinel.MonitorHost)
+		}
+	}
+
+	// the old master keeps its data and becomes the standby pod
+	if err := e.EnsureReplicaOf(tc.cRedis); err != nil {
+		t.Fatalf("EnsureReplicaOf() error = %v", err)
+	}
+	if masters := tc.redis.Masters(); len(masters) != 1 || masters[0] != external {
+		t.Fatalf("masters = %v, want only the external master", masters)
+	}
+	standby, _ := tc.redis.Node(tc.ip(1))
+	if standby.MasterHost != "redis.example.com" || standby.MasterPort != 6380 || standby.Config["masterauth"] != "secret" {
+		t.Errorf("standby replicates from %s:%d with masterauth %q", standby.MasterHost, standby.MasterPort, standby.Config["masterauth"])
+	}
+	for _, i := range []int{0, 2} {
+		if node, _ := tc.redis.Node(tc.ip(i)); node.MasterHost != tc.fqdn(1) || node.Offset != 100 {
+			t.Errorf("node %d replicates from %q at offset %d, want the standby pod at 100", i, node.MasterHost, node.Offset)
+		}
+	}
+	if status := tc.cRedis.Status.Standby; status == nil || status.Pod != "redis-1" || status.Source != "redis.example.com:6380" {
+		t.Errorf("status.standby = %+v, want redis-1 replicating from redis.example.com:6380", status)
+	}
+
+	// switchover has no local master to hand over from
+	tc.cRedis.Spec.Switchover = &v1beta1.SwitchoverSpec{TargetPod: "redis-0"}
+	if err := e.EnsureSwitchover(tc.cRedis); err != nil {
+		t.Fatalf("EnsureSwitchover() error = %v", err)
+	}
+	if result := tc.cRedis.Status.Switchover; result == nil || result.Phase != v1beta1.SwitchoverFailed {
+		t.Errorf("status.switchover = %+v, want Failed", result)
+	}
+	tc.cRedis.Spec.Switchover = nil
+
+	// removing spec.replicaOf promotes the standby pod
+	tc.cRedis.Spec.ReplicaOf = nil
+	if err := e.EnsurePromotion(tc.cRedis); err != nil {
+		t.Fatalf("EnsurePromotion() error = %v", err)
+	}
+	if tc.cRedis.Status.Standby != nil {
+		t.Errorf("status.standby = %+v, want it cleared after the promotion", tc.cRedis.Status.Standby)
+	}
+	// the external master is still running but no longer part of the topology
+	tc.redis.RemoveNode(external)
+	tc.assertTopology(t, 1)
+	if node, _ := tc.redis.Node(tc.ip(1)); node.Config["masterauth"] != "" {
+		t.Errorf("masterauth = %q after the promotion, want the local password", node.Config["masterauth"])
+	}
+	if err := e.EnsureSentinelMonitor(tc.cRedis); err != nil {
+		t.Fatalf("EnsureSentinelMonitor() error = %v", err)
+	}
+	for _, ip := range tc.sentinelIPs() {
+		if sentinel, _ := tc.redis.Sentinel(ip); sentinel.MonitorHost != tc.fqdn(1) {
+			t.Errorf("sentinel %s monitors %q, want %q", ip, sentinel.MonitorHost, tc.fqdn(1))
+		}
+	}
+}
@@ -9,15 +9,20 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
 )
 
+// 存放从模块镜像中拷贝的 redis 模块的 emptyDir
+const modulesVolumeName = "volume-redis-modules"
+
 var _ generater = (*generate)(nil)
 
 type generater interface {
 	createLabels(cRedis *v1beta1.CustomRedis) map[string]string
+	createSentinelLabels(cRedis *v1beta1.CustomRedis) map[string]string
 	createOwnerReference(cRedis *v1beta1.CustomRedis) []metav1.OwnerReference
 
 	// master-slave
@@ -26,7 +31,7 @@ type generater interface {
 	service(cRedis *v1beta1.CustomRedis) map[string]*corev1.Service
 
 	// sentinel
-	deployment(cRedis *v1beta1.CustomRedis) *appv1.Deployment
+	statefulsetForSentinel(cRedis *v1beta1.CustomRedis) *appv1.StatefulSet
 	configmapForSentinel(cRedis *v1beta1.CustomRedis) *corev1.ConfigMap
 }
 
@@ -60,8 +65,19 @@ func (g *generate) createLabels(cRedis *v1beta1.CustomRedis) map[string]string {
 	return labels
 }
 
+// sentinel 节点与 redis 节点通过 component 区分，避免 selector 互相覆盖
+func (g *generate) createSentinelLabels(cRedis *v1beta1.CustomRedis) map[string]string {
+	labels := g.createLabels(cRedis)
+	labels["redis.hongqchen/component"] = util.SentinelResourceSuffix
+	return labels
+}
+
 func (g *generate) configmap(cRedis *v1beta1.CustomRedis) *corev1.ConfigMap {
-	cm := cRedis.Spec.RedisConfig
+	// 拷贝一份，以下补充的配置不写回 spec，以免被视为用户显式设置
+	cm := make(map[string]string, len(cRedis.Spec.RedisConfig)+2)
+	for k, v := range cRedis.Spec.RedisConfig {
+		cm[k] = v
+	}
 
 	// 保证 requirepass 和 masterauth 成对出现
 	if _, exists := cm["requirepass"]; exists {
@@ -70,6 +86,25 @@ func (g *generate) configmap(cRedis *v1beta1.CustomRedis) *corev1.ConfigMap {
 		}
 	}
 
+	// 配置了 AOF 独立数据卷时默认开启 AOF
+	if _, exists := cm["appendonly"]; !exists && cRedis.AofVolumeClaim() != nil {
+		cm["appendonly"] = "yes"
+	}
+
+	// 开启内存策略时，根据 redis 容器的内存 limit 计算 maxmemory
+	if maxmemory, ok := cRedis.MaxMemory(cRedis.Spec.Templates.Resources.Limits); ok {
+		cm["maxmemory"] = strconv.FormatInt(maxmemory, 10)
+	}
+	if policy := cRedis.EvictionPolicy(); policy != "" {
+		cm["maxmemory-policy"] = policy
+	}
+
+	// IPv6 或双栈集群未指定 bind 时，同时监听 IPv4 与 IPv6
+	// "-" 前缀表示该地址不可用时忽略，兼容 IPv6 单栈 Pod
+	if _, exists := cm["bind"]; !exists && cRedis.IsIPv6Enabled() {
+		cm["bind"] = "* -::*"
+	}
+
 	// 将 yaml 格式转为 string
 	var buffer bytes.Buffer
 
@@ -88,6 +123,13 @@ func (g *generate) configmap(cRedis *v1beta1.CustomRedis) *corev1.ConfigMap {
 		buffer.WriteString("\n")
 	}
 
+	// loadmodule 可以出现多次，不能通过 map 渲染
+	for _, module := range cRedis.Spec.Modules {
+		directive := append([]string{"loadmodule", modulePath(module)}, module.Args...)
+		buffer.WriteString(strings.Join(directive, " "))
+		buffer.WriteString("\n")
+	}
+
 	return &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            g.getName(cRedis),
@@ -104,20 +146,31 @@ func (g *generate) configmap(cRedis *v1beta1.CustomRedis) *corev1.ConfigMap {
 func (g *generate) configmapForSentinel(cRedis *v1beta1.CustomRedis) *corev1.ConfigMap {
 	configmapName := fmt.Sprintf("%s-%s", g.getName(cRedis), util.SentinelResourceSuffix)
 	redisPort := cRedis.Spec.RedisConfig["port"]
-	masterIP := "127.0.0.1"
+	masterIP := util.SentinelPlaceholderIPv4
+	if cRedis.IsIPv6Preferred() {
+		masterIP = util.SentinelPlaceholderIPv6
+	}
 
+	// 通过 headless service 的 DNS 名称相互发现，Pod 重建后身份不变
+	// resolve-hostnames 需要出现在 monitor 之前，其余 mymaster 配置需要出现在 monitor 之后
 	sentinelConf := []string{
+		"sentinel resolve-hostnames yes",
+		"sentinel announce-hostnames yes",
+		fmt.Sprintf("sentinel monitor mymaster %s %s %d", masterIP, redisPort, sentinelQuorum(cRedis)),
 		"sentinel down-after-milliseconds mymaster 30000",
 		"sentinel failover-timeout mymaster 180000",
 		"sentinel parallel-syncs mymaster 1",
 	}
-	sentinelConf = append(sentinelConf, fmt.Sprintf("sentinel monitor mymaster %s %s 2", masterIP, redisPort))
 
 	authPass, exists := cRedis.Spec.RedisConfig["requirepass"]
 	if exists {
 		sentinelConf = append(sentinelConf, fmt.Sprintf("sentinel auth-pass mymaster %s", authPass))
 	}
 
+	if cRedis.IsIPv6Enabled() {
+		sentinelConf = append(sentinelConf, "bind * -::*")
+	}
+
 	return &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            configmapName,
@@ -133,9 +186,10 @@ func (g *generate) configmapForSentinel(cRedis *v1beta1.CustomRedis) *corev1.Con
 
 func (g *generate) statefulset(cRedis *v1beta1.CustomRedis) *appv1.StatefulSet {
 	directory := cRedis.Spec.RedisConfig["dir"]
-	pvcNamePrefix := "pvc"
 	redisInstancePort, _ := strconv.ParseInt(cRedis.Spec.RedisConfig["port"], 10, 32)
 
+	headlessName := fmt.Sprintf("%s-%s", g.getName(cRedis), util.HeadlessServiceSuffix)
+
 	labels := g.createLabels(cRedis)
 	delete(labels, "redis.hongqchen/role")
 	labels["redis.hongqchen/owner-type"] = "statefulset"
@@ -156,6 +210,13 @@ func (g *generate) statefulset(cRedis *v1beta1.CustomRedis) *appv1.StatefulSet {
 				},
 			},
 		},
+		// redis.conf rendered for current pod
+		{
+			Name: "volume-redisconf-writable",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
 		// mount host's timezone to pod
 		{
 			Name: "volume-local-time",
@@ -168,21 +229,21 @@ func (g *generate) statefulset(cRedis *v1beta1.CustomRedis) *appv1.StatefulSet {
 	}
 
 	var pvcVolumes []corev1.PersistentVolumeClaim
-	if cRedis.Spec.VolumeConfig != nil {
+	if dataClaim := cRedis.DataVolumeClaim(); dataClaim != nil {
 		pvcVolumes = append(pvcVolumes, corev1.PersistentVolumeClaim{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      pvcNamePrefix,
 				Namespace: cRedis.Namespace,
 			},
-			Spec: *cRedis.Spec.VolumeConfig,
+			Spec: *dataClaim,
 		})
 	}
 
 	volumesMount := []corev1.VolumeMount{
 		{
-			Name:      "volume-local-redisconf",
+			Name:      "volume-redisconf-writable",
 			ReadOnly:  false,
-			MountPath: util.RedisConfigMountPath,
+			MountPath: util.RedisConfigWritablePath,
 		},
 		{
 			Name:      "volume-local-time",
@@ -215,13 +276,115 @@ func (g *generate) statefulset(cRedis *v1beta1.CustomRedis) *appv1.StatefulSet {
 		})
 	}
 
+	// AOF 使用独立的数据卷，挂载到数据目录下的 appenddirname 目录
+	if aofClaim := cRedis.AofVolumeClaim(); aofClaim != nil {
+		pvcVolumes = append(pvcVolumes, corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      aofVolumeName,
+				Namespace: cRedis.Namespace,
+			},
+			Spec: *aofClaim,
+		})
+		volumesMount = append(volumesMount, corev1.VolumeMount{
+			Name:      aofVolumeName,
+			MountPath: aofDirectory(cRedis),
+		})
+	}
+
+	redisConfigPath := fmt.Sprintf("%s/%s", util.RedisConfigWritablePath, util.RedisConfigFileName)
+	announceHost := util.GetPodFQDN("${POD_NAME}", headlessName, g.getNamespace(cRedis))
+
+	// 以 Pod 的稳定 DNS 名称作为 replica-announce-ip，Pod 重建后 IP 变化不影响主从拓扑
+	prepareScript := fmt.Sprintf(
+		"cp %[1]s/%[2]s %[3]s && printf '\\nreplica-announce-ip %%s\\n' \"%[4]s\" >> %[3]s",
+		util.RedisConfigMountPath, util.RedisConfigFileName, redisConfigPath, announceHost,
+	)
+
+	initcontainers := []corev1.Container{
+		{
+			Name:    "prepare-redis-config",
+			Image:   cRedis.Spec.Templates.InitImage,
+			Command: []string{"sh", "-c", prepareScript},
+			Env: []corev1.EnvVar{
+				{
+					Name: "POD_NAME",
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+					},
+				},
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      "volume-local-redisconf",
+					ReadOnly:  true,
+					MountPath: util.RedisConfigMountPath,
+				},
+				{
+					Name:      "volume-redisconf-writable",
+					MountPath: util.RedisConfigWritablePath,
+				},
+			},
+		},
+	}
+
+	// 数据目录为 emptyDir 时，重启的 Pod 先找到当前的 master 并以其 slave 的身份启动
+	if cRedis.DataVolumeClaim() == nil && cRedis.AofVolumeClaim() == nil {
+		initcontainers = append(initcontainers, corev1.Container{
+			Name:            util.FindMasterContainerName,
+			Image:           cRedis.Spec.Templates.Image,
+			Command:         []string{"sh", "-c", g.findMasterScript(cRedis, redisConfigPath, announceHost, headlessName)},
+			ImagePullPolicy: cRedis.Spec.Templates.ImagePullPolicy,
+			Env:             initcontainers[0].Env,
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      "volume-redisconf-writable",
+					MountPath: util.RedisConfigWritablePath,
+				},
+			},
+		})
+	}
+
+	// 指定了镜像的模块由 init container 拷贝到共享的 emptyDir 中，redis 容器从该目录加载
+	modulesMounted := false
+	for _, module := range cRedis.Spec.Modules {
+		if module.Image == "" {
+			continue
+		}
+		if !modulesMounted {
+			modulesMounted = true
+			volumes = append(volumes, corev1.Volume{
+				Name: modulesVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			})
+			volumesMount = append(volumesMount, corev1.VolumeMount{
+				Name:      modulesVolumeName,
+				ReadOnly:  true,
+				MountPath: util.RedisModulesPath,
+			})
+		}
+		initcontainers = append(initcontainers, corev1.Container{
+			Name:            fmt.Sprintf("module-%s", strings.ToLower(strings.ReplaceAll(module.Name, "_", "-"))),
+			Image:           module.Image,
+			Command:         []string{"cp", module.Path, modulePath(module)},
+			ImagePullPolicy: cRedis.Spec.Templates.ImagePullPolicy,
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      modulesVolumeName,
+					MountPath: util.RedisModulesPath,
+				},
+			},
+		})
+	}
+
 	// container info
 	containers := []corev1.Container{
 		{
 			Name:    cRedis.Name,
 			Image:   cRedis.Spec.Templates.Image,
 			Command: []string{"redis-server"},
-			Args:    []string{fmt.Sprintf("%s/%s", util.RedisConfigMountPath, util.RedisConfigFileName)},
+			Args:    []string{redisConfigPath},
 			Ports: []corev1.ContainerPort{
 				{
 					Name:          "redis-port",
@@ -242,20 +405,13 @@ func (g *generate) statefulset(cRedis *v1beta1.CustomRedis) *appv1.StatefulSet {
 			Labels:          labels,
 		},
 		Spec: appv1.StatefulSetSpec{
-			Replicas: cRedis.Spec.Replicas,
+			Replicas:    cRedis.Spec.Replicas,
+			ServiceName: headlessName,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
 			VolumeClaimTemplates: pvcVolumes,
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
-				},
-				Spec: corev1.PodSpec{
-					Containers: containers,
-					Volumes:    volumes,
-				},
-			},
+			Template:             g.podTemplate(cRedis, labels, initcontainers, containers, volumes),
 			UpdateStrategy: appv1.StatefulSetUpdateStrategy{
 				Type: appv1.OnDeleteStatefulSetStrategyType,
 			},
@@ -263,6 +419,93 @@ func (g *generate) statefulset(cRedis *v1beta1.CustomRedis) *appv1.StatefulSet {
 	}
 }
 
+// findMasterScript 没有持久化数据的 Pod 若以 master 身份重启，slave 会立即从其全量同步空数据
+// 启动前查询当前的 master（sentinel 模式询问 sentinel，master-slave 模式访问 master service），存在时追加 replicaof。
+// sentinel 模式下 sentinel 仍认为本 Pod 是 master 时，等待 sentinel 自行完成故障转移（不主动发起，不受暂停与维护窗口影响）；
+// 仍找不到 master 且有 slave 复制本 Pod 时拒绝启动，由 EnsurePodReadyForStatefulset 报告需要人工处理
+func (g *generate) findMasterScript(cRedis *v1beta1.CustomRedis, configPath, announceHost, headlessName string) string {
+	namespace := g.getNamespace(cRedis)
+	redisPort := cRedis.Spec.RedisConfig["port"]
+
+	script := []string{
+		fmt.Sprintf("conf=%s; self=\"%s\"; port=%s", configPath, announceHost, redisPort),
+		"pass=$(sed -n 's/^requirepass //p' \"$conf\")",
+		`rcli() { if [ -n "$pass" ]; then redis-cli --no-auth-warning -a "$pass" "$@"; else redis-cli "$@"; fi; }`,
+		`is_master() { [ -n "$1" ] && [ "$(rcli -h "$1" -p "$2" --raw role 2>/dev/null | head -n 1)" = master ]; }`,
+	}
+	if cRedis.Spec.ClusterMode != v1beta1.Sentinel {
+		script = append(script,
+			fmt.Sprintf("master=%s-master.%s.svc; mport=$port", g.getName(cRedis), namespace),
+			`if is_master "$master" "$mport"; then printf '\nreplicaof %s %s\n' "$master" "$mport" >> "$conf"; fi`,
+		)
+		return strings.Join(script, "\n")
+	}
+
+	sentinel := fmt.Sprintf("redis-cli -h %s-%s.%s.svc -p %d", g.getName(cRedis), util.SentinelResourceSuffix, namespace, util.SentinelPort)
+	script = append(script,
+		fmt.Sprintf("sentinel_master() { set -- $(%s --raw sentinel get-master-addr-by-name mymaster 2>/dev/null); master=$1; mport=$2; }", sentinel),
+		// sentinel 尚未被 operator 配置时监听的是占位地址
+		fmt.Sprintf(`placeholder() { case "$master" in %s|%s) master="" ;; esac; }`, util.SentinelPlaceholderIPv4, util.SentinelPlaceholderIPv6),
+		"sentinel_master; placeholder",
+		`i=0; while [ -n "$master" ] && ! is_master "$master" "$mport" && [ $i -lt 30 ]; do sleep 2; i=$((i+1)); sentinel_master; placeholder; done`,
+		`if is_master "$master" "$mport"; then printf '\nreplicaof %s %s\n' "$master" "$mport" >> "$conf"; exit 0; fi`,
+		fmt.Sprintf("for ip in $(getent ahosts %s.%s.svc | awk '{print $1}' | sort -u); do", headlessName, namespace),
+		`  if [ "$(rcli -h "$ip" -p "$port" --raw role 2>/dev/null | sed -n 2p)" = "$self" ]; then`,
+		`    echo "$ip still replicates from $self, refusing to start as an empty master"; exit 1`,
+		"  fi",
+		"done",
+	)
+	return strings.Join(script, "\n")
+}
+
+// podTemplate 将 spec.templates 中的 Pod 配置合并到 operator 生成的容器与数据卷中
+// containers 与 initcontainers 为 operator 创建的容器，额外的容器追加在其后，保留自身的配置
+func (g *generate) podTemplate(cRedis *v1beta1.CustomRedis, labels map[string]string, initcontainers, containers []corev1.Container, volumes []corev1.Volume) corev1.PodTemplateSpec {
+	podConfig := cRedis.Spec.Templates
+
+	// selector 使用的 label 不能被覆盖
+	podLabels := make(map[string]string, len(podConfig.PodLabels)+len(labels))
+	for k, v := range podConfig.PodLabels {
+		podLabels[k] = v
+	}
+	for k, v := range labels {
+		podLabels[k] = v
+	}
+
+	for i := range initcontainers {
+		initcontainers[i].SecurityContext = podConfig.SecurityContext
+	}
+	for i := range containers {
+		containers[i].Env = append(containers[i].Env, podConfig.Env...)
+		containers[i].EnvFrom = append(containers[i].EnvFrom, podConfig.EnvFrom...)
+		containers[i].VolumeMounts = append(containers[i].VolumeMounts, podConfig.ExtraVolumeMounts...)
+		containers[i].SecurityContext = podConfig.SecurityContext
+	}
+
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      podLabels,
+			Annotations: podConfig.PodAnnotations,
+		},
+		Spec: corev1.PodSpec{
+			InitContainers:     append(initcontainers, podConfig.ExtraInitContainers...),
+			Containers:         append(containers, podConfig.ExtraContainers...),
+			Volumes:            append(volumes, podConfig.ExtraVolumes...),
+			SecurityContext:    podConfig.PodSecurityContext,
+			ServiceAccountName: podConfig.ServiceAccountName,
+			ImagePullSecrets:   podConfig.ImagePullSecrets,
+		},
+	}
+}
+
+// modulePath 返回 redis 加载模块时使用的路径，镜像中的模块被拷贝到 util.RedisModulesPath 下
+func modulePath(module v1beta1.ModuleConfig) string {
+	if module.Image == "" {
+		return module.Path
+	}
+	return path.Join(util.RedisModulesPath, path.Base(module.Path))
+}
+
 // service 配置渲染
 // 不论何种模式，master、slave 均需创建 service
 // sentinel，新增 sentinel 集群 service 创建
@@ -272,7 +515,7 @@ func (g *generate) service(cRedis *v1beta1.CustomRedis) map[string]*corev1.Servi
 	//sentinelName := fmt.Sprintf("%s-%s", name, util.SentinelResourceSuffix)
 	redisPort, _ := strconv.ParseInt(cRedis.Spec.RedisConfig["port"], 10, 32)
 	labels := g.createLabels(cRedis)
-	services := make(map[string]*corev1.Service, 3)
+	services := make(map[string]*corev1.Service, 5)
 
 	// master
 	// 拷贝 labels，添加 master role 键值对
@@ -300,20 +543,27 @@ func (g *generate) service(cRedis *v1beta1.CustomRedis) map[string]*corev1.Servi
 			Selector: masterSelector,
 		},
 	}
+	g.applyServiceConfig(cRedis, masterService)
 	services[fmt.Sprintf("%s-%s", name, "master")] = masterService
 
 	// slave
-	slaveSelector := make(map[string]string, len(labels)+1)
+	slaveSelector := make(map[string]string, len(labels)+2)
 	for k, v := range labels {
 		slaveSelector[k] = v
 	}
 	slaveSelector["redis.hongqchen/role"] = "slave"
+	slaveLabels := make(map[string]string, len(slaveSelector))
+	for k, v := range slaveSelector {
+		slaveLabels[k] = v
+	}
+	// 仅将读流量转发到已追上 master 的 slave
+	slaveSelector[util.ReadEligibleLabel] = "true"
 
 	slaveService := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            fmt.Sprintf("%s-%s", name, "slave"),
 			Namespace:       namespace,
-			Labels:          slaveSelector,
+			Labels:          slaveLabels,
 			OwnerReferences: g.createOwnerReference(cRedis),
 		},
 		Spec: corev1.ServiceSpec{
@@ -327,12 +577,38 @@ func (g *generate) service(cRedis *v1beta1.CustomRedis) map[string]*corev1.Servi
 			Selector: slaveSelector,
 		},
 	}
+	g.applyServiceConfig(cRedis, slaveService)
 	services[fmt.Sprintf("%s-%s", name, "slave")] = slaveService
 
+	// headless，为 statefulset 中每个 redis Pod 提供稳定的 DNS 名称，主从复制基于该名称建立
+	headlessName := fmt.Sprintf("%s-%s", name, util.HeadlessServiceSuffix)
+	headlessService := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            headlessName,
+			Namespace:       namespace,
+			Labels:          labels,
+			OwnerReferences: g.createOwnerReference(cRedis),
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports: []corev1.ServicePort{
+				{
+					Name:     "redis-port",
+					Port:     int32(redisPort),
+					Protocol: corev1.ProtocolTCP,
+				},
+			},
+			Selector:                 labels,
+			PublishNotReadyAddresses: true,
+		},
+	}
+	services[headlessName] = headlessService
+
 	// sentinel
 	if cRedis.Spec.ClusterMode == v1beta1.Sentinel {
-		sentinelSelector := make(map[string]string, len(labels)+1)
-		for k, v := range labels {
+		sentinelLabels := g.createSentinelLabels(cRedis)
+		sentinelSelector := make(map[string]string, len(sentinelLabels)+1)
+		for k, v := range sentinelLabels {
 			sentinelSelector[k] = v
 		}
 		sentinelSelector["redis.hongqchen/role"] = "sentinel"
@@ -356,20 +632,106 @@ func (g *generate) service(cRedis *v1beta1.CustomRedis) map[string]*corev1.Servi
 			},
 		}
 
+		g.applyServiceConfig(cRedis, sentinelService)
 		services[fmt.Sprintf("%s-%s", name, "sentinel")] = sentinelService
+
+		// sentinel statefulset 使用的 headless service，为每个 sentinel 提供稳定的 DNS 名称
+		// 不依赖 role label，并发布未就绪的地址，保证 sentinel 启动阶段即可相互解析
+		sentinelHeadlessName := fmt.Sprintf("%s-%s-%s", name, util.SentinelResourceSuffix, util.HeadlessServiceSuffix)
+		sentinelHeadlessService := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            sentinelHeadlessName,
+				Namespace:       namespace,
+				Labels:          sentinelLabels,
+				OwnerReferences: g.createOwnerReference(cRedis),
+			},
+			Spec: corev1.ServiceSpec{
+				ClusterIP: corev1.ClusterIPNone,
+				Ports: []corev1.ServicePort{
+					{
+						Name:     "redis-port",
+						Port:     util.SentinelPort,
+						Protocol: corev1.ProtocolTCP,
+					},
+				},
+				Selector:                 sentinelLabels,
+				PublishNotReadyAddresses: true,
+			},
+		}
+		services[sentinelHeadlessName] = sentinelHeadlessService
+	}
+
+	// 每个 Pod 一个对外访问的 service，Pod 名称由 statefulset 决定
+	if cRedis.IsPerPodServiceEnabled() {
+		for i := int32(0); i < *cRedis.Spec.Replicas; i++ {
+			svc := g.perPodService(cRedis, fmt.Sprintf("%s-%d", name, i), int32(redisPort))
+			services[svc.Name] = svc
+		}
+
+		if cRedis.Spec.ClusterMode == v1beta1.Sentinel {
+			for i := int32(0); i < *cRedis.Spec.SentinelNum; i++ {
+				svc := g.perPodService(cRedis, fmt.Sprintf("%s-%s-%d", name, util.SentinelResourceSuffix, i), util.SentinelPort)
+				services[svc.Name] = svc
+			}
+		}
+	}
+
+	// IPv6 及双栈集群
+	for _, svc := range services {
+		svc.Spec.IPFamilyPolicy = cRedis.Spec.IPFamilyPolicy
+		svc.Spec.IPFamilies = cRedis.Spec.IPFamilies
 	}
 
 	return services
 }
 
-func (g *generate) deployment(cRedis *v1beta1.CustomRedis) *appv1.Deployment {
+// 设置 master、slave、sentinel service 的类型及注解
+func (g *generate) applyServiceConfig(cRedis *v1beta1.CustomRedis, svc *corev1.Service) {
+	if cRedis.Spec.Service == nil {
+		return
+	}
+
+	svc.Spec.Type = cRedis.Spec.Service.Type
+	svc.ObjectMeta.Annotations = cRedis.Spec.Service.Annotations
+}
+
+// perPodService 通过 statefulset 为 Pod 添加的 pod-name label 选中单个 Pod
+func (g *generate) perPodService(cRedis *v1beta1.CustomRedis, podName string, port int32) *corev1.Service {
+	labels := g.createLabels(cRedis)
+	selector := map[string]string{
+		appv1.StatefulSetPodNameLabel: podName,
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            fmt.Sprintf("%s-%s", podName, util.ExternalServiceSuffix),
+			Namespace:       cRedis.Namespace,
+			Labels:          labels,
+			Annotations:     cRedis.Spec.Service.Annotations,
+			OwnerReferences: g.createOwnerReference(cRedis),
+		},
+		Spec: corev1.ServiceSpec{
+			Type: cRedis.Spec.Service.PerPodType,
+			Ports: []corev1.ServicePort{
+				{
+					Name:     "redis-port",
+					Port:     port,
+					Protocol: corev1.ProtocolTCP,
+				},
+			},
+			Selector: selector,
+		},
+	}
+}
+
+func (g *generate) statefulsetForSentinel(cRedis *v1beta1.CustomRedis) *appv1.StatefulSet {
 	name := fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix)
+	headlessName := fmt.Sprintf("%s-%s", name, util.HeadlessServiceSuffix)
 	directory := cRedis.Spec.RedisConfig["dir"]
-	pvcNamePrefix := "pvc"
 	namespace := cRedis.Namespace
-	labels := g.createLabels(cRedis)
+	labels := g.createSentinelLabels(cRedis)
 	delete(labels, "redis.hongqchen/role")
-	labels["redis.hongqchen/owner-type"] = "deployments"
+	labels["redis.hongqchen/owner-type"] = "statefulset"
 
 	volumes := []corev1.Volume{
 		{
@@ -387,43 +749,51 @@ func (g *generate) deployment(cRedis *v1beta1.CustomRedis) *appv1.Deployment {
 		},
 	}
 
-	// pvcVolumes1111 := []corev1.PersistentVolumeClaim{}
-	// if cr.Spec.VolumeConfig != nil {
-	//	pvcVolumes = append(pvcVolumes, corev1.PersistentVolumeClaim{
-	//		ObjectMeta: metav1.ObjectMeta{
-	//			Name:      pvcNamePrefix,
-	//			Namespace: cr.Namespace,
-	//		},
-	//		Spec: *cr.Spec.VolumeConfig,
-	//	})
-	// }
-
-	if cRedis.Spec.VolumeConfig != nil {
-		// pvc exists
-		volumes = append(volumes, corev1.Volume{
-			Name: "volume-sentinel-config-writable",
-			VolumeSource: corev1.VolumeSource{
-				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-					ClaimName: pvcNamePrefix,
-				},
+	// sentinel 会改写自身配置文件（已知的 replica、sentinel 以及 epoch），
+	// 配置了存储时使用 volumeClaimTemplates 持久化，否则退化为 emptyDir
+	var pvcVolumes []corev1.PersistentVolumeClaim
+	if sentinelClaim := cRedis.SentinelVolumeClaim(); sentinelClaim != nil {
+		pvcVolumes = append(pvcVolumes, corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pvcNamePrefix,
+				Namespace: namespace,
 			},
+			Spec: *sentinelClaim,
 		})
 	} else {
-		// pvc not exists, use emptyDir
 		volumes = append(volumes, corev1.Volume{
-			Name: "volume-sentinel-config-writable",
+			Name: pvcNamePrefix,
 			VolumeSource: corev1.VolumeSource{
 				EmptyDir: &corev1.EmptyDirVolumeSource{},
 			},
 		})
 	}
 
+	sentinelConfigPath := fmt.Sprintf("%s/%s", directory, util.SentinelConfigFileName)
+	announceHost := util.GetPodFQDN("${POD_NAME}", headlessName, namespace)
+
+	// 仅在配置文件不存在时拷贝，避免覆盖 sentinel 已持久化的状态
+	// announce-ip 使用 Pod 的稳定 DNS 名称，配合 announce-hostnames 对外宣告
+	prepareScript := fmt.Sprintf(
+		"if [ ! -f %[1]s ]; then cp %[2]s/%[3]s %[1]s && printf '\\nsentinel announce-ip %%s\\n' \"%[4]s\" >> %[1]s; fi",
+		sentinelConfigPath, util.RedisConfigMountPath, util.SentinelConfigFileName, announceHost,
+	)
+
+	podNameEnv := []corev1.EnvVar{
+		{
+			Name: "POD_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+			},
+		},
+	}
+
 	containers := []corev1.Container{
 		{
 			Name:       util.SentinelResourceSuffix,
 			Image:      cRedis.Spec.Templates.Image,
 			Command:    []string{"redis-server"},
-			Args:       []string{fmt.Sprintf("%s/%s", directory, util.SentinelConfigFileName), "--sentinel"},
+			Args:       []string{sentinelConfigPath, "--sentinel"},
 			WorkingDir: "",
 			Ports: []corev1.ContainerPort{
 				{
@@ -434,57 +804,49 @@ func (g *generate) deployment(cRedis *v1beta1.CustomRedis) *appv1.Deployment {
 			},
 			VolumeMounts: []corev1.VolumeMount{
 				{
-					Name:      "volume-sentinel-config-writable",
+					Name:      pvcNamePrefix,
 					MountPath: directory,
 				},
 			},
+			ImagePullPolicy: cRedis.Spec.Templates.ImagePullPolicy,
 		},
 	}
 
 	initcontainers := []corev1.Container{
 		{
-			Name:  "prepare-sentinel-config",
-			Image: cRedis.Spec.Templates.InitImage,
-			Command: []string{
-				"cp",
-				fmt.Sprintf("%s/%s", util.RedisConfigMountPath, util.SentinelConfigFileName),
-				fmt.Sprintf("%s/%s", directory, util.SentinelConfigFileName),
-			},
+			Name:    "prepare-sentinel-config",
+			Image:   cRedis.Spec.Templates.InitImage,
+			Command: []string{"sh", "-c", prepareScript},
+			Env:     podNameEnv,
 			VolumeMounts: []corev1.VolumeMount{
 				{
 					Name:      "volume-sentinel-config-readonly",
 					MountPath: util.RedisConfigMountPath,
 				},
 				{
-					Name:      "volume-sentinel-config-writable",
+					Name:      pvcNamePrefix,
 					MountPath: directory,
 				},
 			},
 		},
 	}
 
-	return &appv1.Deployment{
+	return &appv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            name,
 			Namespace:       namespace,
 			OwnerReferences: g.createOwnerReference(cRedis),
 			Labels:          labels,
 		},
-		Spec: appv1.DeploymentSpec{
-			Replicas: cRedis.Spec.SentinelNum,
+		Spec: appv1.StatefulSetSpec{
+			Replicas:    cRedis.Spec.SentinelNum,
+			ServiceName: headlessName,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
-				},
-				Spec: corev1.PodSpec{
-					InitContainers: initcontainers,
-					Containers:     containers,
-					Volumes:        volumes,
-				},
-			},
+			VolumeClaimTemplates: pvcVolumes,
+			PodManagementPolicy:  appv1.ParallelPodManagement,
+			Template:             g.podTemplate(cRedis, labels, initcontainers, containers, volumes),
 		},
 	}
 }
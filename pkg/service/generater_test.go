@@ -0,0 +1,273 @@
+package service
+
+import (
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPodTemplate(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisCreating)
+	runAsNonRoot := true
+	tc.cRedis.Spec.Templates.Env = []corev1.EnvVar{{Name: "TZ", Value: "UTC"}}
+	tc.cRedis.Spec.Templates.SecurityContext = &corev1.SecurityContext{RunAsNonRoot: &runAsNonRoot}
+	tc.cRedis.Spec.Templates.ServiceAccountName = "redis"
+	tc.cRedis.Spec.Templates.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	tc.cRedis.Spec.Templates.PodLabels = map[string]string{"team": "cache", "redis.hongqchen/owner-type": "custom"}
+	tc.cRedis.Spec.Templates.PodAnnotations = map[string]string{"vault.hashicorp.com/agent-inject": "true"}
+	tc.cRedis.Spec.Templates.ExtraContainers = []corev1.Container{{Name: "fluent-bit", Image: "fluent/fluent-bit:2.1"}}
+	tc.cRedis.Spec.Templates.ExtraVolumes = []corev1.Volume{{Name: "logs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+	tc.cRedis.Spec.Templates.ExtraVolumeMounts = []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log/redis"}}
+
+	g := newGenerate()
+	for _, sts := range []struct {
+		name      string
+		container string
+		template  corev1.PodTemplateSpec
+		selector  map[string]string
+	}{
+		{name: "redis", container: tc.cRedis.Name, template: g.statefulset(tc.cRedis).Spec.Template, selector: g.statefulset(tc.cRedis).Spec.Selector.MatchLabels},
+		{name: "sentinel", container: util.SentinelResourceSuffix, template: g.statefulsetForSentinel(tc.cRedis).Spec.Template, selector: g.statefulsetForSentinel(tc.cRedis).Spec.Selector.MatchLabels},
+	} {
+		t.Run(sts.name, func(t *testing.T) {
+			podSpec := sts.template.Spec
+			for k, v := range sts.selector {
+				if sts.template.Labels[k] != v {
+					t.Errorf("pod label %s = %q, want selector value %q", k, sts.template.Labels[k], v)
+				}
+			}
+			if sts.template.Labels["team"] != "cache" || sts.template.Annotations["vault.hashicorp.com/agent-inject"] != "true" {
+				t.Errorf("pod metadata = %v %v, want the custom label and annotation", sts.template.Labels, sts.template.Annotations)
+			}
+			if podSpec.ServiceAccountName != "redis" || len(podSpec.ImagePullSecrets) != 1 {
+				t.Errorf("serviceAccountName = %q, imagePullSecrets = %v", podSpec.ServiceAccountName, podSpec.ImagePullSecrets)
+			}
+
+			if len(podSpec.Containers) != 2 || podSpec.Containers[1].Name != "fluent-bit" || podSpec.Containers[1].SecurityContext != nil {
+				t.Fatalf("containers = %v, want the sidecar after the %s container", podSpec.Containers, sts.container)
+			}
+			main := podSpec.Containers[0]
+			if main.Name != sts.container || main.SecurityContext == nil || !*main.SecurityContext.RunAsNonRoot {
+				t.Errorf("container %s security context = %v, want runAsNonRoot", main.Name, main.SecurityContext)
+			}
+			if podSpec.InitContainers[0].SecurityContext == nil {
+				t.Errorf("init container %s has no security context", podSpec.InitContainers[0].Name)
+			}
+			if env := main.Env[len(main.Env)-1]; env.Name != "TZ" {
+				t.Errorf("last env of %s = %s, want TZ", main.Name, env.Name)
+			}
+			if mount := main.VolumeMounts[len(main.VolumeMounts)-1]; mount.Name != "logs" {
+				t.Errorf("last volume mount of %s = %s, want logs", main.Name, mount.Name)
+			}
+			if volume := podSpec.Volumes[len(podSpec.Volumes)-1]; volume.Name != "logs" {
+				t.Errorf("last volume = %s, want logs", volume.Name)
+			}
+		})
+	}
+}
+
+func TestStatefulsetModules(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisCreating)
+	tc.cRedis.Spec.Modules = []v1beta1.ModuleConfig{
+		{Name: "search", Path: "/usr/lib/redis/modules/redisearch.so", Image: "redis/redis-stack-server:7.2.0-v6", Args: []string{"MAXSEARCHRESULTS", "1000"}},
+		{Name: "bf", Path: "/opt/redisbloom.so"},
+	}
+
+	g := newGenerate()
+	conf := g.configmap(tc.cRedis).Data[util.RedisConfigFileName]
+	for _, want := range []string{
+		"loadmodule /redis/modules/redisearch.so MAXSEARCHRESULTS 1000\n",
+		"loadmodule /opt/redisbloom.so\n",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Errorf("redis.conf does not contain %q:\n%s", want, conf)
+		}
+	}
+
+	podSpec := g.statefulset(tc.cRedis).Spec.Template.Spec
+	// only modules shipped in their own image need an init container, after the config and master lookup ones
+	if len(podSpec.InitContainers) != 3 {
+		t.Fatalf("got %d init containers, want 3", len(podSpec.InitContainers))
+	}
+	copyModule := podSpec.InitContainers[2]
+	if copyModule.Image != "redis/redis-stack-server:7.2.0-v6" ||
+		strings.Join(copyModule.Command, " ") != "cp /usr/lib/redis/modules/redisearch.so /redis/modules/redisearch.so" {
+		t.Errorf("init container = %s %v, want the module copied from its image", copyModule.Image, copyModule.Command)
+	}
+	mounted := false
+	for _, mount := range podSpec.Containers[0].VolumeMounts {
+		if mount.Name == modulesVolumeName && mount.MountPath == util.RedisModulesPath {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Errorf("modules volume is not mounted at %s in the redis container", util.RedisModulesPath)
+	}
+}
+
+// fake redis-cli: "sentinel ..." prints $STUB/sentinel, "role" against a host prints $STUB/role-<host>;
+// sleep applies the sentinel failover prepared in $STUB/sentinel-next
+var findMasterStubs = map[string]string{
+	"redis-cli": `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -h) host=$2; shift 2 ;;
+    -p|-a) shift 2 ;;
+    --raw|--no-auth-warning) shift ;;
+    *) break ;;
+  esac
+done
+case "$1" in
+  sentinel) cat "$STUB/sentinel" 2>/dev/null ;;
+  role) cat "$STUB/role-$host" 2>/dev/null ;;
+esac
+`,
+	"getent": "#!/bin/sh\ncat \"$STUB/hosts\" 2>/dev/null\n",
+	"sleep":  "#!/bin/sh\n[ -f \"$STUB/sentinel-next\" ] && mv \"$STUB/sentinel-next\" \"$STUB/sentinel\"\nexit 0\n",
+}
+
+func TestFindMasterScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	const self = "redis-1.redis-headless.default.svc"
+	slaveOfSelf := "slave\n" + self + "\n6379\n"
+
+	tests := []struct {
+		name  string
+		mode  v1beta1.ClusterMode
+		files map[string]string
+		// exit code of the script and the replicaof line appended to redis.conf
+		wantExit      int
+		wantReplicaOf string
+	}{
+		{
+			name:          "sentinel knows the master",
+			mode:          v1beta1.Sentinel,
+			files:         map[string]string{"sentinel": "10.0.0.1\n6379\n", "role-10.0.0.1": "master\n"},
+			wantReplicaOf: "replicaof 10.0.0.1 6379",
+		},
+		{
+			name: "waits for the sentinel failover",
+			mode: v1beta1.Sentinel,
+			files: map[string]string{
+				"sentinel": self + "\n6379\n", "sentinel-next": "10.0.0.3\n6379\n",
+				"role-10.0.0.3": "master\n", "hosts": "10.0.0.3 STREAM\n",
+			},
+			wantReplicaOf: "replicaof 10.0.0.3 6379",
+		},
+		{
+			name:     "refuses to start while a slave replicates from it",
+			mode:     v1beta1.Sentinel,
+			files:    map[string]string{"sentinel": self + "\n6379\n", "hosts": "10.0.0.3 STREAM\n10.0.0.3 DGRAM\n", "role-10.0.0.3": slaveOfSelf},
+			wantExit: 1,
+		},
+		{
+			name:  "sentinel not configured yet",
+			mode:  v1beta1.Sentinel,
+			files: map[string]string{"sentinel": util.SentinelPlaceholderIPv4 + "\n6379\n", "role-127.0.0.1": "master\n"},
+		},
+		{
+			name:          "master-slave follows the master service",
+			mode:          v1beta1.MasterSlave,
+			files:         map[string]string{"role-redis-master.default.svc": "master\n"},
+			wantReplicaOf: "replicaof redis-master.default.svc 6379",
+		},
+		{
+			name:  "master-slave starts without a master",
+			mode:  v1beta1.MasterSlave,
+			files: map[string]string{"hosts": "10.0.0.3 STREAM\n", "role-10.0.0.3": slaveOfSelf},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, tt.mode, util.CustomRedisRunning)
+			dir := t.TempDir()
+			stub := filepath.Join(dir, "stub")
+			bin := filepath.Join(dir, "bin")
+			for _, d := range []string{stub, bin} {
+				if err := os.Mkdir(d, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, content := range findMasterStubs {
+				if err := os.WriteFile(filepath.Join(bin, name), []byte(content), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(stub, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conf := filepath.Join(dir, "redis.conf")
+			if err := os.WriteFile(conf, []byte("port 6379\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			script := newGenerate().findMasterScript(tc.cRedis, conf, self, "redis-headless")
+			cmd := exec.Command("sh", "-c", script)
+			cmd.Env = append(os.Environ(), "STUB="+stub, "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+			out, err := cmd.CombinedOutput()
+			exitCode := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if exitCode != tt.wantExit {
+				t.Fatalf("script exited with %d, want %d, output:\n%s", exitCode, tt.wantExit, out)
+			}
+
+			data, err := os.ReadFile(conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(string(data), "replicaof"); got != (tt.wantReplicaOf != "") || !strings.Contains(string(data), tt.wantReplicaOf) {
+				t.Errorf("redis.conf = %q, want replicaof line %q", data, tt.wantReplicaOf)
+			}
+		})
+	}
+}
+
+func TestFindMasterInitContainer(t *testing.T) {
+	g := newGenerate()
+	for _, tt := range []struct {
+		name   string
+		volume *corev1.PersistentVolumeClaimSpec
+		want   bool
+	}{
+		{name: "emptyDir", want: true},
+		{name: "persistent data", volume: &corev1.PersistentVolumeClaimSpec{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisCreating)
+			tc.cRedis.Spec.VolumeConfig = tt.volume
+
+			found := false
+			for _, container := range g.statefulset(tc.cRedis).Spec.Template.Spec.InitContainers {
+				found = found || container.Name == util.FindMasterContainerName
+			}
+			if found != tt.want {
+				t.Errorf("init container %s present = %v, want %v", util.FindMasterContainerName, found, tt.want)
+			}
+		})
+	}
+}
+
+func TestSentinelConfigQuorum(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisCreating)
+	sentinels := int32(5)
+	tc.cRedis.Spec.SentinelNum = &sentinels
+
+	conf := newGenerate().configmapForSentinel(tc.cRedis).Data[util.SentinelConfigFileName]
+	if want := "sentinel monitor mymaster 127.0.0.1 6379 3\n"; !strings.Contains(conf, want) {
+		t.Errorf("sentinel.conf does not contain %q:\n%s", want, conf)
+	}
+}
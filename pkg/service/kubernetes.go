@@ -5,9 +5,13 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/hongqchen/redis-operator/api/v1beta1"
 	"github.com/hongqchen/redis-operator/pkg/client/kubernetes"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
 	"github.com/hongqchen/redis-operator/pkg/util"
+	"github.com/pkg/errors"
 	appv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -23,27 +27,48 @@ type kubernetesServicer interface {
 	GetStatefulset(name, namespace string) (*appv1.StatefulSet, error)
 	CreateStatefulset(sts *appv1.StatefulSet) error
 	UpdateStatefulset(sts *appv1.StatefulSet) error
+	// DryRunUpdateStatefulset 返回 API server 填充默认值后的 statefulset，不实际更新
+	DryRunUpdateStatefulset(sts *appv1.StatefulSet) (*appv1.StatefulSet, error)
+	// OrphanDeleteStatefulset 删除 statefulset 但保留其 Pod，用于重建 statefulset 修改不可变字段
+	OrphanDeleteStatefulset(name, namespace string) error
 
 	// service
 	GetService(name, namespace string) (*corev1.Service, error)
 	CreateService(service *corev1.Service) error
 	UpdateService(service *corev1.Service) error
+	GetServices(namespace string, selector map[string]string) ([]corev1.Service, error)
+	DeleteService(name, namespace string) error
 
-	// deployment
+	// deployment，仅用于清理旧版本以 deployment 方式部署的 sentinel
 	GetDeployment(name, namespace string) (*appv1.Deployment, error)
-	CreateDeployment(deploy *appv1.Deployment) error
-	UpdateDeployment(deploy *appv1.Deployment) error
+	DeleteDeployment(name, namespace string) error
 
 	// GetReplicas 获取副本数
 	GetReplicas(cRedis *v1beta1.CustomRedis) (int32, error)
+	// GetStatefulsetPods 获取 statefulset 的所有 pod，包括未 ready 的 pod
+	GetStatefulsetPods(name, namespace string) ([]corev1.Pod, error)
 	// GetStatefulsetReadyPods 获取 statefulset ready 的 pod 列表
 	GetStatefulsetReadyPods(name, namespace string) ([]corev1.Pod, error)
-	// GetDeploymentReadyPods 获取 deployment ready 的 Pod 列表
-	GetDeploymentReadyPods(name, namespace string) ([]corev1.Pod, error)
-	// GetIPsByMasterRole 获取 master 节点的 IP 列表
-	GetMasterIPs(cRedis *v1beta1.CustomRedis) ([]string, error)
+	// GetMasterPods 获取角色为 master 的 Pod 列表
+	GetMasterPods(cRedis *v1beta1.CustomRedis) ([]corev1.Pod, error)
 
 	UpdatePodIfExists(podObj *corev1.Pod) error
+	DeletePod(name, namespace string) error
+	// GetPodExternalAddress 获取 Pod 对应 per-pod service 的外部访问地址
+	GetPodExternalAddress(pod *corev1.Pod) (string, int32, error)
+
+	// pvc
+	GetPersistentVolumeClaim(name, namespace string) (*corev1.PersistentVolumeClaim, error)
+	UpdatePersistentVolumeClaim(pvc *corev1.PersistentVolumeClaim) error
+	// IsVolumeExpansionAllowed 判断 storage class 是否允许扩容 PVC
+	IsVolumeExpansionAllowed(storageClassName string) (bool, error)
+
+	// GetSecretValue 获取 secret 中指定 key 的值
+	GetSecretValue(selector *corev1.SecretKeySelector, namespace string) (string, error)
+
+	// customredis，供 RedisMigration 读取并修改目标集群
+	GetCustomRedis(name, namespace string) (*v1beta1.CustomRedis, error)
+	UpdateCustomRedis(cRedis *v1beta1.CustomRedis) error
 }
 
 type KubernetesService struct {
@@ -52,11 +77,11 @@ type KubernetesService struct {
 	redisService RedisServicer
 }
 
-func NewkubernetesService(cl client.Client, logger logr.Logger) *KubernetesService {
+func NewkubernetesService(cl client.Client, rcl redis.Clienter, logger logr.Logger) *KubernetesService {
 	return &KubernetesService{
 		logger:       logger,
 		k8sClient:    kubernetes.NewClient(cl),
-		redisService: NewRedisService(logger),
+		redisService: NewRedisService(rcl, logger),
 	}
 }
 
@@ -66,7 +91,7 @@ func (ks *KubernetesService) getObj(cRedis *v1beta1.CustomRedis) (interface{}, e
 	}
 
 	if cRedis.Spec.ClusterMode == v1beta1.Sentinel {
-		return ks.k8sClient.GetDeployment(fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix), cRedis.Namespace)
+		return ks.k8sClient.GetStatefulset(fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix), cRedis.Namespace)
 	}
 
 	return "", fmt.Errorf("invalid cluster mode")
@@ -82,17 +107,13 @@ func (ks *KubernetesService) GetReplicas(cRedis *v1beta1.CustomRedis) (int32, er
 	switch obj := res.(type) {
 	case *appv1.StatefulSet:
 		return *obj.Spec.Replicas, nil
-	case *appv1.Deployment:
-		return *obj.Spec.Replicas, nil
 	default:
 		return 0, fmt.Errorf("invalid resource type")
 	}
 }
 
-// For example [{"name":"pod_name","ip":"pod_ip"},{"name2":"pod_name2","ip2":"pod_ip2"}]
-func (ks *KubernetesService) GetStatefulsetReadyPods(name, namespace string) ([]corev1.Pod, error) {
-	ks.logger.V(1).Info("Getting all ready pods with statefulset")
-	var readyPods []corev1.Pod
+func (ks *KubernetesService) GetStatefulsetPods(name, namespace string) ([]corev1.Pod, error) {
+	ks.logger.V(1).Info("Getting all pods with statefulset")
 
 	// 从 statefulset 获取 Pod selector
 	storedSts, err := ks.k8sClient.GetStatefulset(name, namespace)
@@ -107,34 +128,20 @@ func (ks *KubernetesService) GetStatefulsetReadyPods(name, namespace string) ([]
 		return nil, err
 	}
 
-	for _, pod := range podsObj.Items {
-		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
-			if len(pod.Status.Conditions) > 1 && pod.Status.Conditions[1].Status == "True" {
-				readyPods = append(readyPods, pod)
-			}
-		}
-	}
-
-	return readyPods, nil
+	return podsObj.Items, nil
 }
 
-func (ks *KubernetesService) GetDeploymentReadyPods(name, namespace string) ([]corev1.Pod, error) {
-	ks.logger.V(1).Info("Getting all ready pods with deployment")
+// For example [{"name":"pod_name","ip":"pod_ip"},{"name2":"pod_name2","ip2":"pod_ip2"}]
+func (ks *KubernetesService) GetStatefulsetReadyPods(name, namespace string) ([]corev1.Pod, error) {
+	ks.logger.V(1).Info("Getting all ready pods with statefulset")
 	var readyPods []corev1.Pod
 
-	storedObj, err := ks.k8sClient.GetDeployment(name, namespace)
-	if err != nil {
-		return nil, err
-	}
-	selector := storedObj.Spec.Selector.MatchLabels
-
-	// 通过 selector 获取 Pod 列表
-	podsObj, err := ks.k8sClient.GetPods(namespace, selector)
+	pods, err := ks.GetStatefulsetPods(name, namespace)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, pod := range podsObj.Items {
+	for _, pod := range pods {
 		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
 			if len(pod.Status.Conditions) > 1 && pod.Status.Conditions[1].Status == "True" {
 				readyPods = append(readyPods, pod)
@@ -145,9 +152,9 @@ func (ks *KubernetesService) GetDeploymentReadyPods(name, namespace string) ([]c
 	return readyPods, nil
 }
 
-func (ks *KubernetesService) GetMasterIPs(cRedis *v1beta1.CustomRedis) ([]string, error) {
-	ks.logger.V(1).Info("Getting master IPs in cluster")
-	var masterIPs []string
+func (ks *KubernetesService) GetMasterPods(cRedis *v1beta1.CustomRedis) ([]corev1.Pod, error) {
+	ks.logger.V(1).Info("Getting master pods in cluster")
+	var masterPods []corev1.Pod
 
 	pods, err := ks.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
 	if err != nil {
@@ -155,18 +162,22 @@ func (ks *KubernetesService) GetMasterIPs(cRedis *v1beta1.CustomRedis) ([]string
 	}
 
 	for _, pod := range pods {
-		ip := pod.Status.PodIP
-		ismaster, err := ks.redisService.IsMaster(cRedis, ip)
+		ismaster, err := ks.redisService.IsMaster(cRedis, pod.Status.PodIP)
 		if err != nil {
 			return nil, err
 		}
 
 		if ismaster {
-			masterIPs = append(masterIPs, ip)
+			masterPods = append(masterPods, pod)
 		}
 	}
 
-	return masterIPs, nil
+	return masterPods, nil
+}
+
+func (ks *KubernetesService) DeletePod(name, namespace string) error {
+	ks.logger.V(1).Info("Deleting pod", "pod", fmt.Sprintf("%s/%s", namespace, name))
+	return ks.k8sClient.DeletePod(name, namespace)
 }
 
 func (ks *KubernetesService) UpdatePodIfExists(podObj *corev1.Pod) error {
@@ -180,6 +191,58 @@ func (ks *KubernetesService) UpdatePodIfExists(podObj *corev1.Pod) error {
 	return ks.k8sClient.UpdatePod(podObj)
 }
 
+// LoadBalancer 使用 ingress 地址，NodePort 使用 Pod 所在节点的地址（优先 ExternalIP）
+func (ks *KubernetesService) GetPodExternalAddress(pod *corev1.Pod) (string, int32, error) {
+	ks.logger.V(1).Info("Getting external address of pod", "pod", pod.Name)
+	svc, err := ks.k8sClient.GetService(fmt.Sprintf("%s-%s", pod.Name, util.ExternalServiceSuffix), pod.Namespace)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(svc.Spec.Ports) == 0 {
+		return "", 0, fmt.Errorf("service %s has no port", svc.Name)
+	}
+
+	switch svc.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		ingress := svc.Status.LoadBalancer.Ingress
+		if len(ingress) == 0 {
+			return "", 0, util.ExternalAddressPendingErr
+		}
+		if ingress[0].IP != "" {
+			return ingress[0].IP, svc.Spec.Ports[0].Port, nil
+		}
+		return ingress[0].Hostname, svc.Spec.Ports[0].Port, nil
+	case corev1.ServiceTypeNodePort:
+		if pod.Spec.NodeName == "" || svc.Spec.Ports[0].NodePort == 0 {
+			return "", 0, util.ExternalAddressPendingErr
+		}
+		node, err := ks.k8sClient.GetNode(pod.Spec.NodeName)
+		if apierror.IsForbidden(err) {
+			return "", 0, errors.Wrap(err, "NodePort services need read access to nodes, see config/namespaced-cluster")
+		}
+		if err != nil {
+			return "", 0, err
+		}
+
+		nodeAddress := ""
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeExternalIP {
+				nodeAddress = address.Address
+				break
+			}
+			if address.Type == corev1.NodeInternalIP && nodeAddress == "" {
+				nodeAddress = address.Address
+			}
+		}
+		if nodeAddress == "" {
+			return "", 0, fmt.Errorf("node %s has no address", node.Name)
+		}
+		return nodeAddress, svc.Spec.Ports[0].NodePort, nil
+	}
+
+	return "", 0, fmt.Errorf("service %s is neither NodePort nor LoadBalancer", svc.Name)
+}
+
 func (ks *KubernetesService) GetConfigmap(name, namespace string) (*corev1.ConfigMap, error) {
 	ks.logger.V(1).Info("Getting configmap")
 	return ks.k8sClient.GetConfigmap(name, namespace)
@@ -210,6 +273,16 @@ func (ks *KubernetesService) UpdateStatefulset(sts *appv1.StatefulSet) error {
 	return ks.k8sClient.UpdateStatefulset(sts)
 }
 
+func (ks *KubernetesService) DryRunUpdateStatefulset(sts *appv1.StatefulSet) (*appv1.StatefulSet, error) {
+	ks.logger.V(1).Info("Updating statefulset in dry-run mode")
+	return ks.k8sClient.DryRunUpdateStatefulset(sts)
+}
+
+func (ks *KubernetesService) OrphanDeleteStatefulset(name, namespace string) error {
+	ks.logger.V(1).Info("Deleting statefulset, orphaning its pods")
+	return ks.k8sClient.DeleteStatefulset(name, namespace, metav1.DeletePropagationOrphan)
+}
+
 func (ks *KubernetesService) GetService(name, namespace string) (*corev1.Service, error) {
 	ks.logger.V(1).Info("Getting service")
 	return ks.k8sClient.GetService(name, namespace)
@@ -225,17 +298,77 @@ func (ks *KubernetesService) UpdateService(service *corev1.Service) error {
 	return ks.k8sClient.UpdateService(service)
 }
 
+func (ks *KubernetesService) GetServices(namespace string, selector map[string]string) ([]corev1.Service, error) {
+	ks.logger.V(1).Info("Getting services")
+	services, err := ks.k8sClient.GetServices(namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+	return services.Items, nil
+}
+
+func (ks *KubernetesService) DeleteService(name, namespace string) error {
+	ks.logger.V(1).Info("Deleting service", "service", fmt.Sprintf("%s/%s", namespace, name))
+	return client.IgnoreNotFound(ks.k8sClient.DeleteService(name, namespace))
+}
+
 func (ks *KubernetesService) GetDeployment(name, namespace string) (*appv1.Deployment, error) {
 	ks.logger.V(1).Info("Getting deployment")
 	return ks.k8sClient.GetDeployment(name, namespace)
 }
 
-func (ks *KubernetesService) CreateDeployment(deploy *appv1.Deployment) error {
-	ks.logger.V(1).Info("Creating deployment")
-	return ks.k8sClient.CreateDeployment(deploy)
+func (ks *KubernetesService) DeleteDeployment(name, namespace string) error {
+	ks.logger.V(1).Info("Deleting deployment")
+	return ks.k8sClient.DeleteDeployment(name, namespace)
+}
+
+func (ks *KubernetesService) GetPersistentVolumeClaim(name, namespace string) (*corev1.PersistentVolumeClaim, error) {
+	ks.logger.V(1).Info("Getting persistent volume claim")
+	return ks.k8sClient.GetPersistentVolumeClaim(name, namespace)
+}
+
+func (ks *KubernetesService) UpdatePersistentVolumeClaim(pvc *corev1.PersistentVolumeClaim) error {
+	ks.logger.V(1).Info("Updating persistent volume claim", "pvc", fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name))
+	return ks.k8sClient.UpdatePersistentVolumeClaim(pvc)
+}
+
+func (ks *KubernetesService) IsVolumeExpansionAllowed(storageClassName string) (bool, error) {
+	ks.logger.V(1).Info("Checking whether storage class allows volume expansion", "storageClass", storageClassName)
+	// 未指定 storage class 的 PVC 为静态绑定的 PV，无法扩容
+	if storageClassName == "" {
+		return false, nil
+	}
+	storageClass, err := ks.k8sClient.GetStorageClass(storageClassName)
+	// 仅有 namespace 级别权限的 operator 无法读取 storage class，视为不允许扩容
+	if apierror.IsForbidden(err) {
+		ks.logger.Info("Not allowed to read the storage class, skipping volume expansion", "storageClass", storageClassName)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return storageClass.AllowVolumeExpansion != nil && *storageClass.AllowVolumeExpansion, nil
+}
+
+func (ks *KubernetesService) GetSecretValue(selector *corev1.SecretKeySelector, namespace string) (string, error) {
+	ks.logger.V(1).Info("Getting secret value", "secret", fmt.Sprintf("%s/%s", namespace, selector.Name), "key", selector.Key)
+	secret, err := ks.k8sClient.GetSecret(selector.Name, namespace)
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[selector.Key]
+	if !ok {
+		return "", errors.Errorf("key %s not found in secret %s/%s", selector.Key, namespace, selector.Name)
+	}
+	return string(value), nil
+}
+
+func (ks *KubernetesService) GetCustomRedis(name, namespace string) (*v1beta1.CustomRedis, error) {
+	ks.logger.V(1).Info("Getting customredis", "customredis", fmt.Sprintf("%s/%s", namespace, name))
+	return ks.k8sClient.GetCustomRedis(name, namespace)
 }
 
-func (ks *KubernetesService) UpdateDeployment(deploy *appv1.Deployment) error {
-	ks.logger.V(1).Info("Updating deployment")
-	return ks.k8sClient.UpdateDeployment(deploy)
+func (ks *KubernetesService) UpdateCustomRedis(cRedis *v1beta1.CustomRedis) error {
+	ks.logger.V(1).Info("Updating customredis", "customredis", fmt.Sprintf("%s/%s", cRedis.Namespace, cRedis.Name))
+	return ks.k8sClient.UpdateCustomRedis(cRedis)
 }
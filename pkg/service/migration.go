@@ -0,0 +1,221 @@
+package service
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	"github.com/pkg/errors"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+)
+
+type Migrator interface {
+	// GetDestination 获取迁移的目标集群
+	GetDestination(rm *v1beta1.RedisMigration) (*v1beta1.CustomRedis, error)
+	// Replication 方式：通过目标集群的 spec.replicaOf 复制源实例，切换时删除该字段提升目标集群
+	EnsureReplicaOf(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error
+	EnsureDetached(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error
+	ObserveReplication(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error
+	// Dump 方式：从 status.cursor 开始复制一批 key 到目标集群的 master，一轮扫描完成时返回 true
+	CopyKeys(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) (bool, error)
+	// ObserveKeys 刷新源实例与目标集群 master 的 key 数量
+	ObserveKeys(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error
+}
+
+var _ Migrator = (*Migrate)(nil)
+
+type Migrate struct {
+	logger       logr.Logger
+	k8sService   kubernetesServicer
+	redisService RedisServicer
+	// 源实例不属于任何 CustomRedis，直接使用 redis client 访问
+	redisClient redis.Clienter
+}
+
+func NewMigrate(cl client.Client, rcl redis.Clienter, logger logr.Logger) *Migrate {
+	return &Migrate{
+		logger:       logger,
+		k8sService:   NewkubernetesService(cl, rcl, logger),
+		redisService: NewRedisService(rcl, logger),
+		redisClient:  rcl,
+	}
+}
+
+func (m *Migrate) GetDestination(rm *v1beta1.RedisMigration) (*v1beta1.CustomRedis, error) {
+	return m.k8sService.GetCustomRedis(rm.Spec.Destination, rm.Namespace)
+}
+
+func (m *Migrate) EnsureReplicaOf(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error {
+	m.logger.V(1).Info("Ensuring the destination replicates from the source", "destination", cRedis.Name)
+
+	if replicaOf := cRedis.Spec.ReplicaOf; replicaOf != nil {
+		if !isSameSource(replicaOf, &rm.Spec.Source) {
+			host, port := replicaOf.Address()
+			return errors.Wrapf(util.MigrationConflictErr, "spec.replicaOf of %s is %s", cRedis.Name, net.JoinHostPort(host, strconv.Itoa(int(port))))
+		}
+		return nil
+	}
+
+	// 目标集群原有的数据会被源实例的全量同步覆盖
+	m.logger.Info("Making the destination a standby of the source", "destination", cRedis.Name, "source", sourceAddress(rm))
+	cRedis.Spec.ReplicaOf = rm.Spec.Source.DeepCopy()
+	return m.k8sService.UpdateCustomRedis(cRedis)
+}
+
+func (m *Migrate) EnsureDetached(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error {
+	m.logger.V(1).Info("Ensuring the destination is detached from the source", "destination", cRedis.Name)
+
+	replicaOf := cRedis.Spec.ReplicaOf
+	if replicaOf == nil {
+		return nil
+	}
+	// 不修改由用户或其他迁移设置的 replicaOf
+	if !isSameSource(replicaOf, &rm.Spec.Source) {
+		host, port := replicaOf.Address()
+		return errors.Wrapf(util.MigrationConflictErr, "spec.replicaOf of %s is %s", cRedis.Name, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	}
+
+	m.logger.Info("Promoting the destination", "destination", cRedis.Name)
+	cRedis.Spec.ReplicaOf = nil
+	return m.k8sService.UpdateCustomRedis(cRedis)
+}
+
+func (m *Migrate) ObserveReplication(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error {
+	m.logger.V(1).Info("Observing replication from the source", "destination", cRedis.Name)
+
+	rm.Status.LinkStatus = ""
+	standby := cRedis.Status.Standby
+	if standby == nil || standby.Source != sourceAddress(rm) {
+		return nil
+	}
+	ip, err := m.podIP(cRedis, standby.Pod)
+	if err != nil {
+		return err
+	}
+
+	sourceInfo, err := m.sourceInfo(rm)
+	if err != nil {
+		return err
+	}
+	destinationInfo, err := m.redisService.GetInfo(cRedis, ip)
+	if err != nil {
+		return err
+	}
+
+	rm.Status.LinkStatus = destinationInfo.Replication.MasterLinkStatus
+	rm.Status.LagBytes = 0
+	if lag := sourceInfo.Replication.MasterReplOffset - destinationInfo.Replication.ProcessedOffset(); lag > 0 {
+		rm.Status.LagBytes = lag
+	}
+	rm.Status.SourceKeys = sourceInfo.Keys()
+	rm.Status.DestinationKeys = destinationInfo.Keys()
+	return nil
+}
+
+func (m *Migrate) CopyKeys(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) (bool, error) {
+	ip, err := m.masterIP(cRedis)
+	if err != nil {
+		return false, err
+	}
+
+	var cursor uint64
+	if rm.Status.Cursor != "" {
+		if cursor, err = strconv.ParseUint(rm.Status.Cursor, 10, 64); err != nil {
+			return false, errors.Wrapf(err, "invalid cursor %q", rm.Status.Cursor)
+		}
+	}
+
+	host, port := rm.Spec.Source.Address()
+	password, err := m.sourcePassword(rm)
+	if err != nil {
+		return false, err
+	}
+	keys, next, err := m.redisClient.ScanDump(host, port, password, cursor, rm.BatchSizeOrDefault())
+	if err != nil {
+		return false, err
+	}
+	if err := m.redisService.RestoreKeys(cRedis, ip, keys); err != nil {
+		return false, err
+	}
+	m.logger.V(2).Info("Copied keys", "keys", len(keys), "cursor", cursor, "next", next)
+
+	rm.Status.CopiedKeys += int64(len(keys))
+	// 一轮扫描完成后清空 cursor，下一轮从头开始
+	if next == 0 {
+		rm.Status.Cursor = ""
+		return true, nil
+	}
+	rm.Status.Cursor = strconv.FormatUint(next, 10)
+	return false, nil
+}
+
+func (m *Migrate) ObserveKeys(rm *v1beta1.RedisMigration, cRedis *v1beta1.CustomRedis) error {
+	m.logger.V(1).Info("Observing key counts", "destination", cRedis.Name)
+
+	sourceInfo, err := m.sourceInfo(rm)
+	if err != nil {
+		return err
+	}
+	rm.Status.SourceKeys = sourceInfo.Keys()
+
+	ip, err := m.masterIP(cRedis)
+	if err != nil {
+		return err
+	}
+	destinationInfo, err := m.redisService.GetInfo(cRedis, ip)
+	if err != nil {
+		return err
+	}
+	rm.Status.DestinationKeys = destinationInfo.Keys()
+	return nil
+}
+
+func (m *Migrate) sourceInfo(rm *v1beta1.RedisMigration) (*redis.Info, error) {
+	host, port := rm.Spec.Source.Address()
+	password, err := m.sourcePassword(rm)
+	if err != nil {
+		return nil, err
+	}
+	return m.redisClient.GetInfo(host, port, password, "")
+}
+
+func (m *Migrate) sourcePassword(rm *v1beta1.RedisMigration) (string, error) {
+	if rm.Spec.Source.PasswordSecret == nil {
+		return "", nil
+	}
+	return m.k8sService.GetSecretValue(rm.Spec.Source.PasswordSecret, rm.Namespace)
+}
+
+// masterIP 目标集群 master Pod 的 IP，由 CustomRedis 的 status.master 决定
+func (m *Migrate) masterIP(cRedis *v1beta1.CustomRedis) (string, error) {
+	if cRedis.Status.Master == "" {
+		return "", errors.Wrapf(util.MasterBeElectingErr, "customredis %s reports no master", cRedis.Name)
+	}
+	return m.podIP(cRedis, cRedis.Status.Master)
+}
+
+func (m *Migrate) podIP(cRedis *v1beta1.CustomRedis, name string) (string, error) {
+	pods, err := m.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		return "", err
+	}
+	for i := range pods {
+		if pods[i].Name == name {
+			return pods[i].Status.PodIP, nil
+		}
+	}
+	return "", errors.Wrapf(util.AllPodReadyErr, "pod %s", name)
+}
+
+func sourceAddress(rm *v1beta1.RedisMigration) string {
+	host, port := rm.Spec.Source.Address()
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+func isSameSource(a, b *v1beta1.ReplicaOfSpec) bool {
+	aHost, aPort := a.Address()
+	bHost, bPort := b.Address()
+	return aHost == bHost && aPort == bPort
+}
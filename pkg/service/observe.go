@@ -0,0 +1,157 @@
+package service
+
+import (
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+)
+
+type Observer interface {
+	// ObserveStatus 只读取集群状态并写入 cRedis.Status，不修改集群
+	ObserveStatus(cRedis *v1beta1.CustomRedis) error
+}
+
+type Observe struct {
+	logger       logr.Logger
+	k8sService   kubernetesServicer
+	redisService RedisServicer
+}
+
+func NewObserve(cl client.Client, rcl redis.Clienter, logger logr.Logger) *Observe {
+	return &Observe{
+		logger:       logger,
+		k8sService:   NewkubernetesService(cl, rcl, logger),
+		redisService: NewRedisService(rcl, logger),
+	}
+}
+
+func (o *Observe) ObserveStatus(cRedis *v1beta1.CustomRedis) error {
+	o.logger.V(1).Info("Observing cluster status")
+	if err := o.observeVolumes(cRedis); err != nil {
+		return err
+	}
+
+	pods, err := o.k8sService.GetStatefulsetReadyPods(cRedis.Name, cRedis.Namespace)
+	if err != nil {
+		if apierror.IsNotFound(err) {
+			cRedis.Status.ReadyReplicas = 0
+			cRedis.Status.Master = ""
+			return nil
+		}
+		return err
+	}
+	cRedis.Status.ReadyReplicas = int32(len(pods))
+
+	masterPods, err := o.k8sService.GetMasterPods(cRedis)
+	if err != nil {
+		return err
+	}
+	// 没有 master 或存在多个 master 时置空
+	cRedis.Status.Master = ""
+	if len(masterPods) == 1 {
+		cRedis.Status.Master = masterPods[0].Name
+	}
+
+	return o.observeStandby(cRedis, pods)
+}
+
+// observeStandby 刷新备用 Pod 与外部 master 的复制链路状态
+func (o *Observe) observeStandby(cRedis *v1beta1.CustomRedis, pods []corev1.Pod) error {
+	standby := cRedis.Status.Standby
+	if standby == nil || !cRedis.IsStandby() {
+		return nil
+	}
+
+	standby.LinkStatus = ""
+	for i := range pods {
+		if pods[i].Name != standby.Pod {
+			continue
+		}
+		replication, err := o.redisService.GetReplication(cRedis, pods[i].Status.PodIP)
+		if err != nil {
+			return err
+		}
+		standby.LinkStatus = replication.MasterLinkStatus
+	}
+	return nil
+}
+
+// 一组由 statefulset volumeClaimTemplate 创建的 PVC
+type volumeClaims struct {
+	stsName  string
+	replicas int32
+	template string
+	claim    *corev1.PersistentVolumeClaimSpec
+}
+
+// observeVolumes 记录每个 PVC 的大小与扩容进度
+func (o *Observe) observeVolumes(cRedis *v1beta1.CustomRedis) error {
+	cRedis.Status.Volumes = nil
+
+	claims := []volumeClaims{
+		{stsName: cRedis.Name, replicas: *cRedis.Spec.Replicas, template: pvcNamePrefix, claim: cRedis.DataVolumeClaim()},
+		{stsName: cRedis.Name, replicas: *cRedis.Spec.Replicas, template: aofVolumeName, claim: cRedis.AofVolumeClaim()},
+	}
+	if cRedis.Spec.ClusterMode == v1beta1.Sentinel {
+		claims = append(claims, volumeClaims{
+			stsName:  fmt.Sprintf("%s-%s", cRedis.Name, util.SentinelResourceSuffix),
+			replicas: *cRedis.Spec.SentinelNum,
+			template: pvcNamePrefix,
+			claim:    cRedis.SentinelVolumeClaim(),
+		})
+	}
+
+	for _, c := range claims {
+		if c.claim == nil {
+			continue
+		}
+		requested, ok := c.claim.Resources.Requests[corev1.ResourceStorage]
+		if !ok {
+			continue
+		}
+
+		for i := 0; i < int(c.replicas); i++ {
+			pvc, err := o.k8sService.GetPersistentVolumeClaim(volumeClaimName(c.template, c.stsName, i), cRedis.Namespace)
+			if err != nil {
+				if apierror.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+
+			volume := v1beta1.VolumeStatus{
+				Pod:       fmt.Sprintf("%s-%d", c.stsName, i),
+				ClaimName: pvc.Name,
+				Requested: requested,
+				Capacity:  pvc.Status.Capacity[corev1.ResourceStorage],
+				Phase:     volumeResizePhase(pvc, requested),
+			}
+			if volume.Phase == v1beta1.VolumeResizePending {
+				storageClassName := ""
+				if pvc.Spec.StorageClassName != nil {
+					storageClassName = *pvc.Spec.StorageClassName
+				}
+				allowed, err := o.k8sService.IsVolumeExpansionAllowed(storageClassName)
+				if err != nil {
+					return err
+				}
+				if !allowed {
+					volume.Phase = v1beta1.VolumeResizeUnsupported
+					volume.Message = fmt.Sprintf("storage class %q does not allow volume expansion", storageClassName)
+				}
+			}
+			cRedis.Status.Volumes = append(cRedis.Status.Volumes, volume)
+		}
+	}
+
+	sort.Slice(cRedis.Status.Volumes, func(i, j int) bool {
+		return cRedis.Status.Volumes[i].ClaimName < cRedis.Status.Volumes[j].ClaimName
+	})
+	return nil
+}
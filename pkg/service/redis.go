@@ -1,32 +1,49 @@
 package service
 
 import (
+	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/hongqchen/redis-operator/api/v1beta1"
 	"github.com/hongqchen/redis-operator/pkg/client/redis"
+	"github.com/hongqchen/redis-operator/pkg/util"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
-	"regexp"
+	"net"
 	"sort"
 	"strconv"
-	"strings"
-)
-
-var (
-	replicationOfMasterHostRE = regexp.MustCompile("master_host:([0-9.]+)")
+	"time"
 )
 
 var _ RedisServicer = (*RedisService)(nil)
 
 type RedisServicer interface {
 	// 直接与 redis client 交互
-	GetReplication(cRedis *v1beta1.CustomRedis, ip string) (string, error)
+	GetInfo(cRedis *v1beta1.CustomRedis, ip string) (*redis.Info, error)
+	GetReplication(cRedis *v1beta1.CustomRedis, ip string) (*redis.ReplicationInfo, error)
 	GetSentinelMonitor(cRedis *v1beta1.CustomRedis, sentienlIP string) (string, string, error)
+	GetSentinelMaster(cRedis *v1beta1.CustomRedis, sentinelIP string) (*redis.SentinelMaster, error)
 	SetAsMaster(cRedis *v1beta1.CustomRedis, ip string) error
-	SetAsSlave(cRedis *v1beta1.CustomRedis, slaveIP, masterIP string) error
-	SetSentinelMonitor(cRedis *v1beta1.CustomRedis, sentinelIP, masterIP string) error
+	SetAsSlave(cRedis *v1beta1.CustomRedis, slaveIP, masterHost string) error
+	ReplicateFrom(cRedis *v1beta1.CustomRedis, ip, masterHost string, masterPort int32) error
+	SetSentinelMonitor(cRedis *v1beta1.CustomRedis, sentinelIP, masterHost string, masterPort int32) error
+	RemoveSentinelMonitor(cRedis *v1beta1.CustomRedis, sentinelIP string) error
+	SetSentinelQuorum(cRedis *v1beta1.CustomRedis, sentinelIP string) error
+	SetReplicaAnnounce(cRedis *v1beta1.CustomRedis, ip, announceIP string, announcePort int32) error
+	SetSentinelAnnounce(cRedis *v1beta1.CustomRedis, sentinelIP, announceIP string, announcePort int32) error
+	GetSentinelPeers(cRedis *v1beta1.CustomRedis, sentinelIP string) ([]map[string]string, error)
+	GetSentinelReplicas(cRedis *v1beta1.CustomRedis, sentinelIP string) ([]map[string]string, error)
+	CheckSentinelQuorum(cRedis *v1beta1.CustomRedis, sentinelIP string) (bool, string, error)
+	ResetSentinel(cRedis *v1beta1.CustomRedis, sentinelIP string) error
+	SentinelFailover(cRedis *v1beta1.CustomRedis, sentinelIP string) error
+	PauseWrites(cRedis *v1beta1.CustomRedis, ip string, timeout time.Duration) error
+	UnpauseWrites(cRedis *v1beta1.CustomRedis, ip string) error
+	ListModules(cRedis *v1beta1.CustomRedis, ip string) ([]redis.Module, error)
+	RestoreKeys(cRedis *v1beta1.CustomRedis, ip string, keys []redis.DumpedKey) error
+	GetConfig(cRedis *v1beta1.CustomRedis, ip, parameter string) (string, error)
+	SetConfig(cRedis *v1beta1.CustomRedis, ip, parameter, value string) error
 
 	GetReplicationOfMasterHost(cRedis *v1beta1.CustomRedis, ip string) (string, error)
+	GetReplicationOffset(cRedis *v1beta1.CustomRedis, ip string) (int64, error)
 	IsMaster(cRedis *v1beta1.CustomRedis, ip string) (bool, error)
 
 	SetOldestAsMaster(cRedis *v1beta1.CustomRedis, pods []corev1.Pod) error
@@ -38,21 +55,36 @@ type RedisService struct {
 	client redis.Clienter
 }
 
-func NewRedisService(logger logr.Logger) *RedisService {
+func NewRedisService(rcl redis.Clienter, logger logr.Logger) *RedisService {
 	return &RedisService{
 		logger: logger,
-		client: redis.NewClient(),
+		client: rcl,
 	}
 }
 
-func (rs *RedisService) GetReplication(cRedis *v1beta1.CustomRedis, ip string) (string, error) {
+func (rs *RedisService) GetInfo(cRedis *v1beta1.CustomRedis, ip string) (*redis.Info, error) {
+	rs.logger.V(1).Info("Getting info", "currentIP", ip)
+	port, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.client.GetInfo(ip, port, password, "")
+}
+
+func (rs *RedisService) GetReplication(cRedis *v1beta1.CustomRedis, ip string) (*redis.ReplicationInfo, error) {
 	rs.logger.V(1).Info("Getting replication info", "currentIP", ip)
 	port, password, err := rs.getPortAndPassword(cRedis)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return rs.client.GetReplication(ip, port, password)
+	info, err := rs.client.GetInfo(ip, port, password, "replication")
+	if err != nil {
+		return nil, err
+	}
+
+	return &info.Replication, nil
 }
 
 func (rs *RedisService) IsMaster(cRedis *v1beta1.CustomRedis, ip string) (bool, error) {
@@ -62,7 +94,7 @@ func (rs *RedisService) IsMaster(cRedis *v1beta1.CustomRedis, ip string) (bool,
 		return false, err
 	}
 
-	return strings.Contains(replication, "role:master"), nil
+	return replication.IsMaster(), nil
 }
 
 func (rs *RedisService) SetAsMaster(cRedis *v1beta1.CustomRedis, ip string) error {
@@ -75,14 +107,25 @@ func (rs *RedisService) SetAsMaster(cRedis *v1beta1.CustomRedis, ip string) erro
 	return rs.client.SetAsMaster(ip, port, password)
 }
 
-func (rs *RedisService) SetAsSlave(cRedis *v1beta1.CustomRedis, slaveIP, masterIP string) error {
-	rs.logger.V(1).Info("Setting as slave", "currentIP", slaveIP, "masterIP", masterIP)
+func (rs *RedisService) SetAsSlave(cRedis *v1beta1.CustomRedis, slaveIP, masterHost string) error {
+	rs.logger.V(1).Info("Setting as slave", "currentIP", slaveIP, "masterHost", masterHost)
+	port, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return err
+	}
+
+	return rs.client.SetAsSlave(slaveIP, masterHost, port, password)
+}
+
+// Replicate from a master outside the cluster, which may listen on another port
+func (rs *RedisService) ReplicateFrom(cRedis *v1beta1.CustomRedis, ip, masterHost string, masterPort int32) error {
+	rs.logger.V(1).Info("Replicating from external master", "currentIP", ip, "masterHost", masterHost, "masterPort", masterPort)
 	port, password, err := rs.getPortAndPassword(cRedis)
 	if err != nil {
 		return err
 	}
 
-	return rs.client.SetAsSlave(slaveIP, masterIP, port, password)
+	return rs.client.ReplicateFrom(ip, port, password, masterHost, masterPort)
 }
 
 // Set the pod with the longest creation time as the master
@@ -98,22 +141,23 @@ func (rs *RedisService) SetOldestAsMaster(cRedis *v1beta1.CustomRedis, pods []co
 		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
 	})
 
-	masterIP := ""
-	for _, pod := range pods {
+	masterHost := ""
+	for i := range pods {
+		pod := &pods[i]
 		// Check that the pod is ready, otherwise ignore it
 		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
 			continue
 		}
 
-		if masterIP == "" {
+		if masterHost == "" {
 			// set as master node
-			masterIP = pod.Status.PodIP
-			if err := rs.SetAsMaster(cRedis, masterIP); err != nil {
+			masterHost = getRedisHost(cRedis, pod)
+			if err := rs.SetAsMaster(cRedis, pod.Status.PodIP); err != nil {
 				return err
 			}
 		} else {
 			// set as slave node
-			if err := rs.SetAsSlave(cRedis, pod.Status.PodIP, masterIP); err != nil {
+			if err := rs.SetAsSlave(cRedis, pod.Status.PodIP, masterHost); err != nil {
 				return err
 			}
 		}
@@ -151,35 +195,251 @@ func (rs *RedisService) GetSentinelMonitor(cRedis *v1beta1.CustomRedis, sentienl
 	return rs.client.GetSentinelMonitor(sentienlIP, password)
 }
 
-func (rs *RedisService) SetSentinelMonitor(cRedis *v1beta1.CustomRedis, sentinelIP, masterIP string) error {
-	rs.logger.V(1).Info("Setting the monitor for sentinel nodes", "sentinelIP", sentinelIP, "masterIP", masterIP)
-	port, password, err := rs.getPortAndPassword(cRedis)
+func (rs *RedisService) GetSentinelMaster(cRedis *v1beta1.CustomRedis, sentinelIP string) (*redis.SentinelMaster, error) {
+	rs.logger.V(1).Info("Getting sentinel master state", "sentinelIP", sentinelIP)
+	_, password, _ := rs.getPortAndPassword(cRedis)
+	return rs.client.GetSentinelMaster(sentinelIP, password)
+}
+
+func (rs *RedisService) SetSentinelMonitor(cRedis *v1beta1.CustomRedis, sentinelIP, masterHost string, masterPort int32) error {
+	rs.logger.V(1).Info("Setting the monitor for sentinel nodes", "sentinelIP", sentinelIP, "masterHost", masterHost, "masterPort", masterPort)
+	_, password, err := rs.getPortAndPassword(cRedis)
 	if err != nil {
 		return err
 	}
-	quorum := strconv.Itoa(int(*cRedis.Spec.SentinelNum)/2 + 1)
 
 	monitor := map[string]interface{}{
-		"masterIP": masterIP,
-		"port":     port,
-		"quorum":   quorum,
+		"masterIP": masterHost,
+		"port":     masterPort,
+		"quorum":   strconv.Itoa(sentinelQuorum(cRedis)),
 	}
 	return rs.client.SetSentinelMonitor(sentinelIP, password, monitor)
 }
 
+func (rs *RedisService) RemoveSentinelMonitor(cRedis *v1beta1.CustomRedis, sentinelIP string) error {
+	rs.logger.V(1).Info("Removing the monitor of sentinel nodes", "sentinelIP", sentinelIP)
+	_, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return err
+	}
+	return rs.client.RemoveSentinelMonitor(sentinelIP, password)
+}
+
+// SetSentinelQuorum 通过 SENTINEL SET 修改 quorum，不影响 sentinel 已知的 replica 与其他 sentinel
+func (rs *RedisService) SetSentinelQuorum(cRedis *v1beta1.CustomRedis, sentinelIP string) error {
+	quorum := sentinelQuorum(cRedis)
+	rs.logger.V(1).Info("Setting sentinel quorum", "sentinelIP", sentinelIP, "quorum", quorum)
+	_, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return err
+	}
+	return rs.client.SetSentinelOption(sentinelIP, password, "quorum", strconv.Itoa(quorum))
+}
+
+// Make the replica announce an address reachable from outside the cluster,
+// only the running instance is changed, so it has to be applied again after a restart
+func (rs *RedisService) SetReplicaAnnounce(cRedis *v1beta1.CustomRedis, ip, announceIP string, announcePort int32) error {
+	rs.logger.V(1).Info("Setting replica announce address", "currentIP", ip, "announceIP", announceIP, "announcePort", announcePort)
+	port, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return err
+	}
+
+	announce := map[string]string{
+		"replica-announce-ip":   announceIP,
+		"replica-announce-port": strconv.Itoa(int(announcePort)),
+	}
+	for parameter, value := range announce {
+		stored, err := rs.client.GetConfig(ip, port, password, parameter)
+		if err != nil {
+			return err
+		}
+		if stored == value {
+			continue
+		}
+		if err := rs.client.SetConfig(ip, port, password, parameter, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rs *RedisService) SetSentinelAnnounce(cRedis *v1beta1.CustomRedis, sentinelIP, announceIP string, announcePort int32) error {
+	rs.logger.V(1).Info("Setting sentinel announce address", "sentinelIP", sentinelIP, "announceIP", announceIP, "announcePort", announcePort)
+	_, password, _ := rs.getPortAndPassword(cRedis)
+
+	if err := rs.client.SetSentinelConfig(sentinelIP, password, "announce-ip", announceIP); err != nil {
+		return err
+	}
+	return rs.client.SetSentinelConfig(sentinelIP, password, "announce-port", strconv.Itoa(int(announcePort)))
+}
+
+func (rs *RedisService) GetSentinelPeers(cRedis *v1beta1.CustomRedis, sentinelIP string) ([]map[string]string, error) {
+	rs.logger.V(1).Info("Getting the other sentinels known by sentinel", "sentinelIP", sentinelIP)
+	_, password, _ := rs.getPortAndPassword(cRedis)
+	return rs.client.GetSentinelPeers(sentinelIP, password)
+}
+
+func (rs *RedisService) GetSentinelReplicas(cRedis *v1beta1.CustomRedis, sentinelIP string) ([]map[string]string, error) {
+	rs.logger.V(1).Info("Getting sentinel replicas", "sentinelIP", sentinelIP)
+	_, password, _ := rs.getPortAndPassword(cRedis)
+	return rs.client.GetSentinelReplicas(sentinelIP, password)
+}
+
+func (rs *RedisService) CheckSentinelQuorum(cRedis *v1beta1.CustomRedis, sentinelIP string) (bool, string, error) {
+	rs.logger.V(1).Info("Checking sentinel quorum", "sentinelIP", sentinelIP)
+	_, password, _ := rs.getPortAndPassword(cRedis)
+	return rs.client.CheckSentinelQuorum(sentinelIP, password)
+}
+
+func (rs *RedisService) ResetSentinel(cRedis *v1beta1.CustomRedis, sentinelIP string) error {
+	rs.logger.V(1).Info("Resetting sentinel", "sentinelIP", sentinelIP)
+	_, password, _ := rs.getPortAndPassword(cRedis)
+	return rs.client.ResetSentinel(sentinelIP, password)
+}
+
+func (rs *RedisService) SentinelFailover(cRedis *v1beta1.CustomRedis, sentinelIP string) error {
+	rs.logger.V(1).Info("Forcing sentinel failover", "sentinelIP", sentinelIP)
+	_, password, _ := rs.getPortAndPassword(cRedis)
+	return rs.client.SentinelFailover(sentinelIP, password)
+}
+
+func (rs *RedisService) PauseWrites(cRedis *v1beta1.CustomRedis, ip string, timeout time.Duration) error {
+	rs.logger.V(1).Info("Pausing writes", "currentIP", ip, "timeout", timeout)
+	port, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return err
+	}
+
+	return rs.client.PauseWrites(ip, port, password, timeout)
+}
+
+func (rs *RedisService) UnpauseWrites(cRedis *v1beta1.CustomRedis, ip string) error {
+	rs.logger.V(1).Info("Unpausing writes", "currentIP", ip)
+	port, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return err
+	}
+
+	return rs.client.UnpauseWrites(ip, port, password)
+}
+
+func (rs *RedisService) ListModules(cRedis *v1beta1.CustomRedis, ip string) ([]redis.Module, error) {
+	rs.logger.V(1).Info("Listing modules", "currentIP", ip)
+	port, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return nil, err
+	}
+
+	return rs.client.ListModules(ip, port, password)
+}
+
+func (rs *RedisService) RestoreKeys(cRedis *v1beta1.CustomRedis, ip string, keys []redis.DumpedKey) error {
+	rs.logger.V(1).Info("Restoring keys", "currentIP", ip, "keys", len(keys))
+	port, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return err
+	}
+
+	return rs.client.RestoreKeys(ip, port, password, keys)
+}
+
+func (rs *RedisService) GetConfig(cRedis *v1beta1.CustomRedis, ip, parameter string) (string, error) {
+	rs.logger.V(1).Info("Getting config", "currentIP", ip, "parameter", parameter)
+	port, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return "", err
+	}
+
+	return rs.client.GetConfig(ip, port, password, parameter)
+}
+
+func (rs *RedisService) SetConfig(cRedis *v1beta1.CustomRedis, ip, parameter, value string) error {
+	rs.logger.V(1).Info("Setting config", "currentIP", ip, "parameter", parameter, "value", value)
+	port, password, err := rs.getPortAndPassword(cRedis)
+	if err != nil {
+		return err
+	}
+
+	return rs.client.SetConfig(ip, port, password, parameter, value)
+}
+
 func (rs *RedisService) GetReplicationOfMasterHost(cRedis *v1beta1.CustomRedis, ip string) (string, error) {
-	rs.logger.V(1).Info("Getting the masterIP of the sentinel node", "sentinelIP", ip)
+	rs.logger.V(1).Info("Getting the master host of the redis node", "currentIP", ip)
 	replication, err := rs.GetReplication(cRedis, ip)
 	if err != nil {
 		return "", err
 	}
 
-	matchRes := replicationOfMasterHostRE.FindStringSubmatch(replication)
-	if len(matchRes) == 0 {
+	if replication.IsMaster() {
 		return "", nil
 	}
 
-	return matchRes[1], nil
+	return replication.MasterHost, nil
+}
+
+// Get the replication offset, for a slave it is the offset it has processed from its master
+func (rs *RedisService) GetReplicationOffset(cRedis *v1beta1.CustomRedis, ip string) (int64, error) {
+	rs.logger.V(1).Info("Getting replication offset", "currentIP", ip)
+	replication, err := rs.GetReplication(cRedis, ip)
+	if err != nil {
+		return 0, err
+	}
+
+	return replication.ProcessedOffset(), nil
+}
+
+// getRedisHost returns the address other nodes use to replicate from the pod.
+// Pods created behind the headless service use their stable DNS name, pods created
+// by older versions of the operator have no subdomain and still use the pod IP.
+func getRedisHost(cRedis *v1beta1.CustomRedis, pod *corev1.Pod) string {
+	headlessName := fmt.Sprintf("%s-%s", cRedis.Name, util.HeadlessServiceSuffix)
+	if pod.Spec.Subdomain != headlessName {
+		return pod.Status.PodIP
+	}
+
+	return util.GetPodFQDN(pod.Name, headlessName, pod.Namespace)
+}
+
+// getRedisAnnounceAddr returns the address the pod announces to sentinels and clients,
+// that is the external address of its per-pod service once it has been applied.
+func getRedisAnnounceAddr(cRedis *v1beta1.CustomRedis, pod *corev1.Pod) (string, int32) {
+	if cRedis.IsPerPodServiceEnabled() {
+		announceIP := pod.Annotations[util.AnnounceIPAnnotation]
+		announcePort, err := strconv.Atoi(pod.Annotations[util.AnnouncePortAnnotation])
+		if announceIP != "" && err == nil {
+			return announceIP, int32(announcePort)
+		}
+	}
+
+	port, _ := strconv.Atoi(cRedis.Spec.RedisConfig["port"])
+	return getRedisHost(cRedis, pod), int32(port)
+}
+
+// sentinelQuorum 多数 sentinel 同意才认为 master 客观下线
+func sentinelQuorum(cRedis *v1beta1.CustomRedis) int {
+	return int(*cRedis.Spec.SentinelNum)/2 + 1
+}
+
+// isRedisHost reports whether host, as seen by a slave or a sentinel, refers to the pod.
+// Dual-stack pods may be known by any of their IPs.
+func isRedisHost(cRedis *v1beta1.CustomRedis, pod *corev1.Pod, host string) bool {
+	if host == getRedisHost(cRedis, pod) || host == pod.Status.PodIP {
+		return true
+	}
+
+	if announceIP, _ := getRedisAnnounceAddr(cRedis, pod); host == announceIP {
+		return true
+	}
+
+	for _, podIP := range pod.Status.PodIPs {
+		if net.ParseIP(host).Equal(net.ParseIP(podIP.IP)) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (rs *RedisService) getPortAndPassword(cRedis *v1beta1.CustomRedis) (int32, string, error) {
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"fmt"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"path"
+)
+
+const (
+	// redis 数据卷与 sentinel 数据卷在 volumeClaimTemplates 中的名称
+	pvcNamePrefix = "pvc"
+	// redis AOF 独立数据卷在 volumeClaimTemplates 中的名称
+	aofVolumeName = "pvc-aof"
+	// redis 7 appenddirname 的默认值
+	defaultAppendDirName = "appendonlydir"
+)
+
+// claimStorage 返回 statefulset 中名为 template 的 volumeClaimTemplate 请求的存储大小，不存在时返回 nil
+func claimStorage(sts *appv1.StatefulSet, template string) *resource.Quantity {
+	for _, claim := range sts.Spec.VolumeClaimTemplates {
+		if claim.Name != template {
+			continue
+		}
+		if storage, ok := claim.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+			return &storage
+		}
+	}
+	return nil
+}
+
+// sameClaimTemplates 两个 statefulset 的 volumeClaimTemplates 名称是否一致
+func sameClaimTemplates(sts, storedSts *appv1.StatefulSet) bool {
+	if len(sts.Spec.VolumeClaimTemplates) != len(storedSts.Spec.VolumeClaimTemplates) {
+		return false
+	}
+	for _, template := range sts.Spec.VolumeClaimTemplates {
+		found := false
+		for _, storedTemplate := range storedSts.Spec.VolumeClaimTemplates {
+			if storedTemplate.Name == template.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// volumeClaimName statefulset 为每个 Pod 创建的 PVC 名称，格式为 <template>-<statefulset>-<ordinal>
+func volumeClaimName(template, stsName string, ordinal int) string {
+	return fmt.Sprintf("%s-%s-%d", template, stsName, ordinal)
+}
+
+// aofDirectory AOF 文件所在目录，redis 7 起 AOF 文件存放在数据目录下的 appenddirname 目录中
+func aofDirectory(cRedis *v1beta1.CustomRedis) string {
+	appendDirName := cRedis.Spec.RedisConfig["appenddirname"]
+	if appendDirName == "" {
+		appendDirName = defaultAppendDirName
+	}
+	return path.Join(cRedis.Spec.RedisConfig["dir"], appendDirName)
+}
+
+// volumeResizePhase 根据 PVC 的请求大小、实际容量与 condition 判断扩容进度
+func volumeResizePhase(pvc *corev1.PersistentVolumeClaim, requested resource.Quantity) v1beta1.VolumeResizePhase {
+	capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+	if capacity.Cmp(requested) >= 0 {
+		return v1beta1.VolumeResized
+	}
+	if claimed := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; claimed.Cmp(requested) < 0 {
+		return v1beta1.VolumeResizePending
+	}
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Type == corev1.PersistentVolumeClaimFileSystemResizePending && condition.Status == corev1.ConditionTrue {
+			return v1beta1.VolumeFileSystemResizePending
+		}
+	}
+	return v1beta1.VolumeResizing
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+	"github.com/hongqchen/redis-operator/api/v1beta1"
+	"github.com/hongqchen/redis-operator/pkg/util"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"testing"
+)
+
+func TestEnsureVolumeExpansion(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowExpansion bool
+		wantRecreated  bool
+		wantClaimed    string
+		wantPhase      v1beta1.VolumeResizePhase
+	}{
+		{
+			name:           "storage class allows expansion",
+			allowExpansion: true,
+			wantRecreated:  true,
+			wantClaimed:    "2Gi",
+			wantPhase:      v1beta1.VolumeResizing,
+		},
+		{
+			name:        "storage class does not allow expansion",
+			wantClaimed: "1Gi",
+			wantPhase:   v1beta1.VolumeResizeUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestCluster(t, v1beta1.MasterSlave, util.CustomRedisRunning)
+			tc.replicate(t, 0)
+			ctx := context.TODO()
+			storageClassName := "standard"
+			tc.cRedis.Spec.VolumeConfig = &corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClassName,
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+				},
+			}
+
+			// the statefulset and its claims were created with 1Gi
+			sts := &appv1.StatefulSet{}
+			if err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: "redis", Namespace: "default"}, sts); err != nil {
+				t.Fatal(err)
+			}
+			sts.Spec.VolumeClaimTemplates = newGenerate().statefulset(tc.cRedis).Spec.VolumeClaimTemplates
+			if err := tc.k8sClient.Update(ctx, sts); err != nil {
+				t.Fatal(err)
+			}
+			allowExpansion := tt.allowExpansion
+			if err := tc.k8sClient.Create(ctx, &storagev1.StorageClass{
+				ObjectMeta:           metav1.ObjectMeta{Name: storageClassName},
+				Provisioner:          "example.com/csi",
+				AllowVolumeExpansion: &allowExpansion,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				pvc := &corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: volumeClaimName(pvcNamePrefix, "redis", i), Namespace: "default"},
+					Spec:       *tc.cRedis.Spec.VolumeConfig.DeepCopy(),
+					Status: corev1.PersistentVolumeClaimStatus{
+						Phase:    corev1.ClaimBound,
+						Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+					},
+				}
+				if err := tc.k8sClient.Create(ctx, pvc); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tc.cRedis.Spec.VolumeConfig.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("2Gi")
+			if err := tc.ensure().EnsureStatefulset(tc.cRedis); err != nil {
+				t.Fatalf("EnsureStatefulset() error = %v", err)
+			}
+
+			err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: "redis", Namespace: "default"}, &appv1.StatefulSet{})
+			if recreated := apierror.IsNotFound(err); recreated != tt.wantRecreated {
+				t.Fatalf("statefulset deleted = %v, want %v", recreated, tt.wantRecreated)
+			}
+			for i := 0; i < 3; i++ {
+				pvc := &corev1.PersistentVolumeClaim{}
+				if err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: volumeClaimName(pvcNamePrefix, "redis", i), Namespace: "default"}, pvc); err != nil {
+					t.Fatal(err)
+				}
+				if claimed := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; claimed.String() != tt.wantClaimed {
+					t.Errorf("pvc %s requests %s, want %s", pvc.Name, claimed.String(), tt.wantClaimed)
+				}
+			}
+
+			if err := NewObserve(tc.k8sClient, tc.redis, logr.Discard()).ObserveStatus(tc.cRedis); err != nil {
+				t.Fatalf("ObserveStatus() error = %v", err)
+			}
+			if len(tc.cRedis.Status.Volumes) != 3 {
+				t.Fatalf("status.volumes = %+v, want one entry per pod", tc.cRedis.Status.Volumes)
+			}
+			for _, volume := range tc.cRedis.Status.Volumes {
+				if volume.Phase != tt.wantPhase || volume.Capacity.String() != "1Gi" || volume.Requested.String() != "2Gi" {
+					t.Errorf("volume of %s is %s with %s of %s, want %s", volume.Pod, volume.Phase, volume.Capacity.String(), volume.Requested.String(), tt.wantPhase)
+				}
+			}
+			if resizing := tc.cRedis.IsVolumeResizing(); resizing != tt.allowExpansion {
+				t.Errorf("IsVolumeResizing() = %v, want %v", resizing, tt.allowExpansion)
+			}
+			if !tt.wantRecreated {
+				return
+			}
+
+			// the next reconcile recreates the statefulset with the new template
+			if err := tc.ensure().EnsureStatefulset(tc.cRedis); err != nil {
+				t.Fatalf("EnsureStatefulset() error = %v", err)
+			}
+			sts = &appv1.StatefulSet{}
+			if err := tc.k8sClient.Get(ctx, types.NamespacedName{Name: "redis", Namespace: "default"}, sts); err != nil {
+				t.Fatal(err)
+			}
+			if storage := claimStorage(sts, pvcNamePrefix); storage == nil || storage.String() != "2Gi" {
+				t.Errorf("volume claim template requests %v, want 2Gi", storage)
+			}
+		})
+	}
+}
+
+func TestStatefulsetStorage(t *testing.T) {
+	tc := newTestCluster(t, v1beta1.Sentinel, util.CustomRedisRunning)
+	tc.replicate(t, 0)
+	tc.cRedis.Spec.RedisConfig["dir"] = "/data"
+	fast := "fast-ssd"
+	tc.cRedis.Spec.VolumeConfig = &corev1.PersistentVolumeClaimSpec{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("5Gi")},
+		},
+	}
+	tc.cRedis.Spec.Storage = &v1beta1.StorageConfig{
+		Aof:      &v1beta1.VolumeSpec{Size: resource.MustParse("10Gi"), StorageClassName: &fast},
+		Sentinel: &v1beta1.VolumeSpec{Size: resource.MustParse("64Mi")},
+	}
+
+	g := newGenerate()
+	sts := g.statefulset(tc.cRedis)
+	// the deprecated volumeConfig is still used for the data volume
+	if storage := claimStorage(sts, pvcNamePrefix); storage == nil || storage.String() != "5Gi" {
+		t.Errorf("data volume requests %v, want 5Gi", storage)
+	}
+	if storage := claimStorage(sts, aofVolumeName); storage == nil || storage.String() != "10Gi" {
+		t.Errorf("aof volume requests %v, want 10Gi", storage)
+	}
+	mounts := map[string]string{}
+	for _, mount := range sts.Spec.Template.Spec.Containers[0].VolumeMounts {
+		mounts[mount.Name] = mount.MountPath
+	}
+	if mounts[pvcNamePrefix] != "/data" || mounts[aofVolumeName] != "/data/appendonlydir" {
+		t.Errorf("volume mounts = %v, want data at /data and aof at /data/appendonlydir", mounts)
+	}
+	if conf := g.configmap(tc.cRedis).Data[util.RedisConfigFileName]; !strings.Contains(conf, "appendonly yes\n") {
+		t.Errorf("redis.conf does not enable appendonly:\n%s", conf)
+	}
+	sentinelSts := g.statefulsetForSentinel(tc.cRedis)
+	if storage := claimStorage(sentinelSts, pvcNamePrefix); storage == nil || storage.String() != "64Mi" {
+		t.Errorf("sentinel volume requests %v, want 64Mi", storage)
+	}
+
+	// volume claim templates are immutable, adding the aof volume recreates the statefulset
+	if err := tc.ensure().EnsureStatefulset(tc.cRedis); err != nil {
+		t.Fatalf("EnsureStatefulset() error = %v", err)
+	}
+	err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: "redis", Namespace: "default"}, &appv1.StatefulSet{})
+	if !apierror.IsNotFound(err) {
+		t.Errorf("statefulset not deleted after adding volumes, error = %v", err)
+	}
+}
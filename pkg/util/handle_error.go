@@ -1,35 +1,127 @@
 package util
 
 import (
-	"fmt"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sync"
 	"time"
 )
 
-func ErrorHandle(logger logr.Logger, err error) time.Duration {
-	if err == nil {
-		return 0
-	}
+type ErrorCategory string
+
+const (
+	// 资源创建中、Pod 启动中、sentinel 选举中等，集群会自行收敛
+	ErrorWaiting ErrorCategory = "Waiting"
+	// API server、redis 访问失败等临时错误
+	ErrorTransient ErrorCategory = "Transient"
+	// operator 无法自行修复，需要人工介入
+	ErrorNeedsHuman ErrorCategory = "NeedsHuman"
+)
 
-	// statefulset 未创建，入队，等待下一次 reconcile
-	if apierror.IsNotFound(err) {
-		fmt.Println(err.Error())
-		return 1 * time.Second
+// 重试间隔从 base 开始翻倍，不超过 max
+type backoffPolicy struct {
+	base time.Duration
+	max  time.Duration
+}
+
+var backoffPolicies = map[ErrorCategory]backoffPolicy{
+	ErrorWaiting:    {base: 2 * time.Second, max: time.Minute},
+	ErrorTransient:  {base: time.Second, max: 5 * time.Minute},
+	ErrorNeedsHuman: {base: time.Minute, max: 10 * time.Minute},
+}
+
+// 在重试间隔上增加最多 10% 的随机值，避免大量 CustomRedis 同时重试
+const backoffJitter = 0.1
+
+// ClassifyError 返回错误所属的类别，未知错误视为临时错误
+func ClassifyError(err error) ErrorCategory {
+	switch {
+	// statefulset 未创建，等待下一次 reconcile
+	case apierror.IsNotFound(err):
+		return ErrorWaiting
+	// pod is being created, or load balancer is being provisioned, or pod is being restarted with the new template
+	case errors.Is(err, AllPodReadyErr), errors.Is(err, ExternalAddressPendingErr), errors.Is(err, RollingUpdateErr):
+		return ErrorWaiting
+	case errors.Is(err, MasterBeElectingErr):
+		return ErrorWaiting
+	// master-slave 模式下 master 丢失或存在多个有数据的 master，以及 master 数据落后于 slave，需要人工选出 master
+	case errors.Is(err, NoMasterErr), errors.Is(err, ManyMastersErr), errors.Is(err, DeprecatedErr):
+		return ErrorNeedsHuman
+	// 模块名称与 MODULE LIST 不一致，需要修正 spec.modules 或模块镜像
+	case errors.Is(err, ModulesMismatchErr):
+		return ErrorNeedsHuman
+	// sentinel 未能选出新的 master，没有数据的 Pod 持续拒绝启动，需要人工处理
+	case errors.Is(err, EmptyMasterRefusedErr):
+		return ErrorNeedsHuman
+	// 迁移的目标集群被其他来源占用，需要人工确认
+	case errors.Is(err, MigrationConflictErr):
+		return ErrorNeedsHuman
+	default:
+		return ErrorTransient
 	}
+}
 
-	// pod is being created
-	if errors.Is(err, AllPodReadyErr) {
-		logger.Info("Reconcile failed", "message", err.Error(), "retryInterval", "20s")
-		return 20 * time.Second
+// Backoff 按 CustomRedis 记录同一类别的连续失败次数，计算指数退避的重试间隔
+type Backoff struct {
+	mu       sync.Mutex
+	failures map[string]backoffState
+}
+
+type backoffState struct {
+	category ErrorCategory
+	count    int
+}
+
+func NewBackoff() *Backoff {
+	return &Backoff{failures: make(map[string]backoffState)}
+}
+
+// Next 记录一次失败并返回下一次重试的间隔，错误类别变化时重新计数
+func (b *Backoff) Next(key string, category ErrorCategory) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.failures[key]
+	if state.category != category {
+		state = backoffState{category: category}
+	}
+	policy := backoffPolicies[category]
+	delay := policy.base
+	for i := 0; i < state.count && delay < policy.max; i++ {
+		delay *= 2
+	}
+	if delay > policy.max {
+		delay = policy.max
 	}
+	state.count++
+	b.failures[key] = state
 
-	if errors.Is(err, MasterBeElectingErr) || errors.Is(err, ManyMastersErr) || errors.Is(err, DeprecatedErr) {
-		logger.Info("Reconcile failed", "message", err.Error(), "retryInterval", "1min")
-		return 1 * time.Minute
+	return wait.Jitter(delay, backoffJitter)
+}
+
+// Forget reconcile 成功或 CustomRedis 被删除后清除失败记录
+func (b *Backoff) Forget(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, key)
+}
+
+// ErrorHandle 对错误分类并记录日志，返回错误类别与重试间隔，err 为 nil 时清除失败记录
+func ErrorHandle(logger logr.Logger, backoff *Backoff, key string, err error) (ErrorCategory, time.Duration) {
+	if err == nil {
+		backoff.Forget(key)
+		return "", 0
 	}
 
-	logger.Error(err, "Reconcile failed", "retryInterval", "30s")
-	return 30 * time.Second
+	category := ClassifyError(err)
+	retryInterval := backoff.Next(key, category)
+	switch category {
+	case ErrorWaiting:
+		logger.Info("Reconcile failed", "message", err.Error(), "category", category, "retryInterval", retryInterval.Round(time.Millisecond).String())
+	default:
+		logger.Error(err, "Reconcile failed", "category", category, "retryInterval", retryInterval.Round(time.Millisecond).String())
+	}
+	return category, retryInterval
 }
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"github.com/pkg/errors"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorCategory
+	}{
+		{err: apierror.NewNotFound(schema.GroupResource{Resource: "statefulsets"}, "redis"), want: ErrorWaiting},
+		{err: errors.Wrap(AllPodReadyErr, "redis"), want: ErrorWaiting},
+		{err: MasterBeElectingErr, want: ErrorWaiting},
+		{err: NoMasterErr, want: ErrorNeedsHuman},
+		{err: ManyMastersErr, want: ErrorNeedsHuman},
+		{err: errors.Wrap(ModulesMismatchErr, "pod redis-0"), want: ErrorNeedsHuman},
+		{err: errors.Wrap(MigrationConflictErr, "spec.replicaOf is 10.1.0.1:6379"), want: ErrorNeedsHuman},
+		{err: errors.Wrap(EmptyMasterRefusedErr, "pod redis-0 restarted 3 times"), want: ErrorNeedsHuman},
+		{err: errors.New("dial tcp 10.0.0.1:6379: connection refused"), want: ErrorTransient},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("ClassifyError(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := NewBackoff()
+	within := func(got, want time.Duration) bool {
+		return got >= want && got <= want+time.Duration(float64(want)*backoffJitter)
+	}
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := b.Next("default/a", ErrorTransient); !within(got, want) {
+			t.Errorf("Next() = %s, want %s plus jitter", got, want)
+		}
+	}
+	// other CustomRedis are tracked separately
+	if got := b.Next("default/b", ErrorTransient); !within(got, time.Second) {
+		t.Errorf("Next() for another CustomRedis = %s, want 1s plus jitter", got)
+	}
+	// a new category starts over from its own base
+	if got := b.Next("default/a", ErrorNeedsHuman); !within(got, time.Minute) {
+		t.Errorf("Next() after the category changed = %s, want 1m plus jitter", got)
+	}
+	for i := 0; i < 10; i++ {
+		b.Next("default/a", ErrorNeedsHuman)
+	}
+	if got := b.Next("default/a", ErrorNeedsHuman); !within(got, 10*time.Minute) {
+		t.Errorf("Next() = %s, want the 10m cap plus jitter", got)
+	}
+
+	b.Forget("default/a")
+	if got := b.Next("default/a", ErrorNeedsHuman); !within(got, time.Minute) {
+		t.Errorf("Next() after Forget() = %s, want 1m plus jitter", got)
+	}
+}
@@ -1,17 +1,53 @@
 package util
 
-import "github.com/pkg/errors"
+import (
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+)
 
 type CustomRedisPhase string
 
 const (
 	RedisConfigFileName  = "redis.conf"
 	RedisConfigMountPath = "/redis/cm"
+	// 经 init container 渲染后，redis 实际加载的配置文件所在目录
+	RedisConfigWritablePath = "/redis/conf"
+	// init container 从模块镜像中拷贝的 redis 模块所在目录
+	RedisModulesPath = "/redis/modules"
 
 	SentinelConfigFileName = "sentinel.conf"
 	SentinelResourceSuffix = "sentinel"
 	SentinelPort           = 26379
 
+	// sentinel 配置文件中 monitor 的占位地址，待 master 选出后替换
+	SentinelPlaceholderIPv4 = "127.0.0.1"
+	SentinelPlaceholderIPv6 = "::1"
+
+	// 数据目录为 emptyDir 时，启动前查找当前 master 的 init container
+	FindMasterContainerName = "find-redis-master"
+
+	HeadlessServiceSuffix = "headless"
+	ExternalServiceSuffix = "external"
+
+	// 开启 per-pod service 后，记录 Pod 对外宣告的地址
+	AnnounceIPAnnotation   = "redis.hongqchen/announce-ip"
+	AnnouncePortAnnotation = "redis.hongqchen/announce-port"
+
+	// slave 复制链路正常且延迟未超出限制时为 "true"，slave service 仅选择该值为 "true" 的 Pod
+	ReadEligibleLabel = "redis.hongqchen/read-eligible"
+
+	// 值为 "true" 时，operator 不再修改集群，仅刷新 status
+	PausedAnnotation = "redis.hongqchen/paused"
+
+	// status.conditions 类型
+	ConditionPaused = "Paused"
+	// 最近一次 reconcile 是否成功，失败时 reason 为错误类别
+	ConditionReconciled = "Reconciled"
+	// 集群处于 operator 无法自行修复的状态，可据此告警
+	ConditionNeedsHuman = "NeedsHuman"
+
 	CustomRedisFailed   CustomRedisPhase = "failed"
 	CustomRedisCreating CustomRedisPhase = "creating"
 	CustomRedisScaling  CustomRedisPhase = "scaling"
@@ -25,5 +61,30 @@ var (
 	ManyMastersErr      = errors.New("multiple masters exist")
 	UnknownErr          = errors.New("unknown error")
 	DeprecatedErr       = errors.New("deprecated master")
+	RollingUpdateErr    = errors.New("rolling update in progress")
+	ModulesMismatchErr  = errors.New("loaded modules do not match spec.modules")
+	// RedisMigration 的目标集群已通过 spec.replicaOf 复制其他实例，或正作为其他实例的备用集群
+	MigrationConflictErr = errors.New("destination is already replicating from another source")
+	// per-pod service 的外部地址尚未分配（如 LoadBalancer 正在创建）
+	ExternalAddressPendingErr = errors.New("external address of per-pod service is pending")
+	// 没有持久化数据的 Pod 找不到 master 且仍有 slave 复制自身，拒绝以空 master 启动
+	EmptyMasterRefusedErr = errors.New("pod without data refuses to start as master")
 	//ManyMonitorsOnSentinelErr = errors.New("sentinel cluster listens on several different masters")
 )
+
+// ClusterDomain Kubernetes 集群的 DNS 域名，由 --cluster-domain 参数设置
+var ClusterDomain = "cluster.local"
+
+// GetPodFQDN 返回 statefulset Pod 通过 headless service 获得的稳定 DNS 名称
+func GetPodFQDN(podName, serviceName, namespace string) string {
+	return fmt.Sprintf("%s.%s.%s.svc.%s", podName, serviceName, namespace, ClusterDomain)
+}
+
+// IsLoopbackHost 判断地址是否为回环地址（IPv4 或 IPv6），sentinel 使用回环地址作为 monitor 占位
+func IsLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}